@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// AnnualTrip is a business trip in the annual report. A trip is a drive or a group of drives.
+type AnnualTrip struct {
+	Date           string
+	StartAddress   string
+	EndAddress     string
+	StartOdometer  int
+	EndOdometer    int
+	Distance       float32
+	DistanceString string
+	Purpose        string
+	Amount         float64
+	AmountString   string
+	// MissingPurpose is set if the trip has no comment telling its purpose, which Skatteverket requires:
+	MissingPurpose bool
+}
+
+type AnnualMonth struct {
+	Name           string
+	Trips          int
+	Distance       float32
+	DistanceString string
+	Amount         float64
+	AmountString   string
+}
+
+type AnnualData struct {
+	Car  Car
+	Year int
+	// Driver is the driver whose trips are reported; the zero Driver if the trips of all drivers are:
+	Driver Driver
+	// Locale is the language of the report:
+	Locale string
+	// Unit is the unit of the distances and odometer readings, see units.go:
+	Unit                   string
+	Owner                  string
+	Trips                  []AnnualTrip
+	Months                 []AnnualMonth
+	MissingPurposes        int
+	Totals                 Totals
+	TotalDistanceString    string
+	BusinessDistance       float32
+	BusinessDistanceString string
+	Allowance              float64
+	AllowanceString        string
+}
+
+// getAnnualData collects the business trips of a car during the year, month by month, along
+// with the totals of the year and the mileage allowance of the business trips, at the rates
+// valid when each trip was made. Grouped drives are reported as one trip. Unless driver is
+// the zero Driver, only the trips of the driver are reported.
+func getAnnualData(car Car, year int, driver Driver, lang string) (AnnualData, error) {
+	data := AnnualData{
+		Car:    car,
+		Year:   year,
+		Driver: driver,
+		Locale: lang,
+		Unit:   carUnit(car.Id),
+		Owner:  config.Report.Owner,
+	}
+
+	categories, err := getCategoryMap()
+	if err != nil {
+		return data, err
+	}
+
+	from, to, err := exportPeriod(year, 0, "", "")
+	if err != nil {
+		return data, err
+	}
+
+	rows, err := getExportRows(car.Id, from, to, true, driver.Id, data.Unit, lang)
+	if err != nil {
+		return data, err
+	}
+
+	data.Totals, err = getTotals(year, 0, car.Id, driver.Id)
+	if err != nil {
+		return data, err
+	}
+	convertTotals(&data.Totals, data.Unit)
+
+	for _, m := range monthNames(lang) {
+		data.Months = append(data.Months, AnnualMonth{Name: m.Name})
+	}
+
+	for _, row := range rows {
+		if category, known := categories[row.Classification]; !known || !category.Deductible {
+			continue
+		}
+
+		t := AnnualTrip{
+			Date:           convertTime(row.StartDate).Format("2006-01-02"),
+			StartAddress:   row.StartAddress,
+			EndAddress:     row.EndAddress,
+			StartOdometer:  row.StartOdometer,
+			EndOdometer:    row.EndOdometer,
+			Distance:       row.Distance,
+			DistanceString: fmt.Sprintf("%.1f", row.Distance),
+			Purpose:        row.Comment,
+			Amount:         row.Reimbursement,
+			AmountString:   formatAmount(row.Reimbursement, "."),
+			MissingPurpose: strings.TrimSpace(row.Comment) == "",
+		}
+
+		if t.MissingPurpose {
+			data.MissingPurposes++
+		}
+
+		data.Trips = append(data.Trips, t)
+		data.BusinessDistance += row.Distance
+		data.Allowance += row.Reimbursement
+
+		m := &data.Months[convertTime(row.StartDate).Month()-1]
+		m.Trips++
+		m.Distance += row.Distance
+		m.Amount += row.Reimbursement
+	}
+
+	for i := range data.Months {
+		data.Months[i].DistanceString = fmt.Sprintf("%.1f", data.Months[i].Distance)
+		data.Months[i].AmountString = formatAmount(data.Months[i].Amount, ".")
+	}
+
+	data.TotalDistanceString = fmt.Sprintf("%.1f", data.Totals.TotalDistance)
+	data.BusinessDistanceString = fmt.Sprintf("%.1f", data.BusinessDistance)
+	data.AllowanceString = formatAmount(data.Allowance, ".")
+
+	return data, nil
+}
+
+// writeAnnualCSV writes the business trips of the year followed by the total business
+// distance and mileage allowance.
+func writeAnnualCSV(out io.Writer, data AnnualData, options ExportOptions) error {
+	w := csv.NewWriter(out)
+	w.Comma = options.Delimiter
+	w.UseCRLF = true
+
+	var header []string
+	for _, key := range []string{"date", "odometer_start_full", "odometer_end_full", "from", "to", "purpose", "distance_" + data.Unit, "reimbursement_kr", "remark"} {
+		header = append(header, translate(data.Locale, key))
+	}
+
+	err := w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range data.Trips {
+		var remark string
+		if t.MissingPurpose {
+			remark = translate(data.Locale, "purpose_missing")
+		}
+
+		err = w.Write([]string{
+			t.Date,
+			strconv.Itoa(t.StartOdometer),
+			strconv.Itoa(t.EndOdometer),
+			t.StartAddress,
+			t.EndAddress,
+			t.Purpose,
+			formatDecimal(t.Distance, options.DecimalSeparator),
+			formatAmount(t.Amount, options.DecimalSeparator),
+			remark,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Write([]string{translate(data.Locale, "sum_business"), "", "", "", "", "", formatDecimal(data.BusinessDistance, options.DecimalSeparator), formatAmount(data.Allowance, options.DecimalSeparator), ""})
+	if err != nil {
+		return err
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
+
+// the columns of the table of business trips in the annual report, in mm; they fill a
+// portrait A4 page with 10 mm margins. The headers are keys of the message catalogs:
+var annualColumns = []struct {
+	header string
+	width  float64
+	align  string
+}{
+	{"date", 20, "C"},
+	{"from", 36, "L"},
+	{"to", 36, "L"},
+	{"odometer_start_short", 18, "R"},
+	{"odometer_end_short", 18, "R"},
+	{"km", 14, "R"},
+	{"kr", 16, "R"},
+	{"purpose", 32, "L"},
+}
+
+// writeAnnualPDF renders the annual report: the business trips of the year, the business
+// distance of each month and the mileage allowance, followed by a line for the signature.
+// Trips without a purpose are marked in red.
+func writeAnnualPDF(out io.Writer, data AnnualData) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(10, 10, 10)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("")
+	pdf.SetTitle(translate(data.Locale, "business_journal"), true)
+
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	t := func(key string, args ...interface{}) string {
+		return tr(translate(data.Locale, key, args...))
+	}
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 8, t("business_trips_of", data.Year)+tr(fmt.Sprintf(", Tesla Model %s (%s)", data.Car.Model, data.Car.Name)), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 8, t("page_of", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+
+	header := func() {
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFillColor(230, 230, 230)
+		for _, c := range annualColumns {
+			label := t(c.header)
+			if c.header == "km" {
+				label = t(data.Unit)
+			}
+
+			pdf.CellFormat(c.width, reportRowHeight, label, "1", 0, c.align, true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", 8)
+	}
+
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, t("business_journal")+fmt.Sprintf(" %d", data.Year), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+
+	owner := data.Owner
+	if owner == "" {
+		owner = "______________________________"
+	}
+
+	lines := [][2]string{
+		{"car", fmt.Sprintf("Tesla Model %s (%s)", data.Car.Model, data.Car.Name)},
+		{"owner", owner},
+		{"income_year", strconv.Itoa(data.Year)},
+		{"printed", convertTime(time.Now()).Format("2006-01-02")},
+	}
+
+	if data.Driver.Name != "" {
+		lines = append(lines, [2]string{"driver", data.Driver.Name})
+	}
+
+	pdf.SetFont("Helvetica", "", 11)
+	for _, line := range lines {
+		pdf.CellFormat(25, 6, t(line[0])+":", "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 6, tr(line[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	_, pageHeight := pdf.GetPageSize()
+
+	if len(data.Trips) == 0 {
+		pdf.SetFont("Helvetica", "I", 11)
+		pdf.CellFormat(0, 8, t("no_business_trips"), "", 1, "L", false, 0, "")
+	} else {
+		header()
+	}
+
+	for _, trip := range data.Trips {
+		// repeat the header of the table on every page:
+		if pdf.GetY()+reportRowHeight > pageHeight-15 {
+			pdf.AddPage()
+			header()
+		}
+
+		purpose := trip.Purpose
+		if trip.MissingPurpose {
+			purpose = translate(data.Locale, "purpose_missing")
+			pdf.SetTextColor(200, 0, 0)
+		}
+
+		values := []string{trip.Date, trip.StartAddress, trip.EndAddress, strconv.Itoa(trip.StartOdometer), strconv.Itoa(trip.EndOdometer), trip.DistanceString, trip.AmountString, purpose}
+		for i, value := range values {
+			c := annualColumns[i]
+			pdf.CellFormat(c.width, reportRowHeight, fitText(pdf, tr(value), c.width), "1", 0, c.align, false, 0, "")
+		}
+		pdf.Ln(-1)
+
+		pdf.SetTextColor(0, 0, 0)
+	}
+
+	// the summary and the signature are kept on the same page:
+	if pdf.GetY()+130 > pageHeight-15 {
+		pdf.AddPage()
+	}
+
+	pdf.Ln(6)
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(0, 8, t("business_trips_per_month"), "", 1, "L", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, m := range data.Months {
+		pdf.CellFormat(40, 5, tr(m.Name), "", 0, "L", false, 0, "")
+		pdf.CellFormat(25, 5, t("n_trips", m.Trips), "", 0, "R", false, 0, "")
+		pdf.CellFormat(30, 5, m.DistanceString+" "+data.Unit, "", 0, "R", false, 0, "")
+		pdf.CellFormat(30, 5, m.AmountString+" kr", "", 1, "R", false, 0, "")
+	}
+
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(0, 8, t("summary"), "", 1, "L", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, total := range [][2]string{
+		{"total_distance", data.TotalDistanceString + " " + data.Unit},
+		{"of_which_business", data.BusinessDistanceString + " " + data.Unit},
+		{"allowance", data.AllowanceString + " kr"},
+	} {
+		pdf.CellFormat(50, 6, t(total[0])+":", "", 0, "L", false, 0, "")
+		pdf.CellFormat(45, 6, tr(total[1]), "", 1, "R", false, 0, "")
+	}
+
+	if data.MissingPurposes > 0 {
+		pdf.Ln(2)
+		pdf.SetTextColor(200, 0, 0)
+		pdf.CellFormat(0, 6, t("missing_purposes", data.MissingPurposes)+".", "", 1, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	}
+
+	pdf.Ln(20)
+
+	x, y := pdf.GetXY()
+	for i, label := range []string{"signature", "name_clarification", "date"} {
+		left := x + float64(i)*65
+		pdf.Line(left, y, left+58, y)
+		pdf.SetXY(left, y+1)
+		pdf.CellFormat(58, 5, t(label), "", 0, "L", false, 0, "")
+	}
+
+	if pdf.Err() {
+		return pdf.Error()
+	}
+
+	return pdf.Output(out)
+}
+
+// annualRequest collects the data of the annual report a request is for. The returned status
+// tells how a failure should be reported.
+func annualRequest(r *http.Request) (AnnualData, int, error) {
+	req, status, err := carYearRequest(r)
+	if err != nil {
+		return AnnualData{}, status, err
+	}
+
+	data, err := getAnnualData(req.Car, req.Year, req.Driver, requestLocale(r))
+	if err != nil {
+		log.Println("Error retrieving annual report: " + err.Error())
+		return data, http.StatusInternalServerError, errors.New("Error retrieving annual report")
+	}
+
+	return data, http.StatusOK, nil
+}
+
+func annualHandler(w http.ResponseWriter, r *http.Request) {
+	data, status, err := annualRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	err = annualTemplate[data.Locale].Execute(w, data)
+	if err != nil {
+		log.Println("Error while executing template: " + err.Error())
+	}
+}
+
+func annualPDFHandler(w http.ResponseWriter, r *http.Request) {
+	data, status, err := annualRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = writeAnnualPDF(&buf, data)
+	if err != nil {
+		log.Println("Error generating annual report: " + err.Error())
+		http.Error(w, "Error generating annual report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"tjansteresor-%d-%d.pdf\"", data.Car.Id, data.Year))
+	w.Write(buf.Bytes())
+}
+
+func annualCSVHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	options, err := getExportOptions(q.Get("delimiter"), q.Get("decimal"), "", "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, status, err := annualRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\ufeff")
+
+	err = writeAnnualCSV(&buf, data, options)
+	if err != nil {
+		log.Println("Error exporting annual report: " + err.Error())
+		http.Error(w, "Error exporting annual report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"tjansteresor-%d-%d.csv\"", data.Car.Id, data.Year))
+	w.Write(buf.Bytes())
+}
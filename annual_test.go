@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestYearTotals(t *testing.T) {
+	useFixtures(t)
+
+	totals, err := getTotals(2021, 0, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if totals.TotalDistance != 130.5 || totals.TotalBusinessDistance != 40 {
+		t.Errorf("Expected the totals of the whole year, got %+v", totals)
+	}
+}
+
+func TestAnnualData(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	data, err := getAnnualData(Car{Id: 1, Model: "3", Name: "Tesla"}, 2021, Driver{}, defaultLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data.Trips) != 2 {
+		t.Fatalf("Expected the 2 business trips of the year, got %+v", data.Trips)
+	}
+
+	if !data.Trips[0].MissingPurpose || data.Trips[1].MissingPurpose || data.MissingPurposes != 1 {
+		t.Errorf("Expected only the first trip to be flagged for a missing purpose, got %+v", data.Trips)
+	}
+
+	if data.Trips[1].Purpose != "Möte med kund" || data.Trips[1].StartOdometer != 1020 || data.Trips[1].EndOdometer != 1040 {
+		t.Errorf("Unexpected trip %+v", data.Trips[1])
+	}
+
+	if len(data.Months) != 12 || data.Months[1].Distance != 20 || data.Months[2].Trips != 1 || data.Months[3].Trips != 0 {
+		t.Errorf("Unexpected months %+v", data.Months)
+	}
+
+	if data.Trips[0].Amount != 50 || data.Months[2].AmountString != "50.00" {
+		t.Errorf("Expected 50 kr per trip at the default rate, got %+v", data.Trips)
+	}
+
+	if data.BusinessDistance != 40 || data.Allowance != 100 || data.AllowanceString != "100.00" {
+		t.Errorf("Expected 40 km and 100.00 kr, got %v km and %s kr", data.BusinessDistance, data.AllowanceString)
+	}
+
+	if data.TotalDistanceString != "130.5" {
+		t.Errorf("Expected a total of 130.5 km, got %s", data.TotalDistanceString)
+	}
+}
+
+func TestAnnualCSV(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+	config.Report.RatePerKm = 1.85
+
+	r := httptest.NewRequest(http.MethodGet, "/annual/1/2021.csv", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	records := readCSV(t, w.Body.String(), ';')
+	if len(records) != 4 {
+		t.Fatalf("Expected a header, 2 trips and a totals row, got %v", records)
+	}
+
+	if records[1][0] != "2021-02-26" || records[1][8] != "Ärende saknas" {
+		t.Errorf("Expected the trip without a purpose to be flagged, got %v", records[1])
+	}
+
+	if records[2][5] != "Möte med kund" || records[2][7] != "37,00" || records[2][8] != "" {
+		t.Errorf("Unexpected trip %v", records[2])
+	}
+
+	if records[3][0] != "Summa tjänsteresor" || records[3][6] != "40,00" || records[3][7] != "74,00" {
+		t.Errorf("Expected 40,00 km and an allowance of 74,00, got %v", records[3])
+	}
+}
+
+func TestAnnualPage(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/annual/1/2021", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "Möte med kund") || !strings.Contains(body, "1 tjänsteresor saknar ärende") {
+		t.Error("Expected the business trips and the number of trips missing a purpose")
+	}
+
+	if strings.Contains(body, "Industrivägen") {
+		t.Error("Expected only business trips")
+	}
+}
+
+func TestAnnualPDF(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	for _, path := range []string{"/annual/1/2021.pdf", "/annual/2/2020.pdf"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/pdf" || !bytes.HasPrefix(w.Body.Bytes(), []byte("%PDF-")) {
+			t.Errorf("GET %s: expected a PDF, got status %d", path, w.Code)
+		}
+	}
+}
+
+func TestAnnualRejectsInvalidPaths(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	tests := map[string]int{
+		"/annual/0/2021":                   http.StatusBadRequest,
+		"/annual/1/x":                      http.StatusBadRequest,
+		"/annual/1/x.pdf":                  http.StatusBadRequest,
+		"/annual/1%20OR%201/2021.csv":      http.StatusBadRequest,
+		"/annual/9/2021":                   http.StatusNotFound,
+		"/annual/1/2021.csv?delimiter=abc": http.StatusBadRequest,
+	}
+
+	for path, status := range tests {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+
+		if w.Code != status {
+			t.Errorf("GET %s: expected status %d, got %d", path, status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The /api/v1 endpoints serve scripts. Unlike the endpoints of the pages, they take and return
+// JSON with stable lower-case names, and raw values instead of display strings: distances and
+// odometer readings in the unit of the car given by unit, durations in minutes, amounts in SEK
+// and times in RFC 3339.
+
+const (
+	apiDefaultLimit = 100
+	apiMaxLimit     = 1000
+)
+
+type apiCar struct {
+	Id    int    `json:"id"`
+	Model string `json:"model"`
+	Name  string `json:"name"`
+	Unit  string `json:"unit"`
+}
+
+type apiDrive struct {
+	Id             int       `json:"id"`
+	Car            int       `json:"car"`
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	StartAddress   string    `json:"start_address"`
+	EndAddress     string    `json:"end_address"`
+	StartOdometer  int       `json:"start_odometer"`
+	EndOdometer    int       `json:"end_odometer"`
+	Distance       float32   `json:"distance"`
+	Unit           string    `json:"unit"`
+	Duration       int       `json:"duration"`
+	Classification *int      `json:"classification"`
+	AutoClassified bool      `json:"auto_classified"`
+	Comment        *string   `json:"comment"`
+	Group          *int      `json:"group"`
+	Driver         *int      `json:"driver"`
+	Reimbursement  float64   `json:"reimbursement"`
+}
+
+type apiDrivesPage struct {
+	Drives []apiDrive `json:"drives"`
+	// the number of drives matching the filters, of which the page holds at most limit from offset:
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+type apiGroup struct {
+	Id             int       `json:"id"`
+	Car            int       `json:"car"`
+	Drives         []int64   `json:"drives"`
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	StartAddress   string    `json:"start_address"`
+	EndAddress     string    `json:"end_address"`
+	StartOdometer  int       `json:"start_odometer"`
+	EndOdometer    int       `json:"end_odometer"`
+	Distance       float32   `json:"distance"`
+	Unit           string    `json:"unit"`
+	Duration       int       `json:"duration"`
+	Classification *int      `json:"classification"`
+	Comment        *string   `json:"comment"`
+	Driver         *int      `json:"driver"`
+	Reimbursement  float64   `json:"reimbursement"`
+}
+
+type apiTotals struct {
+	Car                  int     `json:"car"`
+	From                 string  `json:"from"`
+	To                   string  `json:"to"`
+	Distance             float32 `json:"distance"`
+	BusinessDistance     float32 `json:"business_distance"`
+	PrivateDistance      float32 `json:"private_distance"`
+	UnclassifiedDistance float32 `json:"unclassified_distance"`
+	UnassignedDistance   float32 `json:"unassigned_distance"`
+	Unit                 string  `json:"unit"`
+	Duration             int     `json:"duration"`
+	BusinessDuration     int     `json:"business_duration"`
+	PrivateDuration      int     `json:"private_duration"`
+	UnclassifiedDuration int     `json:"unclassified_duration"`
+	Reimbursement        float64 `json:"reimbursement"`
+}
+
+// apiDrivePatch is the body of PATCH /api/v1/drives/{id}; fields left out aren't changed.
+type apiDrivePatch struct {
+	Classification *int    `json:"classification"`
+	Comment        *string `json:"comment"`
+}
+
+// apiGroupRequest is the body of POST /api/v1/groups.
+type apiGroupRequest struct {
+	Car    int     `json:"car"`
+	Drives []int64 `json:"drives"`
+}
+
+type apiErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func nullIntPtr(n sql.NullInt32) *int {
+	if !n.Valid {
+		return nil
+	}
+
+	i := int(n.Int32)
+	return &i
+}
+
+func nullStringPtr(n sql.NullString) *string {
+	if !n.Valid {
+		return nil
+	}
+
+	return &n.String
+}
+
+// toApiDrive converts a drive to the unit for the API.
+func toApiDrive(d Drive, unit string) apiDrive {
+	convertDrive(&d, unit)
+
+	return apiDrive{
+		Id:             d.Id,
+		Car:            d.CarId,
+		Start:          d.StartDate,
+		End:            d.EndDate,
+		StartAddress:   d.StartAddress,
+		EndAddress:     d.EndAddress,
+		StartOdometer:  d.StartOdometer,
+		EndOdometer:    d.EndOdometer,
+		Distance:       d.Distance,
+		Unit:           unit,
+		Duration:       d.Duration,
+		Classification: nullIntPtr(d.Classification),
+		AutoClassified: d.IsAutoClassified(),
+		Comment:        nullStringPtr(d.Comment),
+		Group:          nullIntPtr(d.GroupId),
+		Driver:         nullIntPtr(d.DriverId),
+		Reimbursement:  d.Reimbursement,
+	}
+}
+
+// toApiGroup converts a grouped drive to the unit for the API.
+func toApiGroup(gd GroupedDrives, unit string) apiGroup {
+	convertGroupedDrives(&gd, unit)
+
+	return apiGroup{
+		Id:             gd.Id,
+		Car:            gd.CarId,
+		Drives:         gd.DriveIds,
+		Start:          gd.StartDate,
+		End:            gd.EndDate,
+		StartAddress:   gd.StartAddress,
+		EndAddress:     gd.EndAddress,
+		StartOdometer:  gd.StartOdometer,
+		EndOdometer:    gd.EndOdometer,
+		Distance:       gd.Distance,
+		Unit:           unit,
+		Duration:       gd.Duration,
+		Classification: nullIntPtr(gd.Classification),
+		Comment:        nullStringPtr(gd.Comment),
+		Driver:         nullIntPtr(gd.DriverId),
+		Reimbursement:  gd.Reimbursement,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeApiError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiErrorResponse{Error: err.Error()})
+}
+
+// apiCarParam returns the car of the car parameter, which is mandatory.
+func apiCarParam(q url.Values) (int, error) {
+	car, err := parseId(q.Get("car"))
+	if err != nil {
+		return 0, errors.New("Invalid or missing car: " + strconv.Quote(q.Get("car")))
+	}
+
+	return int(car), nil
+}
+
+// apiPeriod returns the period given by the from and to parameters, both YYYY-MM-DD and
+// inclusive, as [from, to). A period left open at either end reaches the first or last drive.
+// The returned status tells how a failure should be reported.
+func apiPeriod(q url.Values) (time.Time, time.Time, int, error) {
+	first, last, err := getFirstAndLastYears()
+	if err == sql.ErrNoRows {
+		first = convertTime(time.Now()).Year()
+		last = first
+	} else if err != nil {
+		log.Println("Error retrieving the years of the drives: " + err.Error())
+		return time.Time{}, time.Time{}, http.StatusInternalServerError, errors.New("Error retrieving drives")
+	}
+
+	from := localDate(first, 1, 1)
+	to := localDate(last+1, 1, 1)
+
+	if s := q.Get("from"); s != "" {
+		from, err = parseDate(s)
+		if err != nil {
+			return from, to, http.StatusBadRequest, errors.New("Invalid from date: " + strconv.Quote(s))
+		}
+	}
+
+	if s := q.Get("to"); s != "" {
+		day, err := parseDate(s)
+		if err != nil {
+			return from, to, http.StatusBadRequest, errors.New("Invalid to date: " + strconv.Quote(s))
+		}
+
+		to = day.AddDate(0, 0, 1)
+	}
+
+	if !to.After(from) {
+		return from, to, http.StatusBadRequest, errors.New("The period ends before it starts")
+	}
+
+	return from, to, http.StatusOK, nil
+}
+
+// apiPage returns the limit and offset parameters of a paginated list.
+func apiPage(q url.Values) (int, int, error) {
+	limit, offset := apiDefaultLimit, 0
+
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > apiMaxLimit {
+			return 0, 0, fmt.Errorf("Invalid limit, it must be 1 - %d: %s", apiMaxLimit, strconv.Quote(s))
+		}
+		limit = n
+	}
+
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("Invalid offset: " + strconv.Quote(s))
+		}
+		offset = n
+	}
+
+	return limit, offset, nil
+}
+
+func apiGetCars(w http.ResponseWriter, r *http.Request) {
+	cars, err := getCars()
+	if err != nil {
+		log.Println("Error retrieving cars: " + err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("Error retrieving cars"))
+		return
+	}
+
+	result := []apiCar{}
+	for _, c := range currentAccess(r).visibleCars(cars) {
+		result = append(result, apiCar{Id: c.Id, Model: c.Model, Name: c.Name, Unit: carUnit(c.Id)})
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// apiGetDrives lists the drives of a car, latest first, filtered by period, classification and driver.
+func apiGetDrives(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	car, err := apiCarParam(q)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	from, to, status, err := apiPeriod(q)
+	if err != nil {
+		writeApiError(w, status, err)
+		return
+	}
+
+	limit, offset, err := apiPage(q)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	classification := anyClassification
+	if s := q.Get("classification"); s == "none" {
+		classification = noClassification
+	} else if s != "" {
+		id, err := parseId(s)
+		if err != nil {
+			writeApiError(w, http.StatusBadRequest, errors.New("Invalid classification: "+strconv.Quote(s)))
+			return
+		}
+		classification = int(id)
+	}
+
+	driver, err := getDriverParam(q, car)
+	if errors.Is(err, errUnknownDriver) {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		log.Println("Error retrieving drivers: " + err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("Error retrieving drivers"))
+		return
+	}
+
+	drives, total, err := getDrivesPage(car, driver.Id, from, to, classification, limit, offset, configLocale())
+	if err != nil {
+		log.Println("Error retrieving drives: " + err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("Error retrieving drives"))
+		return
+	}
+
+	unit := carUnit(car)
+
+	page := apiDrivesPage{Drives: []apiDrive{}, Total: total, Limit: limit, Offset: offset}
+	for _, d := range drives {
+		page.Drives = append(page.Drives, toApiDrive(d, unit))
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func apiGetDrive(w http.ResponseWriter, r *http.Request) {
+	id, err := getIdVar(r)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	d, _, err := getDriveById(id, configLocale())
+	if err == sql.ErrNoRows {
+		writeApiError(w, http.StatusNotFound, errors.New("No such drive"))
+		return
+	} else if err != nil {
+		log.Println("Error retrieving drive: " + err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("Error retrieving drive"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toApiDrive(d, carUnit(d.CarId)))
+}
+
+// apiPatchDrive changes the classification and comment of a drive; an empty comment removes it.
+func apiPatchDrive(w http.ResponseWriter, r *http.Request) {
+	id, err := getIdVar(r)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var patch apiDrivePatch
+	err = json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, errors.New("Invalid JSON: "+err.Error()))
+		return
+	}
+
+	if patch.Classification == nil && patch.Comment == nil {
+		writeApiError(w, http.StatusBadRequest, errors.New("Nothing to change; give a classification or a comment"))
+		return
+	}
+
+	_, err = store.GetDriveById(id)
+	if err == sql.ErrNoRows {
+		writeApiError(w, http.StatusNotFound, errors.New("No such drive"))
+		return
+	} else if err != nil {
+		log.Println("Error retrieving drive: " + err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("Error retrieving drive"))
+		return
+	}
+
+	drives := []int64{int64(id)}
+	if patch.Classification != nil {
+		categories, err := getCategoryMap()
+		if err != nil {
+			log.Println("Error retrieving categories: " + err.Error())
+			writeApiError(w, http.StatusInternalServerError, errors.New("Error retrieving categories"))
+			return
+		}
+
+		if _, exists := categories[*patch.Classification]; !exists {
+			writeApiError(w, http.StatusBadRequest, fmt.Errorf("Invalid classification: %d", *patch.Classification))
+			return
+		}
+	}
+
+	action := "classify"
+	if patch.Classification == nil {
+		action = "comment"
+	}
+
+	if !apiCheckAction(w, r, action, drives, []int64{}) {
+		return
+	}
+
+	err = store.Transaction(func(tx JournalStore) error {
+		var err error
+
+		if patch.Classification != nil {
+			_, _, err = changeClassification(tx, *patch.Classification, drives, []int64{}, actingUser(r))
+		}
+
+		if err == nil && patch.Comment != nil {
+			_, _, err = changeComment(tx, *patch.Comment, drives, []int64{}, actingUser(r))
+		}
+
+		return err
+	})
+	if !apiCheckChange(w, err) {
+		return
+	}
+
+	apiGetDrive(w, r)
+}
+
+// apiPostGroup groups drives of a car, returning the new group.
+func apiPostGroup(w http.ResponseWriter, r *http.Request) {
+	var request apiGroupRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, errors.New("Invalid JSON: "+err.Error()))
+		return
+	}
+
+	if len(request.Drives) < 2 {
+		writeApiError(w, http.StatusBadRequest, errors.New("A group needs at least two drives"))
+		return
+	}
+
+	if !currentAccess(r).canSeeCar(request.Car) {
+		writeApiError(w, http.StatusForbidden, fmt.Errorf("No access to car %d", request.Car))
+		return
+	}
+
+	if !apiCheckAction(w, r, "group", request.Drives, []int64{}) {
+		return
+	}
+
+	err = store.Transaction(func(tx JournalStore) error {
+		_, _, err := groupDrives(tx, request.Car, request.Drives, actingUser(r))
+		return err
+	})
+	if !apiCheckChange(w, err) {
+		return
+	}
+
+	d, err := store.GetDriveById(int(request.Drives[0]))
+	if err == nil && !d.GroupId.Valid {
+		err = errors.New("The drives weren't grouped")
+	}
+
+	var gd GroupedDrives
+	if err == nil {
+		gd, err = getGroupedDrivesById(int(d.GroupId.Int32), configLocale())
+	}
+
+	if err != nil {
+		log.Println("Error retrieving the new group: " + err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("Error retrieving the new group"))
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toApiGroup(gd, carUnit(gd.CarId)))
+}
+
+// apiDeleteGroup ungroups a group; with copycomment=true its comment is copied to its drives.
+func apiDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := getIdVar(r)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	gd, err := store.GetGroupedDrivesById(id)
+	if err == sql.ErrNoRows {
+		writeApiError(w, http.StatusNotFound, errors.New("No such group"))
+		return
+	} else if err != nil {
+		log.Println("Error retrieving group: " + err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("Error retrieving group"))
+		return
+	}
+
+	groups := []int64{int64(id)}
+	if !apiCheckAction(w, r, "ungroup", []int64{}, groups) {
+		return
+	}
+
+	copyComment := r.URL.Query().Get("copycomment") == "true"
+	err = store.Transaction(func(tx JournalStore) error {
+		_, _, err := ungroupDrives(tx, gd.CarId, groups, copyComment, actingUser(r))
+		return err
+	})
+	if !apiCheckChange(w, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiGetTotals returns the totals of a car, or of one of its drivers, for any period.
+func apiGetTotals(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	car, err := apiCarParam(q)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	from, to, status, err := apiPeriod(q)
+	if err != nil {
+		writeApiError(w, status, err)
+		return
+	}
+
+	driver, err := getDriverParam(q, car)
+	if errors.Is(err, errUnknownDriver) {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		log.Println("Error retrieving drivers: " + err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("Error retrieving drivers"))
+		return
+	}
+
+	totals, err := getTotalsBetween(car, driver.Id, from, to)
+	if err != nil {
+		log.Println("Error retrieving totals: " + err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("Error retrieving totals"))
+		return
+	}
+
+	unit := carUnit(car)
+	convertTotals(&totals, unit)
+
+	writeJSON(w, http.StatusOK, apiTotals{
+		Car:                  car,
+		From:                 from.Format("2006-01-02"),
+		To:                   to.AddDate(0, 0, -1).Format("2006-01-02"),
+		Distance:             totals.TotalDistance,
+		BusinessDistance:     totals.TotalBusinessDistance,
+		PrivateDistance:      totals.TotalPrivateDistance,
+		UnclassifiedDistance: totals.UnclassifiedDistance,
+		UnassignedDistance:   totals.UnassignedDistance,
+		Unit:                 unit,
+		Duration:             totals.TotalDuration,
+		BusinessDuration:     totals.TotalBusinessDuration,
+		PrivateDuration:      totals.TotalPrivateDuration,
+		UnclassifiedDuration: totals.UnclassifiedDuration,
+		Reimbursement:        totals.Reimbursement,
+	})
+}
+
+// apiCheckAction refuses changes the user may not make, like postAction does, telling whether
+// the change may go ahead.
+func apiCheckAction(w http.ResponseWriter, r *http.Request, action string, drives []int64, groups []int64) bool {
+	err := checkAction(currentAccess(r), action, drives, groups)
+	if errors.Is(err, errNotOwnDrive) || errors.Is(err, errNotAllowed) {
+		writeApiError(w, http.StatusForbidden, err)
+		return false
+	} else if err != nil {
+		log.Println("Error checking the drives of a change: " + err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("Error checking the drives"))
+		return false
+	}
+
+	return true
+}
+
+// apiCheckChange responds with the error of a change, if it failed, telling whether it succeeded.
+func apiCheckChange(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, errMonthClosed) {
+		writeApiError(w, http.StatusConflict, err)
+		return false
+	} else if errors.Is(err, errInvalidGroup) {
+		writeApiError(w, http.StatusBadRequest, err)
+		return false
+	} else if err != nil {
+		log.Println("Error changing drives: " + err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("Error changing drives"))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func apiRequest(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func apiDriveIds(drives []apiDrive) []int {
+	var ids []int
+	for _, d := range drives {
+		ids = append(ids, d.Id)
+	}
+
+	return ids
+}
+
+func TestApiGetDrives(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	w := apiRequest(t, http.MethodGet, "/api/v1/drives?car=1&from=2021-03-01&to=2021-03-02&classification=none", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	// the names are lower-case and there are no display strings:
+	if !strings.Contains(w.Body.String(), `"start_address":`) || strings.Contains(w.Body.String(), "String") {
+		t.Errorf("Unexpected JSON %s", w.Body.String())
+	}
+
+	var page apiDrivesPage
+	json.NewDecoder(w.Body).Decode(&page)
+	if page.Total != 2 || !equalIds(apiDriveIds(page.Drives), []int{5, 3}) || page.Drives[1].Classification != nil || page.Drives[1].Distance != 20.5 {
+		t.Errorf("Expected the unclassified drives 5 and 3, got %+v", page)
+	}
+
+	w = apiRequest(t, http.MethodGet, "/api/v1/drives?car=1&classification=1", "")
+	page = apiDrivesPage{}
+	json.NewDecoder(w.Body).Decode(&page)
+	if page.Total != 2 || !equalIds(apiDriveIds(page.Drives), []int{2, 1}) || *page.Drives[0].Classification != business {
+		t.Errorf("Expected the business drives 2 and 1, got %+v", page)
+	}
+
+	w = apiRequest(t, http.MethodGet, "/api/v1/drives?car=1&limit=2&offset=4", "")
+	page = apiDrivesPage{}
+	json.NewDecoder(w.Body).Decode(&page)
+	if page.Total != 6 || page.Limit != 2 || page.Offset != 4 || !equalIds(apiDriveIds(page.Drives), []int{2, 1}) {
+		t.Errorf("Expected the last page of two drives, got %+v", page)
+	}
+
+	for _, query := range []string{"", "car=x", "car=1&from=2021-13-01", "car=1&from=2021-03-02&to=2021-03-01", "car=1&limit=0", "car=1&offset=-1", "car=1&classification=business"} {
+		w = apiRequest(t, http.MethodGet, "/api/v1/drives?"+query, "")
+		if w.Code != http.StatusBadRequest || !strings.HasPrefix(w.Body.String(), `{"error":`) {
+			t.Errorf("Expected %q to be refused, got %d %s", query, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestApiDrive(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+
+	w := apiRequest(t, http.MethodPatch, "/api/v1/drives/3", `{"classification": 1, "comment": "Kundbesök"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var d apiDrive
+	json.NewDecoder(w.Body).Decode(&d)
+	if d.Id != 3 || d.Classification == nil || *d.Classification != business || d.Comment == nil || *d.Comment != "Kundbesök" || d.Reimbursement != 51.25 {
+		t.Errorf("Unexpected drive %+v", d)
+	}
+
+	// only what is given is changed:
+	w = apiRequest(t, http.MethodPatch, "/api/v1/drives/3", `{"comment": ""}`)
+	if w.Code != http.StatusOK || s.classifications[3].Classification != business {
+		t.Errorf("Expected only the comment to be removed, got %d", w.Code)
+	}
+
+	w = apiRequest(t, http.MethodGet, "/api/v1/drives/3", "")
+	d = apiDrive{}
+	json.NewDecoder(w.Body).Decode(&d)
+	if w.Code != http.StatusOK || d.Comment != nil || d.Car != 1 {
+		t.Errorf("Unexpected drive %+v", d)
+	}
+
+	tests := []struct {
+		path, body string
+		status     int
+	}{
+		{"/api/v1/drives/3", `{"classification": 99}`, http.StatusBadRequest},
+		{"/api/v1/drives/3", `{}`, http.StatusBadRequest},
+		{"/api/v1/drives/3", `classification=1`, http.StatusBadRequest},
+		{"/api/v1/drives/99", `{"classification": 1}`, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		w = apiRequest(t, http.MethodPatch, test.path, test.body)
+		if w.Code != test.status {
+			t.Errorf("Expected %s %s to get status %d, got %d", test.path, test.body, test.status, w.Code)
+		}
+	}
+
+	closeMonth(store, 1, 2021, 3, "test")
+	w = apiRequest(t, http.MethodPatch, "/api/v1/drives/3", `{"classification": 2}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected a drive of a closed month to be refused, got %d", w.Code)
+	}
+}
+
+func TestApiGroups(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+
+	w := apiRequest(t, http.MethodPost, "/api/v1/groups", `{"car": 1, "drives": [4, 5]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var g apiGroup
+	json.NewDecoder(w.Body).Decode(&g)
+	if g.Id == 0 || g.Car != 1 || len(g.Drives) != 2 || g.Distance != 10 {
+		t.Errorf("Unexpected group %+v", g)
+	}
+
+	for _, body := range []string{`{"car": 1, "drives": [4]}`, `{"car": 1, "drives": [6, 7]}`, `{"car": 1, "drives": [6, 99]}`} {
+		w = apiRequest(t, http.MethodPost, "/api/v1/groups", body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be refused, got %d", body, w.Code)
+		}
+	}
+
+	w = apiRequest(t, http.MethodDelete, "/api/v1/groups/"+strconv.Itoa(g.Id), "")
+	if w.Code != http.StatusNoContent || len(s.groups) != 0 {
+		t.Errorf("Expected the group to be removed, got %d", w.Code)
+	}
+
+	w = apiRequest(t, http.MethodDelete, "/api/v1/groups/"+strconv.Itoa(g.Id), "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a removed group, got %d", w.Code)
+	}
+}
+
+func TestApiTotalsAndCars(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	w := apiRequest(t, http.MethodGet, "/api/v1/totals?car=1&from=2021-03-01&to=2021-03-31", "")
+	var totals apiTotals
+	json.NewDecoder(w.Body).Decode(&totals)
+	if w.Code != http.StatusOK || totals.Distance != 110.5 || totals.BusinessDistance != 20 || totals.PrivateDistance != 5 || totals.UnclassifiedDistance != 85.5 || totals.To != "2021-03-31" {
+		t.Errorf("Unexpected totals of March %+v", totals)
+	}
+
+	// without a period, all drives count:
+	w = apiRequest(t, http.MethodGet, "/api/v1/totals?car=1", "")
+	totals = apiTotals{}
+	json.NewDecoder(w.Body).Decode(&totals)
+	if totals.Distance != 130.5 || totals.Reimbursement != 100 {
+		t.Errorf("Unexpected totals %+v", totals)
+	}
+
+	w = apiRequest(t, http.MethodGet, "/api/v1/cars", "")
+	body := w.Body.String()
+	var cars []apiCar
+	json.Unmarshal([]byte(body), &cars)
+	if w.Code != http.StatusOK || len(cars) != 2 || cars[0].Id != 1 || !strings.Contains(body, `"model":`) {
+		t.Errorf("Unexpected cars %s", body)
+	}
+}
+
+// failingYearsStore fails to tell the years of the drives, as if the database were down.
+type failingYearsStore struct {
+	*memoryStore
+}
+
+func (failingYearsStore) GetFirstAndLastYears() (int, int, error) {
+	return 0, 0, errors.New("connection refused")
+}
+
+func TestApiPeriodErrors(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+
+	if w := apiRequest(t, http.MethodGet, "/api/v1/drives?car=1&from=2021-13-01", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid date to be refused, got %d", w.Code)
+	}
+
+	store = failingYearsStore{s}
+
+	for _, path := range []string{"/api/v1/drives?car=1", "/api/v1/totals?car=1"} {
+		w := apiRequest(t, http.MethodGet, path, "")
+		if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "connection refused") {
+			t.Errorf("Expected %s to fail with status 500, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+// failingDriveStore fails to retrieve drives, as if the database were down.
+type failingDriveStore struct {
+	*memoryStore
+}
+
+func (failingDriveStore) GetDriveById(id int) (Drive, error) {
+	return Drive{}, errors.New("connection refused")
+}
+
+func TestApiPatchDriveError(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+	store = failingDriveStore{s}
+
+	w := apiRequest(t, http.MethodPatch, "/api/v1/drives/3", `{"comment": "Handla"}`)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", w.Code)
+	}
+
+	if _, commented := s.comments[3]; commented {
+		t.Error("Expected the drive to be left alone")
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the kinds of things changed by the entries of the audit log:
+const (
+	auditDrive = "drive"
+	auditGroup = "group"
+)
+
+// the changes recorded in the audit log:
+const (
+	auditClassify = "classify"
+	auditComment  = "comment"
+	auditGroupAdd = "group"
+	auditUngroup  = "ungroup"
+	auditAssign   = "assign"
+)
+
+// auditTimeFormat is how the time of an entry is hashed. The database keeps times with
+// microsecond precision, so entries are timed at whole microseconds.
+const auditTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+// auditHash returns the hash of an entry, which covers the hash of the entry before it.
+func auditHash(e AuditEntry) string {
+	h := sha256.New()
+
+	fields := []string{e.PrevHash, e.Time.UTC().Format(auditTimeFormat), e.User, e.Action, e.Entity, strconv.Itoa(e.EntityId), e.OldValue, e.NewValue}
+	for _, field := range fields {
+		// quoting keeps the boundaries between the fields unambiguous:
+		fmt.Fprintln(h, strconv.Quote(field))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// chainAuditEntries sets the hashes of entries appended after the entry with the hash last,
+// which is empty if the log is empty.
+func chainAuditEntries(last string, entries []AuditEntry) {
+	for i := range entries {
+		entries[i].PrevHash = last
+		entries[i].Hash = auditHash(entries[i])
+		last = entries[i].Hash
+	}
+}
+
+// verifyAuditLog checks that every entry of the log is chained to the one before it and that
+// no entry has been changed since it was written.
+func verifyAuditLog(entries []AuditEntry) error {
+	last := ""
+	for _, e := range entries {
+		if e.PrevHash != last {
+			return fmt.Errorf("Entry %d isn't chained to the entry before it; an entry has been removed or inserted", e.Id)
+		}
+
+		if auditHash(e) != e.Hash {
+			return fmt.Errorf("Entry %d has been changed since it was written", e.Id)
+		}
+
+		last = e.Hash
+	}
+
+	return nil
+}
+
+// auditChange is a change to be recorded in the audit log.
+type auditChange struct {
+	Action   string
+	Entity   string
+	EntityId int
+	OldValue string
+	NewValue string
+}
+
+// audit records the changes made by user in the audit log.
+func audit(s JournalStore, user string, changes []auditChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	var entries []AuditEntry
+	for _, c := range changes {
+		entries = append(entries, AuditEntry{
+			Time:     now,
+			User:     user,
+			Action:   c.Action,
+			Entity:   c.Entity,
+			EntityId: c.EntityId,
+			OldValue: c.OldValue,
+			NewValue: c.NewValue,
+		})
+	}
+
+	return s.AppendAudit(entries)
+}
+
+func classificationValue(classification sql.NullInt32) string {
+	if !classification.Valid {
+		return ""
+	}
+
+	return strconv.Itoa(int(classification.Int32))
+}
+
+func commentValue(comment sql.NullString) string {
+	if !comment.Valid {
+		return ""
+	}
+
+	return comment.String
+}
+
+func idsValue(ids []int64) string {
+	var s []string
+	for _, id := range ids {
+		s = append(s, strconv.FormatInt(id, 10))
+	}
+
+	return strings.Join(s, ",")
+}
+
+// classificationChanges returns the changes made by classifying the drives and grouped drives,
+// including the drives of the groups. Drives that already have the classification are left out.
+func classificationChanges(s JournalStore, classification int, driveIds []int64, groupIds []int64) ([]auditChange, error) {
+	var changes []auditChange
+	value := strconv.Itoa(classification)
+
+	for _, id := range groupIds {
+		g, err := s.GetGroupedDrivesById(int(id))
+		if err != nil {
+			return nil, err
+		}
+
+		if old := classificationValue(g.Classification); old != value {
+			changes = append(changes, auditChange{auditClassify, auditGroup, g.Id, old, value})
+		}
+	}
+
+	members, err := s.GetDriveIdsForGroups(groupIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range append(append([]int64{}, driveIds...), members...) {
+		d, err := s.GetDriveById(int(id))
+		if err != nil {
+			return nil, err
+		}
+
+		if old := classificationValue(d.Classification); old != value {
+			changes = append(changes, auditChange{auditClassify, auditDrive, d.Id, old, value})
+		}
+	}
+
+	return changes, nil
+}
+
+// driverChanges returns the changes made by assigning the drives, and the drives of the
+// grouped drives, to a driver. A driverId of 0 removes their driver.
+func driverChanges(s JournalStore, driverId int, driveIds []int64, groupIds []int64) ([]auditChange, error) {
+	var changes []auditChange
+
+	value := ""
+	if driverId != 0 {
+		value = strconv.Itoa(driverId)
+	}
+
+	members, err := s.GetDriveIdsForGroups(groupIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range append(append([]int64{}, driveIds...), members...) {
+		d, err := s.GetDriveById(int(id))
+		if err != nil {
+			return nil, err
+		}
+
+		if old := classificationValue(d.DriverId); old != value {
+			changes = append(changes, auditChange{auditAssign, auditDrive, d.Id, old, value})
+		}
+	}
+
+	return changes, nil
+}
+
+// commentChanges returns the changes made by commenting the drives and grouped drives.
+func commentChanges(s JournalStore, comment string, driveIds []int64, groupIds []int64) ([]auditChange, error) {
+	var changes []auditChange
+
+	for _, id := range groupIds {
+		g, err := s.GetGroupedDrivesById(int(id))
+		if err != nil {
+			return nil, err
+		}
+
+		if old := commentValue(g.Comment); old != comment {
+			changes = append(changes, auditChange{auditComment, auditGroup, g.Id, old, comment})
+		}
+	}
+
+	for _, id := range driveIds {
+		d, err := s.GetDriveById(int(id))
+		if err != nil {
+			return nil, err
+		}
+
+		if old := commentValue(d.Comment); old != comment {
+			changes = append(changes, auditChange{auditComment, auditDrive, d.Id, old, comment})
+		}
+	}
+
+	return changes, nil
+}
+
+// groupChanges returns the changes made by grouping the drives, which must have been grouped
+// already so that the id of the new group is known.
+func groupChanges(s JournalStore, driveIds []int64) ([]auditChange, error) {
+	var changes []auditChange
+
+	for _, id := range driveIds {
+		d, err := s.GetDriveById(int(id))
+		if err != nil {
+			return nil, err
+		}
+
+		if !d.GroupId.Valid {
+			continue
+		}
+
+		if len(changes) == 0 {
+			changes = append(changes, auditChange{auditGroupAdd, auditGroup, d.GroupIdInt(), "", idsValue(driveIds)})
+		}
+
+		changes = append(changes, auditChange{auditGroupAdd, auditDrive, d.Id, "", strconv.Itoa(d.GroupIdInt())})
+	}
+
+	return changes, nil
+}
+
+// ungroupChanges returns the changes made by ungrouping the grouped drives, including the
+// comments copied to their drives.
+func ungroupChanges(s JournalStore, groupIds []int64, copyComment bool) ([]auditChange, error) {
+	var changes []auditChange
+
+	for _, id := range groupIds {
+		g, err := s.GetGroupedDrivesById(int(id))
+		if err != nil {
+			return nil, err
+		}
+
+		driveIds, err := s.GetDriveIdsForGroups([]int64{id})
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, auditChange{auditUngroup, auditGroup, g.Id, idsValue(driveIds), ""})
+
+		for _, driveId := range driveIds {
+			d, err := s.GetDriveById(int(driveId))
+			if err != nil {
+				return nil, err
+			}
+
+			changes = append(changes, auditChange{auditUngroup, auditDrive, d.Id, strconv.Itoa(g.Id), ""})
+
+			if copyComment && g.Comment.Valid && commentValue(d.Comment) != g.Comment.String {
+				changes = append(changes, auditChange{auditComment, auditDrive, d.Id, commentValue(d.Comment), g.Comment.String})
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// ruleChanges returns the changes made by classifying the drive using the rule.
+func ruleChanges(drive Drive, rule Rule) []auditChange {
+	var changes []auditChange
+
+	value := strconv.Itoa(rule.Classification)
+	if old := classificationValue(drive.Classification); old != value {
+		changes = append(changes, auditChange{auditClassify, auditDrive, drive.Id, old, value})
+	}
+
+	if rule.Comment != "" && !drive.Comment.Valid {
+		changes = append(changes, auditChange{auditComment, auditDrive, drive.Id, "", rule.Comment})
+	}
+
+	return changes
+}
+
+func getHistory(w http.ResponseWriter, r *http.Request, entity string) {
+	id, err := getIdVar(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := store.GetAuditHistory(entity, id)
+	if err != nil {
+		log.Printf("Error retrieving history of %s %d: %s\n", entity, id, err.Error())
+		http.Error(w, "Error retrieving history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
+
+func getDriveHistory(w http.ResponseWriter, r *http.Request) {
+	getHistory(w, r, auditDrive)
+}
+
+func getGroupHistory(w http.ResponseWriter, r *http.Request) {
+	getHistory(w, r, auditGroup)
+}
+
+// runVerifyCommand implements the verify subcommand, checking the hash chain of the audit log.
+func runVerifyCommand() error {
+	entries, err := store.GetAuditLog()
+	if err != nil {
+		return err
+	}
+
+	err = verifyAuditLog(entries)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("The audit log is intact (%d entries)\n", len(entries))
+
+	return nil
+}
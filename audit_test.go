@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func auditSummary(entries []AuditEntry) []string {
+	var s []string
+	for _, e := range entries {
+		s = append(s, e.Action+":"+e.OldValue+">"+e.NewValue)
+	}
+
+	return s
+}
+
+func TestAuditLogRecordsChanges(t *testing.T) {
+	s := useFixtures(t)
+
+	_, _, err := changeClassification(store, private, []int64{3}, []int64{}, "anna")
+	if err == nil {
+		_, _, err = changeComment(store, " Lunch ", []int64{3}, []int64{}, "anna")
+	}
+	if err == nil {
+		_, _, err = groupDrives(store, 1, []int64{2, 3}, "bertil")
+	}
+	if err == nil {
+		_, _, err = changeComment(store, "Kundbesök", []int64{}, []int64{int64(s.groups[0].Id)}, "bertil")
+	}
+	if err == nil {
+		_, _, err = changeClassification(store, business, []int64{}, []int64{int64(s.groups[0].Id)}, "bertil")
+	}
+	if err == nil {
+		_, _, err = ungroupDrives(store, 1, []int64{int64(s.groups[0].Id)}, true, "bertil")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, _ := store.GetAuditHistory(auditDrive, 3)
+	expected := []string{"classify:>2", "comment:>Lunch", "group:>1", "classify:2>1", "ungroup:1>", "comment:Lunch>Kundbesök"}
+	if strings.Join(auditSummary(history), " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected the history of drive 3 to be %v, got %v", expected, auditSummary(history))
+	}
+
+	if history[0].User != "anna" || history[len(history)-1].User != "bertil" {
+		t.Errorf("Expected the changes to be logged with their users, got %+v", history)
+	}
+
+	// drive 2 was business already, so only its grouping is logged:
+	history, _ = store.GetAuditHistory(auditDrive, 2)
+	if strings.Join(auditSummary(history), " ") != "group:>1 ungroup:1> comment:Möte med kund>Kundbesök" {
+		t.Errorf("Expected unchanged values to be left out of the log, got %v", auditSummary(history))
+	}
+
+	history, _ = store.GetAuditHistory(auditGroup, 1)
+	if strings.Join(auditSummary(history), " ") != "group:>2,3 comment:>Kundbesök classify:>1 ungroup:2,3>" {
+		t.Errorf("Unexpected history of the group: %v", auditSummary(history))
+	}
+
+	log, _ := store.GetAuditLog()
+	err = verifyAuditLog(log)
+	if err != nil {
+		t.Errorf("Expected the audit log to be intact, got %v", err)
+	}
+}
+
+func TestAuditLogOfRules(t *testing.T) {
+	useFixtures(t)
+	store.CreateRule(Rule{Classification: private, Comment: "Ärende"})
+
+	drives, _ := store.GetDrives(1, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC))
+	_, err := applyRules(store, 1, drives, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, _ := store.GetAuditHistory(auditDrive, 3)
+	if strings.Join(auditSummary(history), " ") != "classify:>2 comment:>Ärende" {
+		t.Errorf("Expected the classification by the rule to be logged, got %v", auditSummary(history))
+	}
+}
+
+func TestAuditLogIsRolledBackWithTheChange(t *testing.T) {
+	useFixtures(t)
+
+	// the classification of drive 3 is logged before drive 99 turns out not to exist:
+	_, _, err := changeClassification(store, private, []int64{3}, []int64{}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Transaction(func(tx JournalStore) error {
+		_, _, err := changeComment(tx, "Ändrad", []int64{3, 99}, []int64{}, "test")
+		return err
+	})
+
+	log, _ := store.GetAuditLog()
+	if len(log) != 1 {
+		t.Errorf("Expected only the classification to be logged, got %v", auditSummary(log))
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	entries := []AuditEntry{
+		{Id: 1, Time: time.Date(2021, 3, 1, 12, 0, 0, 123456000, time.UTC), User: "anna", Action: auditClassify, Entity: auditDrive, EntityId: 3, NewValue: "2"},
+		{Id: 2, Time: time.Date(2021, 3, 1, 12, 1, 0, 0, time.UTC), User: "anna", Action: auditComment, Entity: auditDrive, EntityId: 3, NewValue: "Lunch"},
+		{Id: 3, Time: time.Date(2021, 3, 2, 8, 0, 0, 0, time.UTC), User: "bertil", Action: auditClassify, Entity: auditDrive, EntityId: 3, OldValue: "2", NewValue: "1"},
+	}
+	chainAuditEntries("", entries)
+
+	err := verifyAuditLog(entries)
+	if err != nil {
+		t.Fatalf("Expected the chain to be intact, got %v", err)
+	}
+
+	// times read back from the database are in another zone, but the same instant:
+	moved := append([]AuditEntry{}, entries...)
+	moved[0].Time = moved[0].Time.In(time.FixedZone("", 3600))
+	if verifyAuditLog(moved) != nil {
+		t.Error("Expected the zone of a time not to matter")
+	}
+
+	changed := append([]AuditEntry{}, entries...)
+	changed[1].NewValue = "Kundbesök"
+	err = verifyAuditLog(changed)
+	if err == nil || !strings.Contains(err.Error(), "Entry 2") {
+		t.Errorf("Expected entry 2 to be reported as changed, got %v", err)
+	}
+
+	// changing the hash too only moves the break to the next entry:
+	changed[1].Hash = auditHash(changed[1])
+	err = verifyAuditLog(changed)
+	if err == nil || !strings.Contains(err.Error(), "Entry 3") {
+		t.Errorf("Expected entry 3 to be reported as unchained, got %v", err)
+	}
+
+	removed := []AuditEntry{entries[0], entries[2]}
+	err = verifyAuditLog(removed)
+	if err == nil || !strings.Contains(err.Error(), "Entry 3") {
+		t.Errorf("Expected the removal of entry 2 to be detected, got %v", err)
+	}
+}
+
+func TestDriveHistoryHandler(t *testing.T) {
+	useFixtures(t)
+
+	w := postForm(t, "/action", url.Values{"action": {"classify"}, "classification": {"2"}, "drive": {"3"}, "year": {"2021"}, "month": {"3"}, "car": {"1"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/history/drive/3", nil)
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var history []AuditEntry
+	err := json.NewDecoder(w.Body).Decode(&history)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 1 || history[0].NewValue != "2" || history[0].User != "192.0.2.1" {
+		t.Errorf("Expected the classification by the client, got %+v", history)
+	}
+
+	for _, id := range hostileIds {
+		r := httptest.NewRequest(http.MethodGet, "/history/group/"+url.PathEscape(id), nil)
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+
+		if w.Code == http.StatusOK {
+			t.Errorf("Expected %q to be rejected", id)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookie = "tj_session"
+	// the CSRF token is also kept in a cookie readable by the scripts of the pages, see static/csrf.js:
+	csrfCookie = "tj_csrf"
+	csrfHeader = "X-CSRF-Token"
+	csrfField  = "csrf"
+)
+
+const minPasswordLength = 8
+
+var errInvalidLogin = errors.New("Invalid username or password")
+
+// dummyHash is compared with the password when there's no such user, so that logging in takes
+// as long whether the user exists or not.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("tesla_journal"), bcrypt.DefaultCost)
+
+type contextKey string
+
+const sessionKey contextKey = "session"
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("The password must be at least %d characters long", minPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+
+	return string(hash), err
+}
+
+// checkLogin returns the user if the password is theirs.
+func checkLogin(username, password string) (User, error) {
+	user, err := store.GetUser(username)
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return user, errInvalidLogin
+	} else if err != nil {
+		return user, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return user, errInvalidLogin
+	}
+
+	return user, nil
+}
+
+func setCookie(w http.ResponseWriter, r *http.Request, name, value string, expires time.Time, httpOnly bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: httpOnly,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// startSession logs the user in, setting the session cookie and the CSRF cookie.
+func startSession(w http.ResponseWriter, r *http.Request, user User) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	csrfToken, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	session := Session{
+		TokenHash: hashToken(token),
+		UserId:    user.Id,
+		Username:  user.Username,
+		CSRFToken: csrfToken,
+		Expires:   time.Now().Add(time.Duration(config.Auth.SessionHours) * time.Hour),
+	}
+
+	err = store.CreateSession(session)
+	if err != nil {
+		return err
+	}
+
+	setCookie(w, r, sessionCookie, token, session.Expires, true)
+	setCookie(w, r, csrfCookie, csrfToken, session.Expires, false)
+
+	return nil
+}
+
+func sessionOf(r *http.Request) (Session, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return Session{}, err
+	}
+
+	return store.GetSession(hashToken(cookie.Value))
+}
+
+// currentSession returns the session of a request let through by authenticate.
+func currentSession(r *http.Request) (Session, bool) {
+	session, ok := r.Context().Value(sessionKey).(Session)
+
+	return session, ok
+}
+
+// currentUser returns the name of the logged in user; empty if logging in isn't required.
+func currentUser(r *http.Request) string {
+	session, _ := currentSession(r)
+
+	return session.Username
+}
+
+func isPublicPath(path string) bool {
+	return path == "/login" || path == "/api/openapi.json" || strings.HasPrefix(path, "/static/")
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// authenticate only lets requests from logged in users through. Requests changing anything must
+// also carry the CSRF token of the session, in the X-CSRF-Token header or the csrf form field.
+// Scripts may use an API token instead, see tokens.go.
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if token, ok := bearerToken(r); ok {
+			authenticateToken(w, r, token, next)
+			return
+		}
+
+		session, err := sessionOf(r)
+		if err != nil {
+			if err != http.ErrNoCookie && err != sql.ErrNoRows {
+				log.Println("Error retrieving session: " + err.Error())
+			}
+
+			// pages send the browser to the login page, scripts get an error:
+			if strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+
+			writeError(w, r, http.StatusUnauthorized, "not_logged_in", errors.New("Not logged in"))
+			return
+		}
+
+		if !isSafeMethod(r.Method) {
+			token := r.Header.Get(csrfHeader)
+			if token == "" {
+				token = r.PostFormValue(csrfField)
+			}
+
+			if subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) != 1 {
+				writeError(w, r, http.StatusForbidden, "invalid_csrf", errors.New("Missing or invalid CSRF token"))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey, session)))
+	})
+}
+
+// localPath returns the path to go to after logging in, which must be on this site.
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+
+	return next
+}
+
+type LoginData struct {
+	Next     string
+	Username string
+	Failed   bool
+}
+
+func serveLogin(w http.ResponseWriter, r *http.Request) {
+	err := loginTemplate[requestLocale(r)].Execute(w, LoginData{Next: localPath(r.URL.Query().Get("next"))})
+	if err != nil {
+		log.Println("Error while executing template: " + err.Error())
+	}
+}
+
+func postLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, translate(requestLocale(r), "bad_request"), http.StatusBadRequest)
+		return
+	}
+
+	data := LoginData{Next: localPath(r.Form.Get("next")), Username: r.Form.Get("username")}
+
+	user, err := checkLogin(data.Username, r.Form.Get("password"))
+	if err == nil {
+		err = startSession(w, r, user)
+	}
+
+	if err != nil {
+		if err != errInvalidLogin {
+			log.Println("Error logging in: " + err.Error())
+		}
+
+		data.Failed = true
+		w.WriteHeader(http.StatusUnauthorized)
+
+		err = loginTemplate[requestLocale(r)].Execute(w, data)
+		if err != nil {
+			log.Println("Error while executing template: " + err.Error())
+		}
+		return
+	}
+
+	http.Redirect(w, r, data.Next, http.StatusSeeOther)
+}
+
+func postLogout(w http.ResponseWriter, r *http.Request) {
+	if session, ok := currentSession(r); ok {
+		err := store.DeleteSession(session.TokenHash)
+		if err != nil {
+			log.Println("Error deleting session: " + err.Error())
+		}
+	}
+
+	setCookie(w, r, sessionCookie, "", time.Unix(0, 0), true)
+	setCookie(w, r, csrfCookie, "", time.Unix(0, 0), false)
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func readPassword(in *bufio.Reader, out io.Writer) (string, error) {
+	fmt.Fprint(out, "Password: ")
+
+	password, err := in.ReadString('\n')
+	if err != nil && !(err == io.EOF && password != "") {
+		return "", err
+	}
+
+	return strings.TrimRight(password, "\r\n"), nil
+}
+
+// runUserCommand implements the user subcommand for managing those who can log in. Passwords
+// are read from in.
+func runUserCommand(args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("user", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: tesla_journal user list | add <name> [owner|driver|accountant] | passwd <name> | role <name> <role> | locale <name> [language] | delete <name>")
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	args = flags.Args()
+	if len(args) == 1 && args[0] == "list" {
+		users, err := store.GetUsers()
+		if err != nil {
+			return err
+		}
+
+		for _, u := range users {
+			fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", u.Username, u.Role, u.Created.Format("2006-01-02"), u.Locale)
+		}
+
+		return nil
+	}
+
+	if len(args) < 2 || strings.TrimSpace(args[1]) == "" {
+		flags.Usage()
+		return errors.New("Invalid arguments")
+	}
+
+	username := strings.TrimSpace(args[1])
+
+	// without a language, the browser chooses:
+	if args[0] == "locale" && len(args) <= 3 {
+		var lang string
+		if len(args) == 3 {
+			lang = args[2]
+		}
+
+		if lang != "" && !isLocale(lang) {
+			return fmt.Errorf("Unknown language: %s; there are %s", lang, strings.Join(locales(), ", "))
+		}
+
+		err = store.SetLocale(username, lang)
+		if err == sql.ErrNoRows {
+			return errors.New("Unknown user: " + username)
+		}
+
+		return err
+	}
+
+	// users are owners unless another role is given:
+	role := roleOwner
+	if len(args) == 3 && (args[0] == "add" || args[0] == "role") {
+		role = args[2]
+	} else if len(args) != 2 || args[0] == "role" {
+		flags.Usage()
+		return errors.New("Invalid arguments")
+	}
+
+	if !isRole(role) {
+		return errors.New("Unknown role: " + role)
+	}
+
+	switch args[0] {
+	case "add", "passwd":
+		password, err := readPassword(bufio.NewReader(in), out)
+		if err != nil {
+			return err
+		}
+
+		hash, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+
+		if args[0] == "add" {
+			_, err = store.CreateUser(User{Username: username, PasswordHash: hash, Role: role})
+		} else {
+			err = store.SetPassword(username, hash)
+		}
+		if err == sql.ErrNoRows {
+			return errors.New("Unknown user: " + username)
+		}
+
+		return err
+	case "role":
+		err = store.SetRole(username, role)
+		if err == sql.ErrNoRows {
+			return errors.New("Unknown user: " + username)
+		}
+
+		return err
+	case "delete":
+		err = store.DeleteUser(username)
+		if err == sql.ErrNoRows {
+			return errors.New("Unknown user: " + username)
+		}
+
+		return err
+	}
+
+	flags.Usage()
+	return errors.New("Unknown user command: " + args[0])
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// useAuth requires logging in for the duration of the test, with the user anna.
+func useAuth(t *testing.T) {
+	t.Helper()
+
+	useDefaultConfig(t)
+	config.Auth.Enabled = true
+
+	hash, err := hashPassword("hemligt lösenord")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.CreateUser(User{Username: "anna", PasswordHash: hash, Role: roleOwner})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// login logs in and returns the session and CSRF cookies.
+func login(t *testing.T, username, password string) []*http.Cookie {
+	t.Helper()
+
+	w := postForm(t, "/login", url.Values{"username": {username}, "password": {password}, "next": {"/"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected to be logged in, got status %d", w.Code)
+	}
+
+	return w.Result().Cookies()
+}
+
+func cookie(cookies []*http.Cookie, name string) string {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+
+	return ""
+}
+
+func serve(r *http.Request, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func classifyRequest(csrf string) *http.Request {
+	form := url.Values{"action": {"classify"}, "classification": {"2"}, "drive": {"3"}, "year": {"2021"}, "month": {"3"}, "car": {"1"}}
+	if csrf != "" {
+		form.Set("csrf", csrf)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/action", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return r
+}
+
+func TestLoginRequired(t *testing.T) {
+	useFixtures(t)
+	useAuth(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/details/3", nil)
+	r.Header.Set("Accept", "text/html")
+	w := serve(r, nil)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login?next=%2Fdetails%2F3" {
+		t.Errorf("Expected pages to redirect to the login page, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = serve(httptest.NewRequest(http.MethodGet, "/drive/3", nil), nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected scripts to get status 401, got %d", w.Code)
+	}
+
+	w = serve(classifyRequest(""), nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected changes to be refused, got %d", w.Code)
+	}
+
+	w = serve(httptest.NewRequest(http.MethodGet, "/login", nil), nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the login page to be public, got %d", w.Code)
+	}
+
+	// a forged session cookie is no good either:
+	w = serve(httptest.NewRequest(http.MethodGet, "/drive/3", nil), []*http.Cookie{{Name: sessionCookie, Value: "forged"}})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown session to be refused, got %d", w.Code)
+	}
+}
+
+func TestLoginFailures(t *testing.T) {
+	useFixtures(t)
+	useAuth(t)
+
+	for _, credentials := range [][2]string{{"anna", "fel lösenord"}, {"bertil", "hemligt lösenord"}, {"", ""}} {
+		w := postForm(t, "/login", url.Values{"username": {credentials[0]}, "password": {credentials[1]}})
+		if w.Code != http.StatusUnauthorized || cookie(w.Result().Cookies(), sessionCookie) != "" {
+			t.Errorf("Expected %q to be refused, got %d", credentials[0], w.Code)
+		}
+	}
+}
+
+func TestSessionAndCSRF(t *testing.T) {
+	s := useFixtures(t)
+	useAuth(t)
+
+	cookies := login(t, "anna", "hemligt lösenord")
+	csrf := cookie(cookies, csrfCookie)
+	if cookie(cookies, sessionCookie) == "" || csrf == "" {
+		t.Fatalf("Expected session and CSRF cookies, got %v", cookies)
+	}
+
+	// only the hash of the token is stored:
+	if _, stored := s.sessions[cookie(cookies, sessionCookie)]; stored {
+		t.Error("Expected the session token not to be stored as is")
+	}
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/drive/3", nil), cookies)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected a logged in user to get drives, got %d", w.Code)
+	}
+
+	w = serve(classifyRequest(""), cookies)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected a change without the CSRF token to be refused, got %d", w.Code)
+	}
+
+	w = serve(classifyRequest("fel"), cookies)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected a change with the wrong CSRF token to be refused, got %d", w.Code)
+	}
+
+	w = serve(classifyRequest(csrf), cookies)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected a change with the CSRF token to succeed, got %d", w.Code)
+	}
+
+	r := classifyRequest("")
+	r.Header.Set(csrfHeader, csrf)
+	w = serve(r, cookies)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the CSRF token to be accepted in a header, got %d", w.Code)
+	}
+
+	// changes are logged with the name of the user:
+	history, _ := store.GetAuditHistory(auditDrive, 3)
+	if len(history) != 1 || history[0].User != "anna" {
+		t.Errorf("Expected the change to be logged as made by anna, got %+v", history)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/logout", nil)
+	r.Header.Set(csrfHeader, csrf)
+	w = serve(r, cookies)
+	if w.Code != http.StatusSeeOther || len(s.sessions) != 0 {
+		t.Errorf("Expected the session to end, got %d and %v", w.Code, s.sessions)
+	}
+
+	w = serve(httptest.NewRequest(http.MethodGet, "/drive/3", nil), cookies)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the session cookie to be useless after logging out, got %d", w.Code)
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	w := serve(classifyRequest(""), nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected changes without logging in, got %d", w.Code)
+	}
+
+	w = serve(httptest.NewRequest(http.MethodGet, "/login", nil), nil)
+	if w.Code != http.StatusNotFound && w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected no login page, got %d", w.Code)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"/details/3":        "/details/3",
+		"/?year=2021":       "/?year=2021",
+		"":                  "/",
+		"//evil.example":    "/",
+		"/\\evil.example":   "/",
+		"https://evil.test": "/",
+	}
+
+	for next, expected := range tests {
+		if path := localPath(next); path != expected {
+			t.Errorf("%q: expected %q, got %q", next, expected, path)
+		}
+	}
+}
+
+func TestUserCommand(t *testing.T) {
+	s := useFixtures(t)
+
+	var out bytes.Buffer
+	err := runUserCommand([]string{"add", "anna"}, strings.NewReader("hemligt lösenord\n"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runUserCommand([]string{"add", "bertil"}, strings.NewReader("kort\n"), &out)
+	if err == nil {
+		t.Error("Expected a short password to be refused")
+	}
+
+	_, err = checkLogin("anna", "hemligt lösenord")
+	if err != nil {
+		t.Errorf("Expected anna to be able to log in, got %v", err)
+	}
+
+	err = runUserCommand([]string{"passwd", "anna"}, strings.NewReader("ett annat lösenord"), &out)
+	if err == nil {
+		_, err = checkLogin("anna", "ett annat lösenord")
+	}
+	if err != nil {
+		t.Errorf("Expected the password to be changed, got %v", err)
+	}
+
+	out.Reset()
+	runUserCommand([]string{"list"}, nil, &out)
+	if !strings.HasPrefix(out.String(), "anna\t") {
+		t.Errorf("Expected anna to be listed, got %q", out.String())
+	}
+
+	err = runUserCommand([]string{"delete", "anna"}, nil, &out)
+	if err != nil || len(s.users) != 0 {
+		t.Errorf("Expected anna to be deleted, got %v", err)
+	}
+
+	if runUserCommand([]string{"delete", "anna"}, nil, &out) == nil {
+		t.Error("Expected deleting an unknown user to fail")
+	}
+}
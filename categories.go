@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var cssClassPattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+var errCategoryInUse = errors.New("Category is in use by one or more drives")
+
+func getCategoryMap() (map[int]Category, error) {
+	categoryMap := make(map[int]Category)
+
+	categories, err := store.GetCategories()
+	if err != nil {
+		return categoryMap, err
+	}
+
+	for _, c := range categories {
+		categoryMap[c.Id] = c
+	}
+
+	return categoryMap, nil
+}
+
+// categoryLabel returns the label of a category in the language. The built-in categories are
+// translated for as long as they keep the label they were created with; the others, and the
+// built-in ones once renamed, are displayed as labelled.
+func categoryLabel(c Category, lang string) string {
+	key := "category_" + c.CssClass
+	if builtIn, ok := catalogs[defaultLocale][key]; ok && c.Label == builtIn {
+		return translate(lang, key)
+	}
+
+	return c.Label
+}
+
+// localizeCategories replaces the labels of the categories with those to display in the
+// language.
+func localizeCategories(categories []Category, lang string) {
+	for i := range categories {
+		categories[i].Label = categoryLabel(categories[i], lang)
+	}
+}
+
+// classificationStrings returns the CSS class and label to display for a classification in
+// the language. Drives without a classification, or classified with a category that no longer
+// exists, are displayed as unknown.
+func classificationStrings(classification sql.NullInt32, categories map[int]Category, lang string) (string, string) {
+	if classification.Valid {
+		if c, ok := categories[int(classification.Int32)]; ok {
+			return c.CssClass, categoryLabel(c, lang)
+		}
+	}
+
+	return "unknown", ""
+}
+
+func parseCategoryForm(r *http.Request) (Category, error) {
+	var c Category
+
+	err := r.ParseForm()
+	if err != nil {
+		return c, err
+	}
+
+	c.Label = strings.TrimSpace(r.Form.Get("label"))
+	if c.Label == "" {
+		return c, errors.New("A category needs a label")
+	}
+
+	c.CssClass = strings.TrimSpace(r.Form.Get("cssclass"))
+	if !cssClassPattern.MatchString(c.CssClass) {
+		return c, errors.New("Invalid CSS class: " + c.CssClass)
+	}
+
+	c.Deductible, _ = strconv.ParseBool(r.Form.Get("deductible"))
+	getIntParamPost(r, "sortorder", &c.SortOrder)
+
+	return c, nil
+}
+
+func getCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categories, err := store.GetCategories()
+	if err != nil {
+		log.Println("Error retrieving categories: " + err.Error())
+		http.Error(w, "Error retrieving categories", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(categories)
+}
+
+func postCategory(w http.ResponseWriter, r *http.Request) {
+	c, err := parseCategoryForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c, err = store.CreateCategory(c)
+	if err != nil {
+		log.Println("Error creating category: " + err.Error())
+		http.Error(w, "Error creating category", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+func putCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := getIdVar(r)
+	if err != nil {
+		http.Error(w, "Invalid category id", http.StatusBadRequest)
+		return
+	}
+
+	c, err := parseCategoryForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.Id = id
+
+	err = store.UpdateCategory(c)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Println("Error updating category: " + err.Error())
+		http.Error(w, "Error updating category", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(c)
+}
+
+func removeCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := getIdVar(r)
+	if err != nil {
+		http.Error(w, "Invalid category id", http.StatusBadRequest)
+		return
+	}
+
+	err = store.DeleteCategory(id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err == errCategoryInUse {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Error deleting category %d: %s\n", id, err.Error())
+		http.Error(w, "Error deleting category", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
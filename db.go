@@ -5,11 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"sort"
 	"strings"
 	"time"
 
-	"github.com/goodsign/monday"
 	"github.com/lib/pq"
 )
 
@@ -35,11 +33,6 @@ func connectDB(conf Config) error {
 
 	fmt.Println("Connected to database " + conn.DB)
 
-	err = createTables()
-	if err != nil {
-		return err
-	}
-
 	return nil
 }
 
@@ -55,110 +48,451 @@ func db() *sql.DB {
 	return database
 }
 
-func changeClassification(classification int, drives []string, groupedDrives []string) (*time.Time, *time.Time, error) {
-	if (len(drives) + len(groupedDrives)) == 0 {
-		return nil, nil, errors.New("Attempt to classify drives failed; no drive ids or grouped drive ids specified")
+// dbTime formats an instant for comparing with the timestamps of Teslamate, which are in UTC.
+func dbTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000")
+}
+
+// postgresStore is the JournalStore working on the Teslamate database. Within a transaction
+// all statements go through tx.
+type postgresStore struct {
+	tx *sql.Tx
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s postgresStore) conn() queryer {
+	if s.tx != nil {
+		return s.tx
 	}
 
-	groupedDriveIds, err := getDriveIdsForGroups(groupedDrives)
+	return db()
+}
+
+// Transaction runs fn in a transaction, committing it if fn succeeds and rolling it back
+// otherwise. A transaction started within another one becomes part of the outer one.
+func (s postgresStore) Transaction(fn func(JournalStore) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := db().Begin()
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
-	ids := append(drives, groupedDriveIds...)
+	err = fn(postgresStore{tx: tx})
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			log.Println("Error rolling back transaction: " + rollbackErr.Error())
+		}
 
-	statement := "INSERT INTO public.tj_classifications (drive_id, classification) VALUES "
-	for _, driveId := range ids {
-		statement += fmt.Sprintf("(%s, %d),", driveId, classification)
+		return err
 	}
-	statement = strings.TrimRight(statement, ",")
-	statement += " ON CONFLICT(drive_id) DO UPDATE SET classification = excluded.classification;"
 
-	_, err = db().Exec(statement)
+	return tx.Commit()
+}
+
+// driveStatement returns the query for drives fulfilling the condition.
+func driveStatement(condition string) string {
+	return `WITH data AS (
+        SELECT
+        round(extract(epoch FROM drives.start_date)) * 1000 AS start_date_ts,
+        round(extract(epoch FROM drives.end_date)) * 1000 AS end_date_ts,
+        car.id as car_id,
+        start_km,
+        end_km,
+        CASE WHEN start_geofence.id IS NULL THEN CONCAT('new?lat=', start_position.latitude, '&lng=', start_position.longitude)
+        WHEN start_geofence.id IS NOT NULL THEN CONCAT(start_geofence.id, '/edit')
+        END as start_path,
+        CASE WHEN end_geofence.id IS NULL THEN CONCAT('new?lat=', end_position.latitude, '&lng=', end_position.longitude)
+        WHEN end_geofence.id IS NOT NULL THEN CONCAT(end_geofence.id, '/edit')
+        END as end_path,
+        drives.id as drive_id,
+        classification.classification,
+        grouped_drive.id AS grouped_drive_id,
+        drives.start_date,
+        drives.end_date,
+        drives.duration_min,
+        COALESCE(start_geofence.name, CONCAT_WS(', ', COALESCE(COALESCE(start_address.name, ''), nullif(CONCAT_WS(' ', start_address.road, start_address.house_number), '')), start_address.city)) AS start_address,
+        COALESCE(end_geofence.name, CONCAT_WS(', ', COALESCE(COALESCE(end_address.name, ''), nullif(CONCAT_WS(' ', end_address.road, end_address.house_number), '')), end_address.city)) AS end_address,
+        drives.distance,
+        comment.comment,
+        drives.start_geofence_id,
+        drives.end_geofence_id,
+        classification.rule_id,
+        drive_driver.driver_id
+        FROM drives
+        LEFT JOIN addresses start_address ON start_address_id = start_address.id
+        LEFT JOIN addresses end_address ON end_address_id = end_address.id
+        LEFT JOIN positions start_position ON start_position_id = start_position.id
+        LEFT JOIN positions end_position ON end_position_id = end_position.id
+        LEFT JOIN geofences start_geofence ON start_geofence_id = start_geofence.id
+        LEFT JOIN geofences end_geofence ON end_geofence_id = end_geofence.id
+        LEFT JOIN cars car ON car.id = drives.car_id
+        LEFT JOIN tj_classifications classification ON classification.drive_id = drives.id
+        LEFT JOIN tj_grouped_drives grouped_drive ON drives.car_id=grouped_drive.car_id AND drives.id = ANY(grouped_drive.drive_ids)
+        LEFT JOIN tj_comments comment ON comment.drive_id = drives.id
+        LEFT JOIN tj_drive_drivers drive_driver ON drive_driver.drive_id = drives.id
+        WHERE ` + condition + `
+        ORDER BY drives.start_date DESC
+    )
+    SELECT
+    drive_id,
+    car_id,
+    start_date,
+    end_date,
+    duration_min,
+    start_address,
+    end_address,
+    round(start_km::numeric) AS start_odo,
+    round(end_km::numeric) AS end_odo,
+    distance,
+    classification,
+    grouped_drive_id,
+    comment,
+    start_geofence_id,
+    end_geofence_id,
+    rule_id,
+    driver_id
+    FROM data;`
+}
+
+func scanDrive(row interface{ Scan(...interface{}) error }) (Drive, error) {
+	var drive Drive
+
+	err := row.Scan(&drive.Id, &drive.CarId, &drive.StartDate, &drive.EndDate, &drive.Duration, &drive.StartAddress, &drive.EndAddress, &drive.StartOdometer, &drive.EndOdometer, &drive.Distance, &drive.Classification, &drive.GroupId, &drive.Comment, &drive.StartGeofenceId, &drive.EndGeofenceId, &drive.RuleId, &drive.DriverId)
+
+	return drive, err
+}
+
+func (s postgresStore) GetDrives(carId int, from, to time.Time) ([]Drive, error) {
+	statement := driveStatement("drives.car_id = $1 AND drives.start_date >= $2::timestamp AND drives.start_date < $3::timestamp AND drives.start_date IS NOT NULL AND drives.end_date IS NOT NULL")
+
+	var drives []Drive
+
+	rows, err := s.conn().Query(statement, carId, dbTime(from), dbTime(to))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	if len(groupedDrives) != 0 {
-		statement = fmt.Sprintf(`
-        UPDATE public.tj_grouped_drives
-        SET classification=%d
-        WHERE id=ANY('{`, classification)
-		for _, gid := range groupedDrives {
-			statement += gid + ","
+	for rows.Next() {
+		drive, err := scanDrive(rows)
+		if err != nil {
+			return nil, err
 		}
-		statement = strings.TrimRight(statement, ",")
-		statement += `}');`
 
-		_, err = db().Exec(statement)
+		drives = append(drives, drive)
+	}
+
+	return drives, rows.Err()
+}
+
+func (s postgresStore) GetDrivesPage(carId, driverId int, from, to time.Time, classification, limit, offset int) ([]Drive, int, error) {
+	condition := `drives.car_id = $1 AND drives.start_date >= $2::timestamp AND drives.start_date < $3::timestamp AND drives.start_date IS NOT NULL AND drives.end_date IS NOT NULL
+        AND ($4 = 0 OR drive_driver.driver_id = $4)
+        AND ($5 = 0 OR ($5 = -1 AND classification.classification IS NULL) OR classification.classification = $5)`
+
+	var total int
+
+	statement := `
+    SELECT count(*)
+    FROM drives
+    LEFT JOIN tj_classifications classification ON classification.drive_id = drives.id
+    LEFT JOIN tj_drive_drivers drive_driver ON drive_driver.drive_id = drives.id
+    WHERE ` + condition
+
+	err := s.conn().QueryRow(statement, carId, dbTime(from), dbTime(to), driverId, classification).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	statement = strings.TrimSuffix(driveStatement(condition), ";") + `
+    ORDER BY start_date DESC
+    LIMIT $6 OFFSET $7;`
+
+	rows, err := s.conn().Query(statement, carId, dbTime(from), dbTime(to), driverId, classification, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var drives []Drive
+	for rows.Next() {
+		drive, err := scanDrive(rows)
 		if err != nil {
-			return nil, nil, err
+			return nil, 0, err
 		}
+
+		drives = append(drives, drive)
 	}
 
-	return getAffectedDates(drives, groupedDrives)
+	return drives, total, rows.Err()
 }
 
-func getDriveIdsForGroups(groupedDrives []string) ([]string, error) {
-	var groupedDriveIds []string
+func (s postgresStore) GetDriveById(id int) (Drive, error) {
+	statement := driveStatement("drives.id = $1")
+
+	return scanDrive(s.conn().QueryRow(statement, id))
+}
+
+func (s postgresStore) GetPositions(driveIds []int64) ([]Position, error) {
+	var positions []Position
 
 	statement := `
-    SELECT drive_ids::text[]
-    FROM tj_grouped_drives
-    WHERE id=ANY('{`
-	for _, gid := range groupedDrives {
-		statement += gid + ","
+	SELECT
+	    positions.longitude,
+        positions.latitude
+	FROM
+	    positions, drives
+	WHERE
+		drives.id = ANY($1) AND
+        positions.date BETWEEN drives.start_date AND drives.end_date
+	ORDER BY
+	    positions.date ASC;
+	`
+
+	rows, err := s.conn().Query(statement, pq.Array(driveIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pos Position
+
+		err := rows.Scan(&pos.Longitude, &pos.Latitude)
+		if err != nil {
+			return nil, err
+		}
+
+		positions = append(positions, pos)
 	}
-	statement = strings.TrimRight(statement, ",")
-	statement += `}');`
 
-	rows, _ := db().Query(statement)
+	return positions, rows.Err()
+}
+
+func (s postgresStore) GetCars() ([]Car, error) {
+	var cars []Car
+
+	statement := "SELECT id, model, name FROM cars ORDER BY id ASC;"
+
+	rows, err := s.conn().Query(statement)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var ids pq.StringArray
+		var car Car
 
-		err := rows.Scan(&ids)
+		err := rows.Scan(&car.Id, &car.Model, &car.Name)
 		if err != nil {
 			return nil, err
 		}
 
-		groupedDriveIds = append(groupedDriveIds, ids...)
+		cars = append(cars, car)
 	}
 
-	return groupedDriveIds, rows.Err()
+	return cars, rows.Err()
 }
 
-func ungroupDrives(car int, groupedDrives []string) (*time.Time, *time.Time, error) {
-	if len(groupedDrives) == 0 {
-		return nil, nil, errors.New("Attempt to ungroup drives failed; no group drive ids specified")
+func (s postgresStore) GetUnitOfLength() (string, error) {
+	// older versions of TeslaMate have no settings table:
+	var exists bool
+	err := s.conn().QueryRow("SELECT to_regclass('public.settings') IS NOT NULL;").Scan(&exists)
+	if err != nil || !exists {
+		return "", err
+	}
+
+	var unit string
+	err = s.conn().QueryRow("SELECT unit_of_length::text FROM public.settings ORDER BY id ASC LIMIT 1;").Scan(&unit)
+	if err == sql.ErrNoRows {
+		return "", nil
 	}
 
-	from, to, _ := getAffectedDates([]string{}, groupedDrives)
+	return unit, err
+}
 
+func (s postgresStore) GetFirstAndLastYears() (int, int, error) {
 	statement := `
-    DELETE FROM tj_grouped_drives
-    WHERE id=ANY('{`
-	for _, gid := range groupedDrives {
-		statement += gid + ","
+    SELECT
+        min(start_date) as min_date,
+        max(start_date) as max_date
+    FROM drives`
+
+	var minDate, maxDate sql.NullTime
+
+	row := s.conn().QueryRow(statement)
+	err := row.Scan(&minDate, &maxDate)
+	if err != nil {
+		return 0, 0, err
 	}
-	statement = strings.TrimRight(statement, ",")
-	statement += "}');"
 
-	_, err := db().Exec(statement)
+	// without drives, there are no years:
+	if !minDate.Valid || !maxDate.Valid {
+		return 0, 0, sql.ErrNoRows
+	}
+
+	return convertTime(minDate.Time).Year(), convertTime(maxDate.Time).Year(), nil
+}
+
+func (s postgresStore) GetTotals(carId, driverId int, from, to time.Time) (Totals, error) {
+	statement := `
+    SELECT
+        *,
+        duration_total - (duration_business + duration_private) as duration_unknown,
+        distance_total - (distance_business + distance_private) as distance_unknown
+    FROM
+        (SELECT
+            COALESCE(sum(case when category.deductible then drives.duration_min else 0 end), 0) as duration_business,
+            COALESCE(sum(case when category.deductible then drives.distance else 0 end), 0) as distance_business,
+            COALESCE(sum(case when not category.deductible then drives.duration_min else 0 end), 0) as duration_private,
+            COALESCE(sum(case when not category.deductible then drives.distance else 0 end), 0) as distance_private,
+            COALESCE(sum(drives.duration_min), 0) as duration_total,
+            COALESCE(sum(drives.distance), 0) as distance_total,
+            COALESCE(sum(case when dd.driver_id IS NULL then drives.distance else 0 end), 0) as distance_unassigned
+        FROM drives
+        LEFT JOIN tj_classifications c ON c.drive_id=drives.id
+        LEFT JOIN tj_categories category ON category.id=c.classification
+        LEFT JOIN tj_drive_drivers dd ON dd.drive_id=drives.id
+    WHERE drives.car_id=$1 AND drives.start_date >= $2::timestamp AND drives.start_date < $3::timestamp AND ($4 = 0 OR dd.driver_id = $4)) a`
+
+	var t Totals
+
+	row := s.conn().QueryRow(statement, carId, dbTime(from), dbTime(to), driverId)
+	err := row.Scan(&t.TotalBusinessDuration, &t.TotalBusinessDistance, &t.TotalPrivateDuration, &t.TotalPrivateDistance, &t.TotalDuration, &t.TotalDistance, &t.UnassignedDistance, &t.UnclassifiedDuration, &t.UnclassifiedDistance)
 	if err != nil {
-		return nil, nil, err
+		return t, err
 	}
 
-	return from, to, err
+	return t, nil
+}
+
+func (s postgresStore) GetDateRange(driveIds []int64, groupedDriveIds []int64) (time.Time, time.Time, error) {
+	statement := `
+    SELECT
+        min(start_date) as min_date,
+        max(end_date) as max_date
+    FROM
+    (
+        SELECT start_date, end_date FROM drives WHERE id = ANY($1)
+        UNION ALL
+        SELECT start_date, end_date FROM tj_grouped_drives WHERE id = ANY($2)
+    ) d`
+
+	var minD, maxD sql.NullTime
+
+	row := s.conn().QueryRow(statement, pq.Array(driveIds), pq.Array(groupedDriveIds))
+	err := row.Scan(&minD, &maxD)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	if !minD.Valid || !maxD.Valid {
+		return time.Time{}, time.Time{}, errors.New("Error retrieving first/last dates of range of drives")
+	}
+
+	return minD.Time, maxD.Time, nil
+}
+
+func scanGroupedDrives(row interface{ Scan(...interface{}) error }) (GroupedDrives, error) {
+	var gd GroupedDrives
+	var startAddress, endAddress sql.NullString
+
+	err := row.Scan(&gd.Id, &gd.CarId, &gd.DriveIds, &gd.StartDate, &gd.EndDate, &startAddress, &endAddress, &gd.Distance, &gd.Duration, &gd.Classification, &gd.Comment, &gd.StartOdometer, &gd.EndOdometer, &gd.DriverId)
+	if err != nil {
+		return gd, err
+	}
+
+	gd.StartAddress = startAddress.String
+	gd.EndAddress = endAddress.String
+
+	return gd, nil
+}
+
+func (s postgresStore) GetGroupedDrivesById(id int) (GroupedDrives, error) {
+	statement := `
+    SELECT gd.id, gd.car_id, gd.drive_ids, gd.start_date, gd.end_date, gd.start_address, gd.end_address,
+    gd.distance, gd.duration_min, gd.classification, gd.comment, round(MIN(d.start_km)), round(MAX(d.end_km)),
+    CASE WHEN count(DISTINCT dd.driver_id) = 1 AND count(dd.driver_id) = count(d.id) THEN min(dd.driver_id) END
+	FROM tj_grouped_drives AS gd
+    LEFT JOIN drives d ON d.id = ANY(gd.drive_ids)
+    LEFT JOIN tj_drive_drivers dd ON dd.drive_id = d.id
+	WHERE gd.id = $1
+	GROUP BY gd.id`
+
+	return scanGroupedDrives(s.conn().QueryRow(statement, id))
 }
 
-func groupDrives(car int, drives []string) (*time.Time, *time.Time, error) {
-	if len(drives) == 0 {
-		return nil, nil, errors.New("Attempt to group drives failed; no drive ids specified")
+func (s postgresStore) GetGroupedDrives(carId int, from, to time.Time) ([]GroupedDrives, error) {
+	var groupedDrives []GroupedDrives
+
+	statement := `
+    SELECT gd.id, gd.car_id, gd.drive_ids, gd.start_date, gd.end_date, gd.start_address, gd.end_address,
+    gd.distance, gd.duration_min, gd.classification, gd.comment, round(MIN(d.start_km)), round(MAX(d.end_km)),
+    CASE WHEN count(DISTINCT dd.driver_id) = 1 AND count(dd.driver_id) = count(d.id) THEN min(dd.driver_id) END
+	FROM tj_grouped_drives AS gd
+    LEFT JOIN drives d ON d.id = ANY(gd.drive_ids)
+    LEFT JOIN tj_drive_drivers dd ON dd.drive_id = d.id
+    WHERE gd.car_id = $1 AND gd.start_date >= $2::timestamp AND gd.start_date < $3::timestamp
+    GROUP BY gd.id`
+
+	rows, err := s.conn().Query(statement, carId, dbTime(from), dbTime(to))
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	statement := fmt.Sprintf(`
+	for rows.Next() {
+		gd, err := scanGroupedDrives(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		groupedDrives = append(groupedDrives, gd)
+	}
+
+	return groupedDrives, rows.Err()
+}
+
+func (s postgresStore) GetDriveIdsForGroups(groupedDrives []int64) ([]int64, error) {
+	var groupedDriveIds []int64
+
+	statement := `
+    SELECT drive_ids
+    FROM tj_grouped_drives
+    WHERE id=ANY($1);`
+
+	rows, err := s.conn().Query(statement, pq.Array(groupedDrives))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ids pq.Int64Array
+
+		err := rows.Scan(&ids)
+		if err != nil {
+			return nil, err
+		}
+
+		groupedDriveIds = append(groupedDriveIds, ids...)
+	}
+
+	return groupedDriveIds, rows.Err()
+}
+
+func (s postgresStore) GroupDrives(car int, drives []int64) error {
+	statement := `
     SELECT
     min(start_date) AS start_date,
     max(end_date) AS end_date,
@@ -184,12 +518,7 @@ func groupDrives(car int, drives []string) (*time.Time, *time.Time, error) {
             LEFT JOIN geofences start_geofence ON start_geofence_id = start_geofence.id
             LEFT JOIN geofences end_geofence ON end_geofence_id = end_geofence.id
             LEFT JOIN tj_classifications classification ON classification.drive_id = drives.id
-        WHERE drives.car_id=%d AND drives.id=ANY('{`, car)
-	for _, driveId := range drives {
-		statement += driveId + ","
-	}
-	statement = strings.TrimRight(statement, ",")
-	statement += `}')
+        WHERE drives.car_id=$1 AND drives.id=ANY($2)
     ) c;`
 
 	var startDate, endDate time.Time
@@ -198,773 +527,874 @@ func groupDrives(car int, drives []string) (*time.Time, *time.Time, error) {
 	var startAddress, endAddress string
 	var classification sql.NullInt32
 
-	row := db().QueryRow(statement)
+	row := s.conn().QueryRow(statement, car, pq.Array(drives))
 	err := row.Scan(&startDate, &endDate, &duration, &distance, &startAddress, &endAddress, &classification)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
-	statement = fmt.Sprintf(`
+	statement = `
     INSERT INTO public.tj_grouped_drives
     (car_id, drive_ids, start_date, end_date, start_address, end_address, distance, duration_min, classification)
     VALUES
-    (%d, '{`, car)
-	for _, driveId := range drives {
-		statement += driveId + ","
+    ($1, $2, $3::timestamp, $4::timestamp, $5, $6, $7, $8, $9);`
+
+	_, err = s.conn().Exec(statement, car, pq.Array(drives), dbTime(startDate), dbTime(endDate),
+		startAddress, endAddress, distance, duration, classification)
+
+	return err
+}
+
+func (s postgresStore) UngroupDrives(groupedDrives []int64, copyComment bool) error {
+	// hand the purpose of the group down to its drives before the group disappears:
+	if copyComment {
+		statement := `
+        INSERT INTO public.tj_comments (drive_id, comment)
+        SELECT unnest(drive_ids), comment
+        FROM tj_grouped_drives
+        WHERE comment IS NOT NULL AND id=ANY($1)
+        ON CONFLICT(drive_id) DO UPDATE SET comment = excluded.comment;`
+
+		_, err := s.conn().Exec(statement, pq.Array(groupedDrives))
+		if err != nil {
+			return err
+		}
 	}
-	statement = strings.TrimRight(statement, ",")
-	statement += "}', "
-	statement += "'" + startDate.Format("2006-01-02 15:04:05.000") + "'::timestamp, "
-	statement += "'" + endDate.Format("2006-01-02 15:04:05.000") + "'::timestamp, "
-	statement += "'" + startAddress + "', "
-	statement += "'" + endAddress + "', "
-	statement += fmt.Sprintf("%f, ", distance)
-	statement += fmt.Sprintf("%d, ", duration)
-	if classification.Valid {
-		statement += fmt.Sprintf("%d", classification.Int32)
-	} else {
-		statement += "null"
+
+	statement := `
+    DELETE FROM tj_grouped_drives
+    WHERE id=ANY($1);`
+
+	_, err := s.conn().Exec(statement, pq.Array(groupedDrives))
+
+	return err
+}
+
+func (s postgresStore) ChangeClassification(classification int, drives []int64, groupedDrives []int64) error {
+	groupedDriveIds, err := s.GetDriveIdsForGroups(groupedDrives)
+	if err != nil {
+		return err
 	}
-	statement += ");"
 
-	_, err = db().Exec(statement)
+	ids := append(append([]int64{}, drives...), groupedDriveIds...)
+
+	statement := `
+    INSERT INTO public.tj_classifications (drive_id, classification)
+    SELECT unnest($1::integer[]), $2
+    ON CONFLICT(drive_id) DO UPDATE SET classification = excluded.classification, rule_id = NULL, suggested = false;`
+
+	_, err = s.conn().Exec(statement, pq.Array(ids), classification)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
-	return getAffectedDates(drives, []string{})
+	if len(groupedDrives) != 0 {
+		statement = `
+        UPDATE public.tj_grouped_drives
+        SET classification=$1
+        WHERE id=ANY($2);`
+
+		_, err = s.conn().Exec(statement, classification, pq.Array(groupedDrives))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func getFirstAndLastYears() (int, int, error) {
+func (s postgresStore) ChangeComment(comment string, drives []int64, groupedDrives []int64) error {
+	if len(drives) != 0 {
+		// an empty comment removes any existing comment from the drives:
+		if comment == "" {
+			_, err := s.conn().Exec("DELETE FROM public.tj_comments WHERE drive_id = ANY($1);", pq.Array(drives))
+			if err != nil {
+				return err
+			}
+		} else {
+			statement := `
+        INSERT INTO public.tj_comments (drive_id, comment)
+        SELECT unnest($1::integer[]), $2
+        ON CONFLICT(drive_id) DO UPDATE SET comment = excluded.comment;`
+
+			_, err := s.conn().Exec(statement, pq.Array(drives), comment)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(groupedDrives) != 0 {
+		statement := `
+        UPDATE public.tj_grouped_drives
+        SET comment=NULLIF($1, '')
+        WHERE id=ANY($2);`
+
+		_, err := s.conn().Exec(statement, comment, pq.Array(groupedDrives))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s postgresStore) ClassifyByRule(driveId int, rule Rule) error {
+	statement := `
+    INSERT INTO public.tj_classifications (drive_id, classification, rule_id)
+    VALUES ($1, $2, $3)
+    ON CONFLICT(drive_id) DO UPDATE SET classification = excluded.classification, rule_id = excluded.rule_id, suggested = false;`
+
+	_, err := s.conn().Exec(statement, driveId, rule.Classification, rule.Id)
+	if err != nil {
+		return err
+	}
+
+	// never overwrite a comment written by hand:
+	if rule.Comment != "" {
+		statement = `
+        INSERT INTO public.tj_comments (drive_id, comment)
+        VALUES ($1, $2)
+        ON CONFLICT(drive_id) DO NOTHING;`
+
+		_, err = s.conn().Exec(statement, driveId, rule.Comment)
+	}
+
+	return err
+}
+
+func (s postgresStore) AcceptSuggestion(classification int, driveIds []int64) error {
+	statement := `
+    INSERT INTO public.tj_classifications (drive_id, classification, suggested)
+    SELECT unnest($1::integer[]), $2, true
+    ON CONFLICT(drive_id) DO UPDATE SET classification = excluded.classification, rule_id = NULL, suggested = true;`
+
+	_, err := s.conn().Exec(statement, pq.Array(driveIds), classification)
+
+	return err
+}
+
+func (s postgresStore) GetClassifiedTrips() ([]ClassifiedTrip, error) {
 	statement := `
     SELECT
-        min(start_date) as min_date,
-        max(start_date) as max_date
-    FROM drives`
+        drives.start_geofence_id,
+        drives.end_geofence_id,
+        COALESCE(start_geofence.name, CONCAT_WS(', ', COALESCE(COALESCE(start_address.name, ''), nullif(CONCAT_WS(' ', start_address.road, start_address.house_number), '')), start_address.city)) AS start_address,
+        COALESCE(end_geofence.name, CONCAT_WS(', ', COALESCE(COALESCE(end_address.name, ''), nullif(CONCAT_WS(' ', end_address.road, end_address.house_number), '')), end_address.city)) AS end_address,
+        classification.classification,
+        count(*)
+    FROM drives
+    JOIN tj_classifications classification ON classification.drive_id = drives.id
+    LEFT JOIN addresses start_address ON start_address_id = start_address.id
+    LEFT JOIN addresses end_address ON end_address_id = end_address.id
+    LEFT JOIN geofences start_geofence ON start_geofence_id = start_geofence.id
+    LEFT JOIN geofences end_geofence ON end_geofence_id = end_geofence.id
+    WHERE classification.classification IS NOT NULL AND classification.rule_id IS NULL AND NOT classification.suggested
+    GROUP BY 1, 2, 3, 4, 5;`
+
+	rows, err := s.conn().Query(statement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	var minDate, maxDate time.Time
+	var trips []ClassifiedTrip
+	for rows.Next() {
+		var t ClassifiedTrip
 
-	row := db().QueryRow(statement)
-	err := row.Scan(&minDate, &maxDate)
+		err := rows.Scan(&t.StartGeofenceId, &t.EndGeofenceId, &t.StartAddress, &t.EndAddress, &t.Classification, &t.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		trips = append(trips, t)
+	}
+
+	return trips, rows.Err()
+}
+
+func (s postgresStore) GetCategories() ([]Category, error) {
+	var categories []Category
+
+	statement := "SELECT id, label, css_class, deductible, sort_order FROM public.tj_categories ORDER BY sort_order ASC, id ASC;"
+
+	rows, err := s.conn().Query(statement)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Category
+
+		err := rows.Scan(&c.Id, &c.Label, &c.CssClass, &c.Deductible, &c.SortOrder)
+		if err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, c)
 	}
 
-	return minDate.Year(), maxDate.Year(), nil
+	return categories, rows.Err()
 }
 
-func generateMain(year, month, carId int) MainData {
-	var data MainData
+func (s postgresStore) CreateCategory(c Category) (Category, error) {
+	statement := `
+    INSERT INTO public.tj_categories (label, css_class, deductible, sort_order)
+    VALUES ($1, $2, $3, $4)
+    RETURNING id;`
 
-	data.Year = year
-	data.Month = month
-	data.CarId = carId
+	err := s.conn().QueryRow(statement, c.Label, c.CssClass, c.Deductible, c.SortOrder).Scan(&c.Id)
 
-	cars, err := getCars()
+	return c, err
+}
+
+func (s postgresStore) UpdateCategory(c Category) error {
+	statement := `
+    UPDATE public.tj_categories
+    SET label=$2, css_class=$3, deductible=$4, sort_order=$5
+    WHERE id=$1;`
+
+	res, err := s.conn().Exec(statement, c.Id, c.Label, c.CssClass, c.Deductible, c.SortOrder)
 	if err != nil {
-		log.Println("Error retrieving cars from database: " + err.Error())
+		return err
 	}
-	data.DropdownCars = cars
 
-	from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	to := from.AddDate(0, 1, 0)
+	return expectRowsAffected(res)
+}
 
-	drives, err := getDrives(carId, from, to)
+func (s postgresStore) DeleteCategory(id int) error {
+	statement := `
+    SELECT
+        (SELECT count(*) FROM public.tj_classifications WHERE classification=$1) +
+        (SELECT count(*) FROM public.tj_grouped_drives WHERE classification=$1)`
+
+	var uses int
+	err := s.conn().QueryRow(statement, id).Scan(&uses)
 	if err != nil {
-		log.Println("Error retrieving drives from database: " + err.Error())
+		return err
+	}
+
+	if uses > 0 {
+		return errCategoryInUse
 	}
 
-	groupedDrives, err := getGroupedDrives(carId, from, to)
+	res, err := s.conn().Exec("DELETE FROM public.tj_categories WHERE id=$1;", id)
 	if err != nil {
-		log.Println("Error retrieving grouped drives from database: " + err.Error())
+		return err
 	}
 
-	var days []Day
-	var day *Day = nil
-	current := -1
-	for _, drive := range drives {
-		d := drive.StartDate.Day()
-		if d != current {
-			if day != nil {
-				days = append(days, *day)
-			}
+	return expectRowsAffected(res)
+}
 
-			day = new(Day)
-			day.Date = stripTime(drive.StartDate)
+// expectRowsAffected turns an update or delete that didn't find its row into sql.ErrNoRows.
+func expectRowsAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
 
-			if gd, exists := groupedDrives[day.Date]; exists {
-				day.GroupedDrives = gd
-			}
+	return err
+}
 
-			t := convertTime(day.Date)
-			day.DateString = strings.ToUpper(monday.Format(t, "Monday 2 January", monday.LocaleSvSE))
-			day.DateAsTs = day.Date.Unix()
+func scanRules(rows *sql.Rows) ([]Rule, error) {
+	var rules []Rule
 
-			current = d
+	for rows.Next() {
+		var rule Rule
+
+		err := rows.Scan(&rule.Id, &rule.CarId, &rule.Priority, &rule.StartGeofenceId, &rule.EndGeofenceId, &rule.StartAddress, &rule.EndAddress,
+			&rule.Weekdays, &rule.TimeFrom, &rule.TimeTo, &rule.MinDistance, &rule.MaxDistance, &rule.Classification, &rule.Comment)
+		if err != nil {
+			return nil, err
 		}
 
-		day.Drives = append(day.Drives, drive)
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+const ruleColumns = `id, car_id, priority, start_geofence_id, end_geofence_id, start_address, end_address,
+    weekdays, time_from, time_to, min_distance, max_distance, classification, comment`
+
+func (s postgresStore) GetRules(carId int) ([]Rule, error) {
+	statement := "SELECT " + ruleColumns + `
+    FROM public.tj_rules
+    WHERE car_id IS NULL OR car_id=$1
+    ORDER BY priority DESC, id ASC;`
+
+	rows, err := s.conn().Query(statement, carId)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
+
+	return scanRules(rows)
+}
 
-	if day != nil {
-		days = append(days, *day)
+func (s postgresStore) GetAllRules() ([]Rule, error) {
+	statement := "SELECT " + ruleColumns + `
+    FROM public.tj_rules
+    ORDER BY priority DESC, id ASC;`
+
+	rows, err := s.conn().Query(statement)
+	if err != nil {
+		return nil, err
 	}
-	data.Days = days
+	defer rows.Close()
+
+	return scanRules(rows)
+}
+
+func (s postgresStore) CreateRule(rule Rule) (Rule, error) {
+	statement := `
+    INSERT INTO public.tj_rules
+    (car_id, priority, start_geofence_id, end_geofence_id, start_address, end_address,
+    weekdays, time_from, time_to, min_distance, max_distance, classification, comment)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+    RETURNING id;`
+
+	err := s.conn().QueryRow(statement, rule.CarId, rule.Priority, rule.StartGeofenceId, rule.EndGeofenceId, rule.StartAddress, rule.EndAddress,
+		rule.Weekdays, rule.TimeFrom, rule.TimeTo, rule.MinDistance, rule.MaxDistance, rule.Classification, rule.Comment).Scan(&rule.Id)
+
+	return rule, err
+}
 
-	data.DropdownYears = make([]int, 0)
-	firstYear, lastYear, err := getFirstAndLastYears()
+func (s postgresStore) UpdateRule(rule Rule) error {
+	statement := `
+    UPDATE public.tj_rules
+    SET car_id=$2, priority=$3, start_geofence_id=$4, end_geofence_id=$5, start_address=$6, end_address=$7,
+    weekdays=$8, time_from=$9, time_to=$10, min_distance=$11, max_distance=$12, classification=$13, comment=$14
+    WHERE id=$1;`
+
+	res, err := s.conn().Exec(statement, rule.Id, rule.CarId, rule.Priority, rule.StartGeofenceId, rule.EndGeofenceId, rule.StartAddress, rule.EndAddress,
+		rule.Weekdays, rule.TimeFrom, rule.TimeTo, rule.MinDistance, rule.MaxDistance, rule.Classification, rule.Comment)
 	if err != nil {
-		log.Println("Error retrieving year span")
-		firstYear = 2020
-		lastYear = 2020
+		return err
 	}
 
-	for y := firstYear; y <= lastYear; y++ {
-		data.DropdownYears = append(data.DropdownYears, y)
+	return expectRowsAffected(res)
+}
+
+func (s postgresStore) DeleteRule(id int) error {
+	res, err := s.conn().Exec("DELETE FROM public.tj_rules WHERE id=$1;", id)
+	if err != nil {
+		return err
 	}
 
-	data.DropdownMonths = make([]Month, 0)
-	data.DropdownMonths = append(data.DropdownMonths, Month{1, "Januari"})
-	data.DropdownMonths = append(data.DropdownMonths, Month{2, "Februari"})
-	data.DropdownMonths = append(data.DropdownMonths, Month{3, "Mars"})
-	data.DropdownMonths = append(data.DropdownMonths, Month{4, "April"})
-	data.DropdownMonths = append(data.DropdownMonths, Month{5, "Maj"})
-	data.DropdownMonths = append(data.DropdownMonths, Month{6, "Juni"})
-	data.DropdownMonths = append(data.DropdownMonths, Month{7, "Juli"})
-	data.DropdownMonths = append(data.DropdownMonths, Month{8, "Augusti"})
-	data.DropdownMonths = append(data.DropdownMonths, Month{9, "September"})
-	data.DropdownMonths = append(data.DropdownMonths, Month{10, "Oktober"})
-	data.DropdownMonths = append(data.DropdownMonths, Month{11, "November"})
-	data.DropdownMonths = append(data.DropdownMonths, Month{12, "December"})
+	return expectRowsAffected(res)
+}
 
-	var totalDuration, totalBusinessDuration, totalPrivateDuration, unclassifiedDuration int
-	var totalDistance, totalBusinessDistance, totalPrivateDistance, unclassifiedDistance float32
+func (s postgresStore) GetRates() ([]Rate, error) {
+	var rates []Rate
 
-	for _, day := range data.Days {
-		for _, drive := range day.Drives {
-			totalDuration += drive.Duration
-			totalDistance += drive.Distance
+	statement := "SELECT id, category_id, rate_per_km, valid_from, valid_to FROM public.tj_rates ORDER BY category_id ASC, valid_from ASC, id ASC;"
 
-			if drive.Classification.Valid {
-				switch drive.Classification.Int32 {
-				case business:
-					totalBusinessDuration += drive.Duration
-					totalBusinessDistance += drive.Distance
+	rows, err := s.conn().Query(statement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-				case private:
-					totalPrivateDuration += drive.Duration
-					totalPrivateDistance += drive.Distance
-				}
-			} else {
-				unclassifiedDuration += drive.Duration
-				unclassifiedDistance += drive.Distance
-			}
+	for rows.Next() {
+		var rate Rate
+
+		err := rows.Scan(&rate.Id, &rate.Category, &rate.RatePerKm, &rate.ValidFrom, &rate.ValidTo)
+		if err != nil {
+			return nil, err
 		}
+
+		rates = append(rates, rate)
 	}
 
-	h, m := minutesToHoursAndMinutes(totalDuration)
-	data.TotalDurationString = fmt.Sprintf("%d:%02d", h, m)
-	data.TotalDistanceString = fmt.Sprintf("%.1f", totalDistance)
+	return rates, rows.Err()
+}
+
+func (s postgresStore) CreateRate(rate Rate) (Rate, error) {
+	statement := `
+    INSERT INTO public.tj_rates (category_id, rate_per_km, valid_from, valid_to)
+    VALUES ($1, $2, $3, $4)
+    RETURNING id;`
 
-	h, m = minutesToHoursAndMinutes(totalBusinessDuration)
-	data.TotalBusinessDurationString = fmt.Sprintf("%d:%02d", h, m)
-	data.TotalBusinessDistanceString = fmt.Sprintf("%.1f", totalBusinessDistance)
+	err := s.conn().QueryRow(statement, rate.Category, rate.RatePerKm, rate.ValidFrom, rate.ValidTo).Scan(&rate.Id)
 
-	h, m = minutesToHoursAndMinutes(totalPrivateDuration)
-	data.TotalPrivateDurationString = fmt.Sprintf("%d:%02d", h, m)
-	data.TotalPrivateDistanceString = fmt.Sprintf("%.1f", totalPrivateDistance)
+	return rate, err
+}
 
-	if unclassifiedDuration > 0 || unclassifiedDistance > 0 {
-		data.UnclassifiedDrivesRemaining = true
-		h, m = minutesToHoursAndMinutes(unclassifiedDuration)
-		data.UnclassifiedDurationString = fmt.Sprintf("%d:%02d", h, m)
-		data.UnclassifiedDistanceString = fmt.Sprintf("%.1f", unclassifiedDistance)
+func (s postgresStore) UpdateRate(rate Rate) error {
+	statement := `
+    UPDATE public.tj_rates
+    SET category_id=$2, rate_per_km=$3, valid_from=$4, valid_to=$5
+    WHERE id=$1;`
+
+	res, err := s.conn().Exec(statement, rate.Id, rate.Category, rate.RatePerKm, rate.ValidFrom, rate.ValidTo)
+	if err != nil {
+		return err
 	}
 
-	return data
+	return expectRowsAffected(res)
 }
 
-func getDay(year, month, day, carId int) (Day, error) {
-	var d Day
+func (s postgresStore) DeleteRate(id int) error {
+	res, err := s.conn().Exec("DELETE FROM public.tj_rates WHERE id=$1;", id)
+	if err != nil {
+		return err
+	}
 
-	from := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
-	to := from.AddDate(0, 0, 1)
+	return expectRowsAffected(res)
+}
+
+func scanMonths(rows *sql.Rows) ([]time.Time, error) {
+	var months []time.Time
+
+	for rows.Next() {
+		var month time.Time
+
+		err := rows.Scan(&month)
+		if err != nil {
+			return nil, err
+		}
+
+		months = append(months, month)
+	}
 
-	drives, err := getDrives(carId, from, to)
+	return months, rows.Err()
+}
+
+func (s postgresStore) GetClosedMonths(carId int) ([]time.Time, error) {
+	rows, err := s.conn().Query("SELECT month FROM public.tj_closed_months WHERE car_id=$1 ORDER BY month ASC;", carId)
 	if err != nil {
-		log.Println("Error retrieving drives from database: " + err.Error())
+		return nil, err
 	}
-	d.Drives = drives
+	defer rows.Close()
 
-	groupedDrives, err := getGroupedDrives(carId, from, to)
+	return scanMonths(rows)
+}
+
+func (s postgresStore) GetClosedMonthsOf(driveIds []int64, groupIds []int64) ([]time.Time, error) {
+	statement := `
+    SELECT DISTINCT closed.month
+    FROM public.tj_closed_months closed
+    JOIN
+    (
+        SELECT car_id, start_date FROM drives WHERE id = ANY($1)
+        UNION ALL
+        SELECT car_id, start_date FROM tj_grouped_drives WHERE id = ANY($2)
+    ) d ON d.car_id = closed.car_id AND date_trunc('month', d.start_date AT TIME ZONE 'UTC' AT TIME ZONE $3)::date = closed.month
+    ORDER BY closed.month ASC;`
+
+	rows, err := s.conn().Query(statement, pq.Array(driveIds), pq.Array(groupIds), location.String())
 	if err != nil {
-		log.Println("Error retrieving grouped drives from database: " + err.Error())
+		return nil, err
 	}
+	defer rows.Close()
 
-	d.Date = stripTime(from)
+	return scanMonths(rows)
+}
+
+func (s postgresStore) logClosedMonth(carId int, month time.Time, action, user, reason string) error {
+	statement := `
+    INSERT INTO public.tj_closed_month_log (car_id, month, action, username, reason)
+    VALUES ($1, $2, $3, $4, $5);`
+
+	_, err := s.conn().Exec(statement, carId, month.Format("2006-01-02"), action, user, reason)
+
+	return err
+}
+
+func (s postgresStore) CloseMonth(carId int, month time.Time, user string) error {
+	statement := `
+    INSERT INTO public.tj_closed_months (car_id, month)
+    VALUES ($1, $2)
+    ON CONFLICT(car_id, month) DO NOTHING;`
 
-	if gd, exists := groupedDrives[d.Date]; exists {
-		d.GroupedDrives = gd
+	res, err := s.conn().Exec(statement, carId, month.Format("2006-01-02"))
+	if err != nil {
+		return err
 	}
 
-	t := convertTime(d.Date)
-	d.DateString = strings.ToUpper(monday.Format(t, "Monday 2 January", monday.LocaleSvSE))
-	d.DateAsTs = d.Date.Unix()
+	// nothing is logged if the month was closed already:
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
 
-	return d, nil
+	return s.logClosedMonth(carId, month, "close", user, "")
 }
 
-func getDays(from, to time.Time, carId int) ([]Day, error) {
-	drives, err := getDrives(carId, from, to)
+func (s postgresStore) ReopenMonth(carId int, month time.Time, user, reason string) error {
+	res, err := s.conn().Exec("DELETE FROM public.tj_closed_months WHERE car_id=$1 AND month=$2;", carId, month.Format("2006-01-02"))
 	if err != nil {
-		log.Println("Error retrieving drives from database: " + err.Error())
+		return err
 	}
 
-	groupedDrives, err := getGroupedDrives(carId, from, to)
+	err = expectRowsAffected(res)
 	if err != nil {
-		log.Println("Error retrieving grouped drives from database: " + err.Error())
+		return err
 	}
 
-	var days []Day
-	var day *Day = nil
-	current := -1
-	for _, drive := range drives {
-		d := drive.StartDate.Day()
-		if d != current {
-			if day != nil {
-				days = append(days, *day)
-			}
+	return s.logClosedMonth(carId, month, "reopen", user, reason)
+}
 
-			day = new(Day)
-			day.Date = stripTime(drive.StartDate)
+func (s postgresStore) GetClosedMonthLog(carId int) ([]ClosedMonthEvent, error) {
+	var events []ClosedMonthEvent
 
-			if gd, exists := groupedDrives[day.Date]; exists {
-				day.GroupedDrives = gd
-			}
+	statement := `
+    SELECT car_id, month, action, username, reason, logged_at
+    FROM public.tj_closed_month_log
+    WHERE car_id=$1
+    ORDER BY logged_at ASC, id ASC;`
+
+	rows, err := s.conn().Query(statement, carId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-			t := convertTime(day.Date)
-			day.DateString = strings.ToUpper(monday.Format(t, "Monday 2 January", monday.LocaleSvSE))
-			day.DateAsTs = day.Date.Unix()
+	for rows.Next() {
+		var e ClosedMonthEvent
 
-			current = d
+		err := rows.Scan(&e.CarId, &e.Month, &e.Action, &e.User, &e.Reason, &e.Time)
+		if err != nil {
+			return nil, err
 		}
 
-		day.Drives = append(day.Drives, drive)
+		events = append(events, e)
 	}
 
-	if day != nil {
-		days = append(days, *day)
-	}
-
-	return days, nil
+	return events, rows.Err()
 }
 
-func getAffectedDates(driveIds []string, groupedDriveIds []string) (*time.Time, *time.Time, error) {
-	var dates []time.Time
+func (s postgresStore) AppendAudit(entries []AuditEntry) error {
+	return s.Transaction(func(js JournalStore) error {
+		conn := js.(postgresStore).conn()
 
-	if len(driveIds) > 0 {
-		statement := `
-        SELECT
-            min(start_date) as min_date,
-            max(end_date) as max_date
-        FROM drives
-        WHERE id = ANY('{`
-		for _, id := range driveIds {
-			statement += id + ","
+		// appends are serialized so that each entry is chained to the one written before it:
+		_, err := conn.Exec("LOCK TABLE public.tj_audit_log IN EXCLUSIVE MODE;")
+		if err != nil {
+			return err
 		}
-		statement = strings.TrimRight(statement, ",")
-		statement += `}')`
 
-		var minD, maxD time.Time
-
-		row := db().QueryRow(statement)
-		err := row.Scan(&minD, &maxD)
-		if err == nil {
-			dates = append(dates, minD)
-			dates = append(dates, maxD)
+		var last string
+		err = conn.QueryRow("SELECT hash FROM public.tj_audit_log ORDER BY id DESC LIMIT 1;").Scan(&last)
+		if err != nil && err != sql.ErrNoRows {
+			return err
 		}
-	}
 
-	if len(groupedDriveIds) > 0 {
+		chainAuditEntries(last, entries)
+
 		statement := `
-        SELECT
-            min(start_date) as min_date,
-            max(end_date) as max_date
-        FROM tj_grouped_drives
-        WHERE id = ANY('{`
-		for _, id := range groupedDriveIds {
-			statement += id + ","
+        INSERT INTO public.tj_audit_log (logged_at, username, action, entity, entity_id, old_value, new_value, prev_hash, hash)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`
+
+		for _, e := range entries {
+			_, err = conn.Exec(statement, e.Time, e.User, e.Action, e.Entity, e.EntityId, e.OldValue, e.NewValue, e.PrevHash, e.Hash)
+			if err != nil {
+				return err
+			}
 		}
-		statement = strings.TrimRight(statement, ",")
-		statement += `}')`
 
-		var minD, maxD time.Time
+		return nil
+	})
+}
+
+func (s postgresStore) queryAuditLog(condition string, args ...interface{}) ([]AuditEntry, error) {
+	var entries []AuditEntry
 
-		row := db().QueryRow(statement)
-		err := row.Scan(&minD, &maxD)
-		if err == nil {
-			dates = append(dates, minD)
-			dates = append(dates, maxD)
-		}
+	statement := `
+    SELECT id, logged_at, username, action, entity, entity_id, old_value, new_value, prev_hash, hash
+    FROM public.tj_audit_log
+    ` + condition + `
+    ORDER BY id ASC;`
+
+	rows, err := s.conn().Query(statement, args...)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEntry
+
+		err := rows.Scan(&e.Id, &e.Time, &e.User, &e.Action, &e.Entity, &e.EntityId, &e.OldValue, &e.NewValue, &e.PrevHash, &e.Hash)
+		if err != nil {
+			return nil, err
+		}
 
-	if len(dates) < 2 {
-		return nil, nil, errors.New("Error retrieving first/last dates of range of drives")
+		entries = append(entries, e)
 	}
 
-	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
-	minDate := stripTime(dates[0])
-	maxDate := stripTime(dates[len(dates)-1]).AddDate(0, 0, 1)
+	return entries, rows.Err()
+}
 
-	return &minDate, &maxDate, nil
+func (s postgresStore) GetAuditLog() ([]AuditEntry, error) {
+	return s.queryAuditLog("")
 }
 
-func getDrives(carId int, from, to time.Time) ([]Drive, error) {
-	statement := fmt.Sprintf(`WITH data AS (
-        SELECT
-        round(extract(epoch FROM drives.start_date)) * 1000 AS start_date_ts,
-        round(extract(epoch FROM drives.end_date)) * 1000 AS end_date_ts,
-        car.id as car_id,
-        start_km,
-        end_km,
-        CASE WHEN start_geofence.id IS NULL THEN CONCAT('new?lat=', start_position.latitude, '&lng=', start_position.longitude)
-        WHEN start_geofence.id IS NOT NULL THEN CONCAT(start_geofence.id, '/edit')
-        END as start_path,
-        CASE WHEN end_geofence.id IS NULL THEN CONCAT('new?lat=', end_position.latitude, '&lng=', end_position.longitude)
-        WHEN end_geofence.id IS NOT NULL THEN CONCAT(end_geofence.id, '/edit')
-        END as end_path,
-        drives.id as drive_id,
-        classification.classification,
-        grouped_drive.id AS grouped_drive_id,
-        drives.start_date,
-        drives.end_date,
-        drives.duration_min,
-        COALESCE(start_geofence.name, CONCAT_WS(', ', COALESCE(COALESCE(start_address.name, ''), nullif(CONCAT_WS(' ', start_address.road, start_address.house_number), '')), start_address.city)) AS start_address,
-        COALESCE(end_geofence.name, CONCAT_WS(', ', COALESCE(COALESCE(end_address.name, ''), nullif(CONCAT_WS(' ', end_address.road, end_address.house_number), '')), end_address.city)) AS end_address,
-        drives.distance
-        FROM drives
-        LEFT JOIN addresses start_address ON start_address_id = start_address.id
-        LEFT JOIN addresses end_address ON end_address_id = end_address.id
-        LEFT JOIN positions start_position ON start_position_id = start_position.id
-        LEFT JOIN positions end_position ON end_position_id = end_position.id
-        LEFT JOIN geofences start_geofence ON start_geofence_id = start_geofence.id
-        LEFT JOIN geofences end_geofence ON end_geofence_id = end_geofence.id
-        LEFT JOIN cars car ON car.id = drives.car_id
-        LEFT JOIN tj_classifications classification ON classification.drive_id = drives.id
-        LEFT JOIN tj_grouped_drives grouped_drive ON drives.car_id=grouped_drive.car_id AND drives.id = ANY(grouped_drive.drive_ids)
-        WHERE drives.car_id = %d AND drives.start_date >= '%s'::date AND drives.start_date < '%s'::date AND drives.start_date IS NOT NULL AND drives.end_date IS NOT NULL
-        ORDER BY drives.start_date DESC
-    )
-    SELECT
-    drive_id,
-    start_date,
-    end_date,
-    duration_min,
-    start_address,
-    end_address,
-    round(start_km::numeric) AS start_odo,
-    round(end_km::numeric) AS end_odo,
-    distance,
-    classification,
-    grouped_drive_id
-    FROM data;`, carId, from.Format("2006-01-02 15:04:05.000"), to.Format("2006-01-02 15:04:05.000"))
+func (s postgresStore) GetAuditHistory(entity string, entityId int) ([]AuditEntry, error) {
+	return s.queryAuditLog("WHERE entity=$1 AND entity_id=$2", entity, entityId)
+}
 
-	var drives []Drive
+func (s postgresStore) GetUsers() ([]User, error) {
+	var users []User
 
-	rows, _ := db().Query(statement)
+	rows, err := s.conn().Query("SELECT id, username, password_hash, role, COALESCE(locale, ''), created_at FROM public.tj_users ORDER BY username ASC;")
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var drive Drive
+		var u User
 
-		err := rows.Scan(&drive.Id, &drive.StartDate, &drive.EndDate, &drive.Duration, &drive.StartAddress, &drive.EndAddress, &drive.StartOdometer, &drive.EndOdometer, &drive.Distance, &drive.Classification, &drive.GroupId)
+		err := rows.Scan(&u.Id, &u.Username, &u.PasswordHash, &u.Role, &u.Locale, &u.Created)
 		if err != nil {
 			return nil, err
 		}
 
-		drive.ClassificationClass = "unknown"
-		drive.ClassificationString = ""
-		if drive.Classification.Valid {
-			switch drive.Classification.Int32 {
-			case business:
-				drive.ClassificationClass = "business"
-				drive.ClassificationString = "Tjänsteresa"
-
-			case private:
-				drive.ClassificationClass = "private"
-				drive.ClassificationString = "Privat resa"
-			}
-		}
+		users = append(users, u)
+	}
 
-		drive.StartTime = convertTime(drive.StartDate).Format("15:04")
-		drive.EndTime = convertTime(drive.EndDate).Format("15:04")
+	return users, rows.Err()
+}
 
-		h, m := minutesToHoursAndMinutes(drive.Duration)
-		drive.DurationString = fmt.Sprintf("%d:%02d", h, m)
-		drive.DistanceString = fmt.Sprintf("%.2f", drive.Distance)
+func (s postgresStore) GetUser(username string) (User, error) {
+	var u User
 
-		drives = append(drives, drive)
-	}
+	statement := "SELECT id, username, password_hash, role, COALESCE(locale, ''), created_at FROM public.tj_users WHERE username=$1;"
+	err := s.conn().QueryRow(statement, username).Scan(&u.Id, &u.Username, &u.PasswordHash, &u.Role, &u.Locale, &u.Created)
 
-	return drives, rows.Err()
+	return u, err
 }
 
-func getGroupedDrivesById(id string) (GroupedDrives, error) {
-	statement := fmt.Sprintf(`
-    SELECT gd.*, round(MIN(d.start_km)), round(MAX(d.end_km))
-	FROM tj_grouped_drives AS gd
-    LEFT JOIN drives d ON d.id = ANY(gd.drive_ids)
-	WHERE gd.id = %s
-	GROUP BY gd.id`, id)
+func (s postgresStore) CreateUser(user User) (User, error) {
+	statement := `
+    INSERT INTO public.tj_users (username, password_hash, role)
+    VALUES ($1, $2, $3)
+    RETURNING id, created_at;`
 
-	var gd GroupedDrives
+	err := s.conn().QueryRow(statement, user.Username, user.PasswordHash, user.Role).Scan(&user.Id, &user.Created)
 
-	row := db().QueryRow(statement)
-	var startAddress, endAddress sql.NullString
-	err := row.Scan(&gd.Id, &gd.CarId, &gd.DriveIds, &gd.StartDate, &gd.EndDate, &startAddress, &endAddress, &gd.Distance, &gd.Duration, &gd.Classification, &gd.Comment, &gd.StartOdometer, &gd.EndOdometer)
+	return user, err
+}
+
+func (s postgresStore) SetPassword(username, passwordHash string) error {
+	res, err := s.conn().Exec("UPDATE public.tj_users SET password_hash=$2 WHERE username=$1;", username, passwordHash)
 	if err != nil {
-		return gd, err
+		return err
 	}
 
-	if startAddress.Valid {
-		gd.StartAddress = startAddress.String
-	} else {
-		gd.StartAddress = ""
-	}
+	return expectRowsAffected(res)
+}
 
-	if endAddress.Valid {
-		gd.EndAddress = endAddress.String
-	} else {
-		gd.EndAddress = ""
+func (s postgresStore) SetRole(username, role string) error {
+	res, err := s.conn().Exec("UPDATE public.tj_users SET role=$2 WHERE username=$1;", username, role)
+	if err != nil {
+		return err
 	}
 
-	gd.ClassificationClass = "unknown"
-	gd.ClassificationString = ""
-	if gd.Classification.Valid {
-		switch gd.Classification.Int32 {
-		case business:
-			gd.ClassificationClass = "business"
-			gd.ClassificationString = "Tjänsteresa"
+	return expectRowsAffected(res)
+}
 
-		case private:
-			gd.ClassificationClass = "private"
-			gd.ClassificationString = "Privat resa"
-		}
+func (s postgresStore) SetLocale(username, locale string) error {
+	res, err := s.conn().Exec("UPDATE public.tj_users SET locale=NULLIF($2, '') WHERE username=$1;", username, locale)
+	if err != nil {
+		return err
 	}
 
-	gd.StartTime = convertTime(gd.StartDate).Format("15:04")
-	gd.EndTime = convertTime(gd.EndDate).Format("15:04")
-
-	h, m := minutesToHoursAndMinutes(gd.Duration)
-	gd.DurationString = fmt.Sprintf("%d:%02d", h, m)
-	gd.DistanceString = fmt.Sprintf("%.2f", gd.Distance)
-
-	return gd, nil
+	return expectRowsAffected(res)
 }
 
-func getGroupedDrives(carId int, from, to time.Time) (map[time.Time][]GroupedDrives, error) {
-	groupedDrives := make(map[time.Time][]GroupedDrives)
-
-	statement := fmt.Sprintf(`
-    SELECT gd.*, round(MIN(d.start_km)), round(MAX(d.end_km))
-	FROM tj_grouped_drives AS gd
-    LEFT JOIN drives d ON d.id = ANY(gd.drive_ids)
-    WHERE gd.car_id = %d AND gd.start_date >= '%s'::date AND gd.start_date < '%s'::date
-    GROUP BY gd.id`, carId, from.Format("2006-01-02 15:04:05.000"), to.Format("2006-01-02 15:04:05.000"))
-
-	rows, err := db().Query(statement)
+func (s postgresStore) DeleteUser(username string) error {
+	res, err := s.conn().Exec("DELETE FROM public.tj_users WHERE username=$1;", username)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var gd GroupedDrives
-		var startAddress, endAddress sql.NullString
 
-		err := rows.Scan(&gd.Id, &gd.CarId, &gd.DriveIds, &gd.StartDate, &gd.EndDate, &startAddress, &endAddress, &gd.Distance, &gd.Duration, &gd.Classification, &gd.Comment, &gd.StartOdometer, &gd.EndOdometer)
-		if err != nil {
-			return nil, err
-		}
+	return expectRowsAffected(res)
+}
 
-		if startAddress.Valid {
-			gd.StartAddress = startAddress.String
-		} else {
-			gd.StartAddress = ""
-		}
+func (s postgresStore) CreateSession(session Session) error {
+	_, err := s.conn().Exec("DELETE FROM public.tj_sessions WHERE expires_at < now();")
+	if err != nil {
+		return err
+	}
 
-		if endAddress.Valid {
-			gd.EndAddress = endAddress.String
-		} else {
-			gd.EndAddress = ""
-		}
+	statement := `
+    INSERT INTO public.tj_sessions (token_hash, user_id, csrf_token, expires_at)
+    VALUES ($1, $2, $3, $4);`
 
-		gd.ClassificationClass = "unknown"
-		gd.ClassificationString = ""
-		if gd.Classification.Valid {
-			switch gd.Classification.Int32 {
-			case business:
-				gd.ClassificationClass = "business"
-				gd.ClassificationString = "Tjänsteresa"
-
-			case private:
-				gd.ClassificationClass = "private"
-				gd.ClassificationString = "Privat resa"
-			}
-		}
+	_, err = s.conn().Exec(statement, session.TokenHash, session.UserId, session.CSRFToken, session.Expires)
 
-		gd.StartTime = convertTime(gd.StartDate).Format("15:04")
-		gd.EndTime = convertTime(gd.EndDate).Format("15:04")
+	return err
+}
 
-		h, m := minutesToHoursAndMinutes(gd.Duration)
-		gd.DurationString = fmt.Sprintf("%d:%02d", h, m)
-		gd.DistanceString = fmt.Sprintf("%.2f", gd.Distance)
+func (s postgresStore) GetSession(tokenHash string) (Session, error) {
+	var session Session
 
-		key := stripTime(gd.StartDate)
-		_, ok := groupedDrives[key]
-		if !ok {
-			groupedDrives[key] = make([]GroupedDrives, 0)
-		}
+	statement := `
+    SELECT s.token_hash, s.user_id, u.username, u.role, COALESCE(u.locale, ''), s.csrf_token, s.expires_at
+    FROM public.tj_sessions s
+    JOIN public.tj_users u ON u.id = s.user_id
+    WHERE s.token_hash=$1 AND s.expires_at > now();`
 
-		groupedDrives[key] = append(groupedDrives[key], gd)
-	}
+	err := s.conn().QueryRow(statement, tokenHash).Scan(&session.TokenHash, &session.UserId, &session.Username, &session.Role, &session.Locale, &session.CSRFToken, &session.Expires)
 
-	return groupedDrives, rows.Err()
+	return session, err
 }
 
-func getDriveById(driveId string) (Drive, string, error) {
-	statement := fmt.Sprintf(`WITH data AS (
-        SELECT
-        round(extract(epoch FROM drives.start_date)) * 1000 AS start_date_ts,
-        round(extract(epoch FROM drives.end_date)) * 1000 AS end_date_ts,
-        car.id as car_id,
-        start_km,
-        end_km,
-        CASE WHEN start_geofence.id IS NULL THEN CONCAT('new?lat=', start_position.latitude, '&lng=', start_position.longitude)
-        WHEN start_geofence.id IS NOT NULL THEN CONCAT(start_geofence.id, '/edit')
-        END as start_path,
-        CASE WHEN end_geofence.id IS NULL THEN CONCAT('new?lat=', end_position.latitude, '&lng=', end_position.longitude)
-        WHEN end_geofence.id IS NOT NULL THEN CONCAT(end_geofence.id, '/edit')
-        END as end_path,
-        drives.id as drive_id,
-        classification.classification,
-        grouped_drive.id AS grouped_drive_id,
-        drives.start_date,
-        drives.end_date,
-        drives.duration_min,
-        COALESCE(start_geofence.name, CONCAT_WS(', ', COALESCE(COALESCE(start_address.name, ''), nullif(CONCAT_WS(' ', start_address.road, start_address.house_number), '')), start_address.city)) AS start_address,
-        COALESCE(end_geofence.name, CONCAT_WS(', ', COALESCE(COALESCE(end_address.name, ''), nullif(CONCAT_WS(' ', end_address.road, end_address.house_number), '')), end_address.city)) AS end_address,
-        drives.distance
-        FROM drives
-        LEFT JOIN addresses start_address ON start_address_id = start_address.id
-        LEFT JOIN addresses end_address ON end_address_id = end_address.id
-        LEFT JOIN positions start_position ON start_position_id = start_position.id
-        LEFT JOIN positions end_position ON end_position_id = end_position.id
-        LEFT JOIN geofences start_geofence ON start_geofence_id = start_geofence.id
-        LEFT JOIN geofences end_geofence ON end_geofence_id = end_geofence.id
-        LEFT JOIN cars car ON car.id = drives.car_id
-        LEFT JOIN tj_classifications classification ON classification.drive_id = drives.id
-        LEFT JOIN tj_grouped_drives grouped_drive ON drives.car_id=grouped_drive.car_id AND drives.id = ANY(grouped_drive.drive_ids)
-        WHERE drives.id = %s
-        ORDER BY drives.start_date DESC
-    )
-    SELECT
-    drive_id,
-    start_date,
-    end_date,
-    duration_min,
-    start_address,
-    end_address,
-    round(start_km::numeric) AS start_odo,
-    round(end_km::numeric) AS end_odo,
-    distance,
-    classification,
-    grouped_drive_id
-    FROM data;`, driveId)
-
-	var drive Drive
-	var comment string
-	comment = "test comment"
-
-	row := db().QueryRow(statement)
-	err := row.Scan(&drive.Id, &drive.StartDate, &drive.EndDate, &drive.Duration, &drive.StartAddress, &drive.EndAddress, &drive.StartOdometer, &drive.EndOdometer, &drive.Distance, &drive.Classification, &drive.GroupId)
-	if err != nil {
-		return drive, comment, err
-	}
+func (s postgresStore) DeleteSession(tokenHash string) error {
+	_, err := s.conn().Exec("DELETE FROM public.tj_sessions WHERE token_hash=$1;", tokenHash)
 
-	drive.ClassificationClass = "unknown"
-	drive.ClassificationString = ""
-	if drive.Classification.Valid {
-		switch drive.Classification.Int32 {
-		case business:
-			drive.ClassificationClass = "business"
-			drive.ClassificationString = "Tjänsteresa"
+	return err
+}
 
-		case private:
-			drive.ClassificationClass = "private"
-			drive.ClassificationString = "Privat resa"
-		}
-	}
+const apiTokenColumns = "t.id, t.user_id, u.username, u.role, COALESCE(u.locale, ''), t.name, t.token_hash, t.scope, t.created_at, t.expires_at, t.revoked_at"
 
-	drive.StartTime = convertTime(drive.StartDate).Format("15:04")
-	drive.EndTime = convertTime(drive.EndDate).Format("15:04")
+func scanApiToken(row interface{ Scan(...interface{}) error }) (ApiToken, error) {
+	var t ApiToken
 
-	h, m := minutesToHoursAndMinutes(drive.Duration)
-	drive.DurationString = fmt.Sprintf("%d:%02d", h, m)
-	drive.DistanceString = fmt.Sprintf("%.2f", drive.Distance)
+	err := row.Scan(&t.Id, &t.UserId, &t.Username, &t.Role, &t.Locale, &t.Name, &t.TokenHash, &t.Scope, &t.Created, &t.Expires, &t.Revoked)
 
-	return drive, comment, nil
+	return t, err
 }
 
-func getPositions(driveIds []string) ([]Position, error) {
-	var positions []Position
+func (s postgresStore) GetApiTokens() ([]ApiToken, error) {
+	var tokens []ApiToken
 
 	statement := `
-	SELECT
-	    positions.longitude,
-        positions.latitude
-	FROM
-	    positions, drives
-	WHERE
-		drives.id = ANY('{`
+    SELECT ` + apiTokenColumns + `
+    FROM public.tj_api_tokens t
+    JOIN public.tj_users u ON u.id = t.user_id
+    ORDER BY t.id ASC;`
 
-	for _, id := range driveIds {
-		statement += id + ","
+	rows, err := s.conn().Query(statement)
+	if err != nil {
+		return nil, err
 	}
-	statement = strings.TrimRight(statement, ",")
-	statement += `}') AND 
-        positions.date BETWEEN drives.start_date AND drives.end_date
-	ORDER BY
-	    positions.date ASC;
-	`
-
-	rows, _ := db().Query(statement)
 	defer rows.Close()
 
 	for rows.Next() {
-		var pos Position
-
-		err := rows.Scan(&pos.Longitude, &pos.Latitude)
+		t, err := scanApiToken(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		positions = append(positions, pos)
+		tokens = append(tokens, t)
 	}
 
-	return positions, rows.Err()
+	return tokens, rows.Err()
 }
 
-func getCars() ([]Car, error) {
-	var cars []Car
+func (s postgresStore) GetApiToken(tokenHash string) (ApiToken, error) {
+	statement := `
+    SELECT ` + apiTokenColumns + `
+    FROM public.tj_api_tokens t
+    JOIN public.tj_users u ON u.id = t.user_id
+    WHERE t.token_hash=$1 AND t.revoked_at IS NULL AND (t.expires_at IS NULL OR t.expires_at > now());`
 
-	statement := "SELECT id, model, name FROM cars ORDER BY id ASC;"
+	return scanApiToken(s.conn().QueryRow(statement, tokenHash))
+}
+
+func (s postgresStore) CreateApiToken(token ApiToken) (ApiToken, error) {
+	statement := `
+    INSERT INTO public.tj_api_tokens (user_id, name, token_hash, scope, expires_at)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING id, created_at;`
+
+	err := s.conn().QueryRow(statement, token.UserId, token.Name, token.TokenHash, token.Scope, token.Expires).Scan(&token.Id, &token.Created)
+
+	return token, err
+}
+
+func (s postgresStore) RevokeApiToken(id int) error {
+	res, err := s.conn().Exec("UPDATE public.tj_api_tokens SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL;", id)
+	if err != nil {
+		return err
+	}
+
+	return expectRowsAffected(res)
+}
 
-	rows, _ := db().Query(statement)
+func (s postgresStore) queryDrivers(condition string, args ...interface{}) ([]Driver, error) {
+	var drivers []Driver
+
+	rows, err := s.conn().Query("SELECT id, car_id, name, user_id FROM public.tj_drivers WHERE "+condition+" ORDER BY name ASC;", args...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var car Car
+		var d Driver
 
-		err := rows.Scan(&car.Id, &car.Model, &car.Name)
+		err := rows.Scan(&d.Id, &d.CarId, &d.Name, &d.UserId)
 		if err != nil {
 			return nil, err
 		}
 
-		cars = append(cars, car)
+		drivers = append(drivers, d)
 	}
 
-	return cars, rows.Err()
+	return drivers, rows.Err()
 }
 
-func getTotals(year, month, carId int) (Totals, error) {
-	from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	to := from.AddDate(0, 1, 0)
+func (s postgresStore) GetDrivers(carId int) ([]Driver, error) {
+	return s.queryDrivers("car_id=$1", carId)
+}
 
-	statement := fmt.Sprintf(`
-    SELECT
-        *,
-        duration_total - (duration_business + duration_private) as duration_unknown,
-        distance_total - (distance_business + distance_private) as distance_unknown
-    FROM
-        (SELECT
-            sum(case when c.classification=1 then drives.duration_min else 0 end) as duration_business,
-            sum(case when c.classification=1 then drives.distance else 0 end) as distance_business,
-            sum(case when c.classification=2 then drives.duration_min else 0 end) as duration_private,
-            sum(case when c.classification=2 then drives.distance else 0 end) as distance_private,
-            sum(drives.duration_min) as duration_total,
-            sum(drives.distance) as distance_total
-        FROM drives
-        LEFT JOIN tj_classifications c ON c.drive_id=drives.id
-    WHERE drives.car_id=%d AND drives.start_date >= '%s'::date AND drives.start_date < '%s'::date) a`, carId, from.Format("2006-01-02 15:04:05.000"), to.Format("2006-01-02 15:04:05.000"))
+func (s postgresStore) GetDriversOfUser(userId int) ([]Driver, error) {
+	return s.queryDrivers("user_id=$1", userId)
+}
 
-	var t Totals
+func (s postgresStore) CreateDriver(d Driver) (Driver, error) {
+	statement := `
+    INSERT INTO public.tj_drivers (car_id, name, user_id)
+    VALUES ($1, $2, $3)
+    RETURNING id;`
 
-	row := db().QueryRow(statement)
-	err := row.Scan(&t.TotalBusinessDuration, &t.TotalBusinessDistance, &t.TotalPrivateDuration, &t.TotalPrivateDistance, &t.TotalDuration, &t.TotalDistance, &t.UnclassifiedDuration, &t.UnclassifiedDistance)
+	err := s.conn().QueryRow(statement, d.CarId, d.Name, d.UserId).Scan(&d.Id)
+
+	return d, err
+}
+
+func (s postgresStore) UpdateDriver(d Driver) error {
+	res, err := s.conn().Exec("UPDATE public.tj_drivers SET name=$2, user_id=$3 WHERE id=$1;", d.Id, d.Name, d.UserId)
 	if err != nil {
-		return t, err
+		return err
 	}
 
-	return t, nil
+	return expectRowsAffected(res)
 }
 
-func createTables() error {
-	statement := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS public.tj_classifications
-    (
-        drive_id integer NOT NULL,
-        classification integer,
-        PRIMARY KEY (drive_id)
-    );
-    ALTER TABLE public.tj_classifications
-    OWNER to %s;`, config.Connection.User)
-
-	_, err := db().Exec(statement)
+func (s postgresStore) DeleteDriver(id int) error {
+	res, err := s.conn().Exec("DELETE FROM public.tj_drivers WHERE id=$1;", id)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Classifications table exists.")
+	return expectRowsAffected(res)
+}
 
-	statement = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS public.tj_grouped_drives
-    (
-        id SERIAL PRIMARY KEY,
-        car_id integer NOT NULL,
-        drive_ids integer[] NOT NULL,
-        start_date timestamp without time zone NOT NULL,
-        end_date timestamp without time zone NOT NULL,
-        start_address character varying NOT NULL,
-        end_address character varying NOT NULL,
-        distance double precision NOT NULL,
-        duration_min smallint NOT NULL,
-        classification integer,
-        comment text
-    );
-    ALTER TABLE public.tj_grouped_drives
-    OWNER to %s;`, config.Connection.User)
-
-	_, err = db().Exec(statement)
+func (s postgresStore) AssignDriver(driverId int, drives []int64, groupedDrives []int64) error {
+	groupedDriveIds, err := s.GetDriveIdsForGroups(groupedDrives)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Grouped drives table exists.")
-
-	statement = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS public.tj_comments
-    (
-        drive_id integer NOT NULL,
-		comment text,
-		PRIMARY KEY (drive_id)
-    );
-    ALTER TABLE public.tj_comments
-    OWNER to %s;`, config.Connection.User)
+	ids := append(append([]int64{}, drives...), groupedDriveIds...)
 
-	_, err = db().Exec(statement)
-	if err != nil {
+	if driverId == 0 {
+		_, err = s.conn().Exec("DELETE FROM public.tj_drive_drivers WHERE drive_id=ANY($1);", pq.Array(ids))
 		return err
 	}
 
-	fmt.Println("Grouped drives table exists.")
+	statement := `
+    INSERT INTO public.tj_drive_drivers (drive_id, driver_id)
+    SELECT unnest($1::integer[]), $2
+    ON CONFLICT(drive_id) DO UPDATE SET driver_id = excluded.driver_id;`
+
+	_, err = s.conn().Exec(statement, pq.Array(ids), driverId)
 
-	return nil
+	return err
 }
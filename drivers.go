@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// isDriverOf tells whether the driver is one of the drivers of the car.
+func isDriverOf(carId, driverId int) (bool, error) {
+	drivers, err := store.GetDrivers(carId)
+	if err != nil {
+		return false, err
+	}
+
+	for _, d := range drivers {
+		if d.Id == driverId {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+var errUnknownDriver = errors.New("Unknown driver")
+
+// getDriverParam returns the driver given by the driver parameter of a query, who must be one
+// of the drivers of the car. Without the parameter, the zero Driver is returned, meaning all drivers.
+// Fails with errUnknownDriver if the parameter isn't the id of such a driver.
+func getDriverParam(q url.Values, carId int) (Driver, error) {
+	s := q.Get("driver")
+	if s == "" || s == "0" {
+		return Driver{}, nil
+	}
+
+	id, err := parseId(s)
+	if err != nil {
+		return Driver{}, fmt.Errorf("%w: %s", errUnknownDriver, strconv.Quote(s))
+	}
+
+	drivers, err := store.GetDrivers(carId)
+	if err != nil {
+		return Driver{}, err
+	}
+
+	for _, d := range drivers {
+		if d.Id == int(id) {
+			return d, nil
+		}
+	}
+
+	return Driver{}, fmt.Errorf("%w: %s", errUnknownDriver, strconv.Quote(s))
+}
+
+func getCarVar(r *http.Request) (int, error) {
+	id, err := parseId(mux.Vars(r)["car"])
+	return int(id), err
+}
+
+// getDriverVars returns the car and the driver of the path, which must be one of the car's drivers.
+func getDriverVars(r *http.Request) (int, int, error) {
+	car, err := getCarVar(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	id, err := getIdVar(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	exists, err := isDriverOf(car, id)
+	if err == nil && !exists {
+		err = sql.ErrNoRows
+	}
+
+	return car, id, err
+}
+
+func parseDriverForm(r *http.Request) (Driver, error) {
+	var d Driver
+
+	err := r.ParseForm()
+	if err != nil {
+		return d, err
+	}
+
+	d.Name = strings.TrimSpace(r.Form.Get("name"))
+	if d.Name == "" {
+		return d, errors.New("A driver needs a name")
+	}
+
+	// the user logging in as the driver, if any:
+	if username := strings.TrimSpace(r.Form.Get("user")); username != "" {
+		user, err := store.GetUser(username)
+		if err == sql.ErrNoRows {
+			return d, errors.New("Unknown user: " + strconv.Quote(username))
+		} else if err != nil {
+			return d, err
+		}
+
+		d.UserId = sql.NullInt32{Int32: int32(user.Id), Valid: true}
+	}
+
+	return d, nil
+}
+
+func getDriversHandler(w http.ResponseWriter, r *http.Request) {
+	car, err := getCarVar(r)
+	if err != nil {
+		http.Error(w, "Invalid car id", http.StatusBadRequest)
+		return
+	}
+
+	drivers, err := store.GetDrivers(car)
+	if err != nil {
+		log.Println("Error retrieving drivers: " + err.Error())
+		http.Error(w, "Error retrieving drivers", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(drivers)
+}
+
+func postDriver(w http.ResponseWriter, r *http.Request) {
+	car, err := getCarVar(r)
+	if err != nil {
+		http.Error(w, "Invalid car id", http.StatusBadRequest)
+		return
+	}
+
+	d, err := parseDriverForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.CarId = car
+
+	d, err = store.CreateDriver(d)
+	if err != nil {
+		log.Println("Error creating driver: " + err.Error())
+		http.Error(w, "Error creating driver", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(d)
+}
+
+func putDriver(w http.ResponseWriter, r *http.Request) {
+	car, id, err := getDriverVars(r)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, "Invalid driver id", http.StatusBadRequest)
+		return
+	}
+
+	d, err := parseDriverForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.Id, d.CarId = id, car
+
+	err = store.UpdateDriver(d)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Println("Error updating driver: " + err.Error())
+		http.Error(w, "Error updating driver", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(d)
+}
+
+// removeDriver deletes a driver; their drives are left without a driver.
+func removeDriver(w http.ResponseWriter, r *http.Request) {
+	_, id, err := getDriverVars(r)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, "Invalid driver id", http.StatusBadRequest)
+		return
+	}
+
+	err = store.DeleteDriver(id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Printf("Error deleting driver %d: %s\n", id, err.Error())
+		http.Error(w, "Error deleting driver", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type DriversData struct {
+	Car     Car
+	Drivers []Driver
+	// the usernames of the users, by id:
+	Usernames map[int32]string
+}
+
+// serveDrivers serves the page for editing the drivers of a car; the page uses the driver endpoints.
+func serveDrivers(w http.ResponseWriter, r *http.Request) {
+	car, err := getCarVar(r)
+	if err != nil {
+		http.Error(w, "Invalid car id", http.StatusBadRequest)
+		return
+	}
+
+	var data DriversData
+
+	cars, err := getCars()
+	if err != nil {
+		log.Println("Error retrieving cars: " + err.Error())
+		http.Error(w, "Error retrieving cars", http.StatusInternalServerError)
+		return
+	}
+
+	found := false
+	for _, c := range cars {
+		if c.Id == car {
+			data.Car, found = c, true
+		}
+	}
+
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	data.Drivers, err = store.GetDrivers(car)
+	if err != nil {
+		log.Println("Error retrieving drivers: " + err.Error())
+		http.Error(w, "Error retrieving drivers", http.StatusInternalServerError)
+		return
+	}
+
+	users, err := store.GetUsers()
+	if err != nil {
+		log.Println("Error retrieving users: " + err.Error())
+		http.Error(w, "Error retrieving users", http.StatusInternalServerError)
+		return
+	}
+
+	data.Usernames = make(map[int32]string)
+	for _, u := range users {
+		data.Usernames[int32(u.Id)] = u.Username
+	}
+
+	err = driversTemplate[requestLocale(r)].Execute(w, data)
+	if err != nil {
+		log.Println("Error while executing template: " + err.Error())
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// useDrivers gives car 1 the drivers Anna and Bertil, and car 2 the driver Cecilia.
+func useDrivers(t *testing.T) (Driver, Driver, Driver) {
+	t.Helper()
+
+	var drivers []Driver
+	for _, d := range []Driver{{CarId: 1, Name: "Anna"}, {CarId: 1, Name: "Bertil"}, {CarId: 2, Name: "Cecilia"}} {
+		d, err := store.CreateDriver(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		drivers = append(drivers, d)
+	}
+
+	return drivers[0], drivers[1], drivers[2]
+}
+
+func TestDriverJournals(t *testing.T) {
+	useFixtures(t)
+	anna, bertil, _ := useDrivers(t)
+
+	_, _, err := assignDriver(store, anna.Id, []int64{2, 3}, []int64{}, "test")
+	if err == nil {
+		_, _, err = assignDriver(store, bertil.Id, []int64{4}, []int64{}, "test")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := generateMain(2021, 3, 1, anna.Id, defaultLocale)
+
+	var ids []int
+	for _, day := range data.Days {
+		ids = append(ids, driveIds(day.Drives)...)
+
+		for _, d := range day.Drives {
+			if d.DriverName != "Anna" {
+				t.Errorf("Expected drive %d to be driven by Anna, got %q", d.Id, d.DriverName)
+			}
+		}
+	}
+
+	if !equalIds(ids, []int{3, 2}) {
+		t.Errorf("Expected only the drives of Anna, got %v", ids)
+	}
+
+	if data.TotalDistanceString != "40.5" || data.UnassignedDistanceString != "" || len(data.Drivers) != 2 {
+		t.Errorf("Unexpected journal of Anna: %+v", data)
+	}
+
+	totals, err := getTotals(2021, 3, 1, bertil.Id)
+	if err != nil || totals.TotalDistance != 5 || totals.TotalPrivateDistance != 5 {
+		t.Errorf("Expected the totals of Bertil to be the private drive 4, got %+v (%v)", totals, err)
+	}
+
+	// all drives are shown together, with the distance still lacking a driver:
+	data = generateMain(2021, 3, 1, 0, defaultLocale)
+	if data.TotalDistanceString != "110.5" || data.UnassignedDistanceString != "65.0" {
+		t.Errorf("Expected 65 km without a driver, got %q of %q", data.UnassignedDistanceString, data.TotalDistanceString)
+	}
+
+	// unassigning is logged like the other changes:
+	_, _, err = assignDriver(store, 0, []int64{3}, []int64{}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, _ := store.GetAuditHistory(auditDrive, 3)
+	expected := "assign:>" + strconv.Itoa(anna.Id) + " assign:" + strconv.Itoa(anna.Id) + ">"
+	if strings.Join(auditSummary(history), " ") != expected {
+		t.Errorf("Expected the history %q, got %v", expected, auditSummary(history))
+	}
+}
+
+func TestGroupDriver(t *testing.T) {
+	s := useFixtures(t)
+	anna, bertil, _ := useDrivers(t)
+
+	groupDrives(store, 1, []int64{4, 5}, "test")
+	group := int64(s.groups[0].Id)
+
+	// assigning a group assigns its drives:
+	_, _, err := assignDriver(store, anna.Id, []int64{}, []int64{group}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gd, _ := getGroupedDrivesById(int(group), defaultLocale)
+	if !gd.DriverId.Valid || gd.DriverName != "Anna" {
+		t.Errorf("Expected the group to be driven by Anna, got %+v", gd)
+	}
+
+	days, _ := getDays(date("2021-03-02"), date("2021-03-03"), 1, anna.Id, defaultLocale)
+	if len(days) != 1 || len(days[0].GroupedDrives) != 1 || len(days[0].Drives) != 2 {
+		t.Errorf("Expected the group in the journal of Anna, got %+v", days)
+	}
+
+	// a group of drives by several drivers has no driver, and belongs to no driver's journal:
+	assignDriver(store, bertil.Id, []int64{5}, []int64{}, "test")
+
+	gd, _ = getGroupedDrivesById(int(group), defaultLocale)
+	if gd.DriverId.Valid {
+		t.Errorf("Expected the group to have no driver, got %+v", gd)
+	}
+
+	days, _ = getDays(date("2021-03-02"), date("2021-03-03"), 1, anna.Id, defaultLocale)
+	if len(days) != 1 || len(days[0].GroupedDrives) != 0 {
+		t.Errorf("Expected no group in the journal of Anna, got %+v", days)
+	}
+}
+
+func TestPostActionAssign(t *testing.T) {
+	s := useFixtures(t)
+	anna, _, cecilia := useDrivers(t)
+
+	form := url.Values{"action": {"assign"}, "assignee": {strconv.Itoa(anna.Id)}, "drive": {"3"}, "year": {"2021"}, "month": {"3"}, "car": {"1"}, "driver": {strconv.Itoa(anna.Id)}}
+	w := postForm(t, "/action", form)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	if s.assignments[3] != anna.Id {
+		t.Errorf("Expected drive 3 to be assigned to Anna, got %v", s.assignments)
+	}
+
+	// the totals and affected days are those of the driver shown:
+	var response PostResponse
+	json.NewDecoder(w.Body).Decode(&response)
+	if response.Totals.TotalDistance != 20.5 || len(response.AffectedDays) != 1 || len(response.AffectedDays[0].Drives) != 1 {
+		t.Errorf("Expected only drive 3 in the response, got %+v", response)
+	}
+
+	// the driver of another car can't be assigned:
+	form.Set("assignee", strconv.Itoa(cecilia.Id))
+	form.Set("drive", "2")
+	w = postForm(t, "/action", form)
+	if w.Code != http.StatusBadRequest || s.assignments[2] != 0 {
+		t.Errorf("Expected an invalid driver to be refused, got %d", w.Code)
+	}
+
+	form.Set("assignee", "0")
+	form.Del("drive")
+	w = postForm(t, "/action", form)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected assigning nothing to be refused, got %d", w.Code)
+	}
+}
+
+func TestDriverHandlers(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+	_, _, cecilia := useDrivers(t)
+
+	w := postForm(t, "/drivers/1", url.Values{"name": {" Doris "}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", w.Code)
+	}
+
+	var doris Driver
+	json.NewDecoder(w.Body).Decode(&doris)
+	if doris.Name != "Doris" || doris.CarId != 1 {
+		t.Errorf("Unexpected driver %+v", doris)
+	}
+
+	w = postForm(t, "/drivers/1", url.Values{"name": {" "}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a driver without a name to be refused, got %d", w.Code)
+	}
+
+	assignDriver(store, doris.Id, []int64{3}, []int64{}, "test")
+
+	path := "/drivers/1/" + strconv.Itoa(doris.Id)
+	r := httptest.NewRequest(http.MethodPut, path, strings.NewReader("name=Dora"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the driver to be renamed, got %d", w.Code)
+	}
+
+	// the drivers of a car can't be changed through another car:
+	r = httptest.NewRequest(http.MethodDelete, "/drivers/1/"+strconv.Itoa(cecilia.Id), nil)
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for the driver of another car, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodDelete, path, nil)
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+
+	if _, assigned := s.assignments[3]; assigned {
+		t.Error("Expected the drives of a deleted driver to be left without a driver")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/settings/drivers/2", nil)
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Cecilia") {
+		t.Errorf("Expected the drivers page of car 2, got %d", w.Code)
+	}
+}
+
+func TestExportOfDriver(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+	anna, _, cecilia := useDrivers(t)
+
+	assignDriver(store, anna.Id, []int64{2, 3}, []int64{}, "test")
+
+	r := httptest.NewRequest(http.MethodGet, "/export?car=1&year=2021&month=3&columns=date,distance,driver&driver="+strconv.Itoa(anna.Id), nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	records := readCSV(t, w.Body.String(), ';')
+	if len(records) != 1+2+4 || strings.Join(records[1], "|") != "2021-03-01|20,00|Anna" {
+		t.Errorf("Expected the two drives of Anna, got %v", records)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/export?car=1&year=2021&month=3&driver="+strconv.Itoa(cecilia.Id), nil)
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected the driver of another car to be refused, got %d", w.Code)
+	}
+}
+
+// failingDriversStore fails to retrieve drivers, as if the database were down.
+type failingDriversStore struct {
+	*memoryStore
+}
+
+func (failingDriversStore) GetDrivers(carId int) ([]Driver, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestGetDriverParamErrors(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+	useDrivers(t)
+
+	for _, param := range []string{"9", "x"} {
+		_, err := getDriverParam(url.Values{"driver": {param}}, 1)
+		if !errors.Is(err, errUnknownDriver) {
+			t.Errorf("Expected driver %s to be unknown, got %v", param, err)
+		}
+	}
+
+	// a failing database is no fault of the request:
+	store = failingDriversStore{s}
+
+	_, err := getDriverParam(url.Values{"driver": {"1"}}, 1)
+	if err == nil || errors.Is(err, errUnknownDriver) {
+		t.Errorf("Expected the error of the database, got %v", err)
+	}
+
+	for _, path := range []string{"/year/1/2021?driver=1", "/annual/1/2021?driver=1", "/export?car=1&year=2021&driver=1"} {
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status 500 from %s, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// the columns that can be exported, in their default order:
+var exportColumns = []string{"date", "starttime", "endtime", "startaddress", "endaddress", "startodometer", "endodometer", "distance", "duration", "classification", "comment", "driver", "reimbursement"}
+
+// the keys of the headers of the columns in the message catalogs:
+var exportHeaders = map[string]string{
+	"date":           "date",
+	"starttime":      "start",
+	"endtime":        "end",
+	"startaddress":   "from",
+	"endaddress":     "to",
+	"startodometer":  "odometer_start_full",
+	"endodometer":    "odometer_end_full",
+	"distance":       "distance_km",
+	"duration":       "duration",
+	"classification": "classification",
+	"comment":        "comment",
+	"driver":         "driver",
+	"reimbursement":  "reimbursement_kr",
+}
+
+type ExportOptions struct {
+	Delimiter        rune
+	DecimalSeparator string
+	Columns          []string
+	// Grouped exports a group of drives as one row instead of one row per drive:
+	Grouped bool
+	// DriverId limits the export to the drives of a driver, unless it's 0:
+	DriverId int
+	// Locale is the language of the headers and totals:
+	Locale string
+	// Unit is the unit of the distances and odometer readings, see units.go:
+	Unit string
+}
+
+// exportRow is a drive or a group of drives.
+type exportRow struct {
+	StartDate            time.Time
+	StartTime            string
+	EndTime              string
+	StartAddress         string
+	EndAddress           string
+	StartOdometer        int
+	EndOdometer          int
+	Distance             float32
+	Duration             int
+	Classification       int
+	ClassificationString string
+	Comment              string
+	Driver               string
+	Reimbursement        float64
+}
+
+func parseExportColumns(s string) ([]string, error) {
+	var columns []string
+
+	for _, c := range strings.Split(s, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+
+		if _, ok := exportHeaders[c]; !ok {
+			return nil, errors.New("Unknown column: " + c)
+		}
+
+		columns = append(columns, c)
+	}
+
+	if len(columns) == 0 {
+		return nil, errors.New("No columns to export")
+	}
+
+	return columns, nil
+}
+
+func parseDelimiter(s string) (rune, error) {
+	if s == "tab" || s == `\t` {
+		return '\t', nil
+	}
+
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || r == '"' || r == '\r' || r == '\n' {
+		return 0, errors.New("Invalid delimiter: " + strconv.Quote(s))
+	}
+
+	return r, nil
+}
+
+// getExportOptions returns the export options of the configuration, overridden by the given
+// values where they aren't empty.
+func getExportOptions(delimiter, decimalSeparator, columns, grouped string) (ExportOptions, error) {
+	options := ExportOptions{
+		DecimalSeparator: config.Export.DecimalSeparator,
+		Grouped:          config.Export.Grouped,
+		Locale:           configLocale(),
+		Unit:             unitKm,
+	}
+
+	if delimiter == "" {
+		delimiter = config.Export.Delimiter
+	}
+
+	if decimalSeparator != "" {
+		options.DecimalSeparator = decimalSeparator
+	}
+
+	if columns == "" {
+		columns = config.Export.Columns
+	}
+
+	if grouped != "" {
+		var err error
+		options.Grouped, err = strconv.ParseBool(grouped)
+		if err != nil {
+			return options, errors.New("Invalid value for grouped: " + grouped)
+		}
+	}
+
+	var err error
+	options.Delimiter, err = parseDelimiter(delimiter)
+	if err != nil {
+		return options, err
+	}
+
+	options.Columns, err = parseExportColumns(columns)
+	if err != nil {
+		return options, err
+	}
+
+	return options, nil
+}
+
+// exportPeriod returns the period to export: from and to (inclusive) if given, otherwise the
+// month, or the whole year if no month is given.
+func exportPeriod(year, month int, from, to string) (time.Time, time.Time, error) {
+	if from != "" || to != "" {
+		start, err := parseDate(from)
+		if err != nil {
+			return start, start, errors.New("Invalid from date: " + strconv.Quote(from))
+		}
+
+		end, err := parseDate(to)
+		if err != nil {
+			return start, end, errors.New("Invalid to date: " + strconv.Quote(to))
+		}
+
+		if end.Before(start) {
+			return start, end, errors.New("The period ends before it starts")
+		}
+
+		return start, end.AddDate(0, 0, 1), nil
+	}
+
+	if year < 1 {
+		return time.Time{}, time.Time{}, errors.New("Either a year or a period is needed")
+	}
+
+	if month == 0 {
+		start := localDate(year, 1, 1)
+		return start, start.AddDate(1, 0, 0), nil
+	}
+
+	if month < 1 || month > 12 {
+		return time.Time{}, time.Time{}, fmt.Errorf("Invalid month: %d", month)
+	}
+
+	start := localDate(year, month, 1)
+
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+func driveRow(d Drive) exportRow {
+	return exportRow{
+		StartDate:            d.StartDate,
+		StartTime:            d.StartTime,
+		EndTime:              d.EndTime,
+		StartAddress:         d.StartAddress,
+		EndAddress:           d.EndAddress,
+		StartOdometer:        d.StartOdometer,
+		EndOdometer:          d.EndOdometer,
+		Distance:             d.Distance,
+		Duration:             d.Duration,
+		Classification:       int(d.Classification.Int32),
+		ClassificationString: d.ClassificationString,
+		Comment:              d.Comment.String,
+		Driver:               d.DriverName,
+		Reimbursement:        d.Reimbursement,
+	}
+}
+
+func groupRow(gd GroupedDrives) exportRow {
+	return exportRow{
+		StartDate:            gd.StartDate,
+		StartTime:            gd.StartTime,
+		EndTime:              gd.EndTime,
+		StartAddress:         gd.StartAddress,
+		EndAddress:           gd.EndAddress,
+		StartOdometer:        gd.StartOdometer,
+		EndOdometer:          gd.EndOdometer,
+		Distance:             gd.Distance,
+		Duration:             gd.Duration,
+		Classification:       int(gd.Classification.Int32),
+		ClassificationString: gd.ClassificationString,
+		Comment:              gd.Comment.String,
+		Driver:               gd.DriverName,
+		Reimbursement:        gd.Reimbursement,
+	}
+}
+
+// journalRows returns the drives in chronological order. If grouped is set, the drives
+// of a group are replaced by one row for the group, like in the list of drives.
+func journalRows(drives []Drive, groupedDrives []GroupedDrives, grouped bool) []exportRow {
+	var rows []exportRow
+	for _, d := range drives {
+		if !grouped || !d.GroupId.Valid {
+			rows = append(rows, driveRow(d))
+		}
+	}
+
+	if grouped {
+		for _, gd := range groupedDrives {
+			rows = append(rows, groupRow(gd))
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].StartDate.Before(rows[j].StartDate) })
+
+	return rows
+}
+
+// getExportRows returns the rows of the period in the unit and the language, only those of
+// the driver unless driverId is 0.
+func getExportRows(carId int, from, to time.Time, grouped bool, driverId int, unit, lang string) ([]exportRow, error) {
+	drives, err := getDrives(carId, from, to, driverId, lang)
+	if err != nil {
+		return nil, err
+	}
+
+	groupedDrives, err := getGroupedDrives(carId, from, to, driverId, lang)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []GroupedDrives
+	for _, gd := range groupedDrives {
+		groups = append(groups, gd...)
+	}
+
+	rows := journalRows(drives, groups, grouped)
+	convertRows(rows, unit)
+
+	return rows, nil
+}
+
+func formatDecimal(f float32, decimalSeparator string) string {
+	return strings.Replace(fmt.Sprintf("%.2f", f), ".", decimalSeparator, 1)
+}
+
+func formatDuration(minutes int) string {
+	h, m := minutesToHoursAndMinutes(minutes)
+	return fmt.Sprintf("%d:%02d", h, m)
+}
+
+func (row exportRow) value(column string, options ExportOptions) string {
+	switch column {
+	case "date":
+		return convertTime(row.StartDate).Format("2006-01-02")
+	case "starttime":
+		return row.StartTime
+	case "endtime":
+		return row.EndTime
+	case "startaddress":
+		return row.StartAddress
+	case "endaddress":
+		return row.EndAddress
+	case "startodometer":
+		return strconv.Itoa(row.StartOdometer)
+	case "endodometer":
+		return strconv.Itoa(row.EndOdometer)
+	case "distance":
+		return formatDecimal(row.Distance, options.DecimalSeparator)
+	case "duration":
+		return formatDuration(row.Duration)
+	case "classification":
+		return row.ClassificationString
+	case "comment":
+		return row.Comment
+	case "driver":
+		return row.Driver
+	case "reimbursement":
+		return formatAmount(row.Reimbursement, options.DecimalSeparator)
+	}
+
+	return ""
+}
+
+// isSummedColumn tells whether the totals rows of the export have a sum in the column.
+func isSummedColumn(column string) bool {
+	return column == "distance" || column == "duration" || column == "reimbursement"
+}
+
+// writeCSV writes the rows followed by the totals of business, private and unclassified
+// drives. The totals rows have their sums in the distance, duration and reimbursement columns
+// and their label in the first other column; if there is none, every row starts with an
+// extra column for the labels.
+func writeCSV(out io.Writer, rows []exportRow, categories map[int]Category, options ExportOptions) error {
+	w := csv.NewWriter(out)
+	w.Comma = options.Delimiter
+	w.UseCRLF = true
+
+	var prefix []string
+	label := -1
+	for i, c := range options.Columns {
+		if !isSummedColumn(c) {
+			label = i
+			break
+		}
+	}
+
+	if label < 0 {
+		prefix = []string{""}
+		label = 0
+	}
+
+	header := append([]string{}, prefix...)
+	for _, c := range options.Columns {
+		key := exportHeaders[c]
+		if c == "distance" {
+			key = "distance_" + options.Unit
+		}
+
+		header = append(header, translate(options.Locale, key))
+	}
+
+	err := w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	totals := []struct {
+		label         string
+		distance      float32
+		duration      int
+		reimbursement float64
+	}{{label: "sum_business"}, {label: "sum_private"}, {label: "sum_unclassified"}, {label: "sum"}}
+
+	for _, row := range rows {
+		record := append([]string{}, prefix...)
+		for _, c := range options.Columns {
+			record = append(record, row.value(c, options))
+		}
+
+		err = w.Write(record)
+		if err != nil {
+			return err
+		}
+
+		t := 2
+		if category, known := categories[row.Classification]; known {
+			if category.Deductible {
+				t = 0
+			} else {
+				t = 1
+			}
+		}
+
+		totals[t].distance += row.Distance
+		totals[t].duration += row.Duration
+		totals[t].reimbursement += row.Reimbursement
+		totals[3].distance += row.Distance
+		totals[3].duration += row.Duration
+		totals[3].reimbursement += row.Reimbursement
+	}
+
+	for _, t := range totals {
+		record := make([]string, len(prefix)+len(options.Columns))
+		record[label] = translate(options.Locale, t.label)
+
+		for i, c := range options.Columns {
+			if c == "distance" {
+				record[len(prefix)+i] = formatDecimal(t.distance, options.DecimalSeparator)
+			} else if c == "duration" {
+				record[len(prefix)+i] = formatDuration(t.duration)
+			} else if c == "reimbursement" {
+				record[len(prefix)+i] = formatAmount(t.reimbursement, options.DecimalSeparator)
+			}
+		}
+
+		err = w.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
+
+// exportCSV writes the drives of a car in the period as CSV in the unit of the car, preceded
+// by a byte order mark so that Excel recognizes the file as UTF-8.
+func exportCSV(out io.Writer, carId int, from, to time.Time, options ExportOptions) error {
+	categories, err := getCategoryMap()
+	if err != nil {
+		return err
+	}
+
+	options.Unit = carUnit(carId)
+
+	rows, err := getExportRows(carId, from, to, options.Grouped, options.DriverId, options.Unit, options.Locale)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(out, "\ufeff")
+	if err != nil {
+		return err
+	}
+
+	return writeCSV(out, rows, categories, options)
+}
+
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	car, err := parseId(q.Get("car"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	year, _ := strconv.Atoi(q.Get("year"))
+	month, _ := strconv.Atoi(q.Get("month"))
+
+	from, to, err := exportPeriod(year, month, q.Get("from"), q.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	options, err := getExportOptions(q.Get("delimiter"), q.Get("decimal"), q.Get("columns"), q.Get("grouped"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	driver, err := getDriverParam(q, int(car))
+	if errors.Is(err, errUnknownDriver) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("Error retrieving drivers from database: " + err.Error())
+		http.Error(w, "Error retrieving drivers", http.StatusInternalServerError)
+		return
+	}
+	options.DriverId = driver.Id
+	options.Locale = requestLocale(r)
+
+	filename := fmt.Sprintf("korjournal-%d-%s-%s.csv", car, from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+
+	var buf bytes.Buffer
+	err = exportCSV(&buf, int(car), from, to, options)
+	if err != nil {
+		log.Println("Error exporting drives: " + err.Error())
+		http.Error(w, "Error exporting drives", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	w.Write(buf.Bytes())
+}
+
+// runExportCommand implements the export subcommand, writing CSV to standard output or a file.
+func runExportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	car := flags.Int("car", 1, "id of the car")
+	year := flags.Int("year", convertTime(time.Now()).Year(), "year to export")
+	month := flags.Int("month", 0, "month to export; the whole year if left out")
+	from := flags.String("from", "", "first date to export (YYYY-MM-DD), instead of year and month")
+	to := flags.String("to", "", "last date to export (YYYY-MM-DD)")
+	delimiter := flags.String("delimiter", "", "field delimiter, e.g. ; or tab")
+	decimal := flags.String("decimal", "", "decimal separator")
+	columns := flags.String("columns", "", "comma separated columns: "+strings.Join(exportColumns, ","))
+	grouped := flags.String("grouped", "", "export groups of drives as one row (true/false)")
+	driver := flags.Int("driver", 0, "id of the driver whose drives to export; all drives if left out")
+	lang := flags.String("lang", "", "language of the headers and totals: "+strings.Join(locales(), ", "))
+	output := flags.String("o", "", "file to write to instead of standard output")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	start, end, err := exportPeriod(*year, *month, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	options, err := getExportOptions(*delimiter, *decimal, *columns, *grouped)
+	if err != nil {
+		return err
+	}
+
+	if *lang != "" {
+		if !isLocale(*lang) {
+			return errors.New("Unknown language: " + *lang)
+		}
+
+		options.Locale = *lang
+	}
+
+	if *driver != 0 {
+		exists, err := isDriverOf(*car, *driver)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return fmt.Errorf("Car %d has no driver %d", *car, *driver)
+		}
+
+		options.DriverId = *driver
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	return exportCSV(out, *car, start, end, options)
+}
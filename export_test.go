@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useDefaultConfig(t *testing.T) {
+	previous, previousLocation := config, location
+	config = defaultConfig()
+	// the handlers are tested without logging in, except in auth_test.go:
+	config.Auth.Enabled = false
+	t.Cleanup(func() { config, location = previous, previousLocation })
+
+	err := loadTimeZone(config.Service.TimeZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readCSV(t *testing.T, data string, delimiter rune) [][]string {
+	t.Helper()
+
+	r := csv.NewReader(strings.NewReader(strings.TrimPrefix(data, "\ufeff")))
+	r.Comma = delimiter
+
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+
+	return records
+}
+
+func TestExportCSV(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	options, err := getExportOptions("", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = exportCSV(&buf, 1, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "\ufeff") {
+		t.Error("Expected a byte order mark")
+	}
+
+	records := readCSV(t, buf.String(), ';')
+
+	// a header, four drives and four totals:
+	if len(records) != 9 {
+		t.Fatalf("Expected 9 records, got %d: %v", len(records), records)
+	}
+
+	expected := []string{"2021-03-01", "08:00", "08:30", "Hemma", "Kontoret", "1020", "1040", "20,00", "0:30", "Tjänsteresa", "Möte med kund", "", "50,00"}
+	if strings.Join(records[1], "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %v, got %v", expected, records[1])
+	}
+
+	totals := map[string]string{}
+	for _, r := range records[5:] {
+		totals[r[0]] = r[7]
+	}
+
+	if totals["Summa tjänsteresor"] != "20,00" || totals["Summa privatresor"] != "5,00" || totals["Summa oklassificerade resor"] != "25,50" || totals["Summa"] != "50,50" {
+		t.Errorf("Unexpected totals %v", totals)
+	}
+}
+
+func TestExportCSVInEnglish(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	options, err := getExportOptions("", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Locale = "en"
+
+	var buf bytes.Buffer
+	err = exportCSV(&buf, 1, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := readCSV(t, buf.String(), ';')
+	if len(records) < 2 || records[1][9] != "Business trip" {
+		t.Errorf("Expected the classification in English, got %v", records)
+	}
+}
+
+func TestExportCSVGroupsAndColumns(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	groupDrives(store, 1, []int64{4, 5}, "test")
+	changeComment(store, "Handla", []int64{}, []int64{1}, "test")
+
+	options, err := getExportOptions(",", ".", "date, distance,comment", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	exportCSV(&buf, 1, time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC), options)
+
+	records := readCSV(t, buf.String(), ',')
+	if len(records) != 6 || strings.Join(records[1], "|") != "2021-03-02|10.00|Handla" {
+		t.Errorf("Expected the group as one row, got %v", records)
+	}
+
+	options.Grouped = false
+	buf.Reset()
+	exportCSV(&buf, 1, time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC), options)
+
+	records = readCSV(t, buf.String(), ',')
+	if len(records) != 7 {
+		t.Errorf("Expected both drives of the group, got %v", records)
+	}
+}
+
+func TestExportCSVLabelsTotals(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	tests := []struct {
+		columns string
+		label   int
+		width   int
+	}{
+		{"distance,date,comment", 1, 3},
+		{"distance,duration", 0, 3},
+	}
+
+	for _, test := range tests {
+		options, err := getExportOptions("", "", test.columns, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		exportCSV(&buf, 1, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC), options)
+
+		records := readCSV(t, buf.String(), ';')
+		sum := records[len(records)-1]
+		if len(sum) != test.width || sum[test.label] != "Summa" {
+			t.Errorf("%s: expected the label in column %d, got %v", test.columns, test.label, sum)
+		}
+	}
+}
+
+func TestGetExportOptionsRejectsInvalidValues(t *testing.T) {
+	useDefaultConfig(t)
+
+	for _, args := range [][4]string{{`""`, "", "", ""}, {"\"", "", "", ""}, {"", "", "date,speed", ""}, {"", "", "", "maybe"}} {
+		_, err := getExportOptions(args[0], args[1], args[2], args[3])
+		if err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+}
+
+func TestExportPeriod(t *testing.T) {
+	tests := []struct {
+		year, month int
+		from, to    string
+		start, end  string
+	}{
+		{2021, 3, "", "", "2021-03-01", "2021-04-01"},
+		{2021, 12, "", "", "2021-12-01", "2022-01-01"},
+		{2021, 0, "", "", "2021-01-01", "2022-01-01"},
+		{0, 0, "2021-03-05", "2021-03-05", "2021-03-05", "2021-03-06"},
+	}
+
+	for _, test := range tests {
+		start, end, err := exportPeriod(test.year, test.month, test.from, test.to)
+		if err != nil || start.Format("2006-01-02") != test.start || end.Format("2006-01-02") != test.end {
+			t.Errorf("%+v: got %v - %v (%v)", test, start, end, err)
+		}
+	}
+
+	_, _, err := exportPeriod(0, 0, "2021-03-05", "2021-03-01")
+	if err == nil {
+		t.Error("Expected a period ending before it starts to be rejected")
+	}
+}
+
+func TestExportHandler(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/export?car=1&year=2021&month=3", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") || !strings.Contains(w.Header().Get("Content-Disposition"), "korjournal-1-20210301-20210331.csv") {
+		t.Errorf("Unexpected headers %v", w.Header())
+	}
+
+	records := readCSV(t, w.Body.String(), ';')
+	if len(records) != 1+5+4 {
+		t.Errorf("Expected the five drives of March, got %d records", len(records))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/export?car=1%20OR%201=1&year=2021", nil)
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid car, got %d", w.Code)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goodsign/monday"
+)
+
+// defaultLocale is the language of the journal unless the configuration, the user or the
+// browser asks for another; it is also the language of texts missing from another catalog.
+const defaultLocale = "sv"
+
+// isLocale tells whether there is a catalog of the language.
+func isLocale(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// locales returns the languages there are catalogs of, the default first.
+func locales() []string {
+	var langs []string
+	for lang := range catalogs {
+		if lang != defaultLocale {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+
+	return append([]string{defaultLocale}, langs...)
+}
+
+// translate returns the text of the key in the language, formatted with args if there are any.
+// Texts missing from the catalog of the language are taken from the default one.
+func translate(lang, key string, args ...interface{}) string {
+	message, ok := catalogs[lang][key]
+	if !ok {
+		message, ok = catalogs[defaultLocale][key]
+	}
+
+	if !ok {
+		log.Println("Missing message: " + key)
+		message = key
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+
+	return message
+}
+
+// configLocale returns the language of the configuration.
+func configLocale() string {
+	if isLocale(config.Service.Locale) {
+		return config.Service.Locale
+	}
+
+	return defaultLocale
+}
+
+// acceptedLocale returns the language the Accept-Language header prefers the most of those
+// there are catalogs of. Regions are ignored, so en-US asks for en.
+func acceptedLocale(header string) (string, bool) {
+	best, bestQ := "", 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.IndexByte(lang, '-'); i >= 0 {
+			lang = lang[:i]
+		}
+
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				var err error
+				q, err = strconv.ParseFloat(f[2:], 64)
+				if err != nil {
+					q = 0
+				}
+			}
+		}
+
+		if isLocale(lang) && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+
+	return best, best != ""
+}
+
+// requestLocale returns the language to answer a request in: the one the user chose, otherwise
+// the one the browser asks for, otherwise the one of the configuration.
+func requestLocale(r *http.Request) string {
+	if session, ok := currentSession(r); ok && isLocale(session.Locale) {
+		return session.Locale
+	}
+
+	if lang, ok := acceptedLocale(r.Header.Get("Accept-Language")); ok {
+		return lang
+	}
+
+	return configLocale()
+}
+
+// monthNames returns the months of the year in the language.
+func monthNames(lang string) []Month {
+	var months []Month
+	for m := 1; m <= 12; m++ {
+		months = append(months, Month{m, translate(lang, "month_"+strconv.Itoa(m))})
+	}
+
+	return months
+}
+
+// dateString returns the weekday and date of a day in the language, as the journal heads
+// its days.
+func dateString(day time.Time, lang string) string {
+	locale := monday.LocaleSvSE
+	if lang == "en" {
+		locale = monday.LocaleEnGB
+	}
+
+	return strings.ToUpper(monday.Format(day, "Monday 2 January", locale))
+}
+
+// Language is a choice of language on the pages.
+type Language struct {
+	Code string
+	Name string
+}
+
+// templateFuncs returns the functions the pages use for their texts in the language: t gives
+// the text of a key, messages the whole catalog for the scripts, lang the language itself and
+// languages those there are to choose from.
+func templateFuncs(lang string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(key string, args ...interface{}) string {
+			return translate(lang, key, args...)
+		},
+		"messages": func() map[string]string {
+			return catalogs[lang]
+		},
+		"lang": func() string {
+			return lang
+		},
+		"languages": func() []Language {
+			var languages []Language
+			for _, code := range locales() {
+				languages = append(languages, Language{code, translate(code, "language_name")})
+			}
+
+			return languages
+		},
+	}
+}
+
+// parseTemplates parses a page once for every language.
+func parseTemplates(filename string) map[string]*template.Template {
+	templates := make(map[string]*template.Template)
+	for lang := range catalogs {
+		templates[lang] = template.Must(template.New(filename).Funcs(templateFuncs(lang)).ParseFiles(filename))
+	}
+
+	return templates
+}
+
+// postLocale saves the language the user chose for the pages; an empty one lets the browser
+// choose again.
+func postLocale(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, translate(requestLocale(r), "bad_request"), http.StatusBadRequest)
+		return
+	}
+
+	lang := r.Form.Get("locale")
+	if lang != "" && !isLocale(lang) {
+		http.Error(w, "Unknown locale: "+strconv.Quote(lang), http.StatusBadRequest)
+		return
+	}
+
+	session, _ := currentSession(r)
+
+	err = store.SetLocale(session.Username, lang)
+	if err != nil {
+		log.Println("Error saving locale: " + err.Error())
+		http.Error(w, "Error saving locale", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, localPath(r.Form.Get("next")), http.StatusSeeOther)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCatalogsHaveTheSameKeys(t *testing.T) {
+	for lang, catalog := range catalogs {
+		for key, message := range catalogs[defaultLocale] {
+			translated, ok := catalog[key]
+			if !ok {
+				t.Errorf("Expected %s in the %s catalog", key, lang)
+				continue
+			}
+
+			// the values formatted into the texts must be the same:
+			if strings.Count(translated, "%") != strings.Count(message, "%") {
+				t.Errorf("Expected %s in the %s catalog to take the same values as %q, got %q", key, lang, message, translated)
+			}
+		}
+
+		for key := range catalog {
+			if _, ok := catalogs[defaultLocale][key]; !ok {
+				t.Errorf("Expected %s of the %s catalog in the default one", key, lang)
+			}
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if s := translate("en", "n_trips", 3); s != "3 trips" {
+		t.Errorf("Expected 3 trips, got %q", s)
+	}
+
+	if s := translate("fi", "n_trips", 3); s != "3 resor" {
+		t.Errorf("Expected an unknown language to fall back to Swedish, got %q", s)
+	}
+
+	if s := translate("en", "no_such_key"); s != "no_such_key" {
+		t.Errorf("Expected a missing key to show itself, got %q", s)
+	}
+}
+
+func TestAcceptedLocale(t *testing.T) {
+	for _, c := range []struct {
+		header string
+		lang   string
+	}{
+		{"", ""},
+		{"en-US,en;q=0.9", "en"},
+		{"sv-SE", "sv"},
+		{"fi, en;q=0.5, sv;q=0.8", "sv"},
+		{"de;q=1.0, en-GB;q=0.3", "en"},
+		{"en;q=0, sv;q=0.1", "sv"},
+		{"fi, de", ""},
+	} {
+		lang, ok := acceptedLocale(c.header)
+		if lang != c.lang || ok != (c.lang != "") {
+			t.Errorf("Expected %q for %q, got %q", c.lang, c.header, lang)
+		}
+	}
+}
+
+func TestMonthsAndDatesInLocale(t *testing.T) {
+	if m := monthNames("en")[2]; m.Number != 3 || m.Name != "March" {
+		t.Errorf("Expected March, got %v", m)
+	}
+
+	if m := monthNames("sv")[4]; m.Name != "Maj" {
+		t.Errorf("Expected Maj, got %v", m)
+	}
+
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	if s := dateString(day, "en"); s != "MONDAY 1 MARCH" {
+		t.Errorf("Expected MONDAY 1 MARCH, got %q", s)
+	}
+}
+
+func TestCategoryLabelsInLocale(t *testing.T) {
+	for _, c := range []struct {
+		category Category
+		label    string
+	}{
+		{Category{Label: "Tjänsteresa", CssClass: "business"}, "Business trip"},
+		{Category{Label: "Privat resa", CssClass: "private"}, "Private trip"},
+		{Category{Label: "Jobbresa", CssClass: "business"}, "Jobbresa"},
+		{Category{Label: "Pendling", CssClass: "commute"}, "Pendling"},
+	} {
+		if label := categoryLabel(c.category, "en"); label != c.label {
+			t.Errorf("Expected %q for %+v, got %q", c.label, c.category, label)
+		}
+	}
+}
+
+func TestPagesInLocale(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	get := func(path, acceptLanguage string) string {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept-Language", acceptLanguage)
+
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 from %s, got %d", path, w.Code)
+		}
+
+		return w.Body.String()
+	}
+
+	for _, path := range []string{"/", "/details/3", "/annual/1/2021", "/settings/rates", "/settings/drivers/1"} {
+		if body := get(path, "en-US,en;q=0.9"); !strings.Contains(body, `<html lang="en">`) || !strings.Contains(body, "Tesla Driving Journal") {
+			t.Errorf("Expected %s in English", path)
+		}
+
+		if body := get(path, ""); !strings.Contains(body, `<html lang="sv">`) || !strings.Contains(body, "Tesla Körjournal") {
+			t.Errorf("Expected %s in Swedish by default", path)
+		}
+	}
+
+	// the configuration chooses for browsers asking for neither:
+	config.Service.Locale = "en"
+	if body := get("/", "fi"); !strings.Contains(body, "Total distance") {
+		t.Errorf("Expected the journal in the language of the configuration")
+	}
+}
+
+func TestUserLocale(t *testing.T) {
+	useFixtures(t)
+	useAuth(t)
+
+	cookies := login(t, "anna", "hemligt lösenord")
+	csrf := cookie(cookies, csrfCookie)
+
+	get := func() string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", "sv-SE")
+
+		return serve(r, cookies).Body.String()
+	}
+
+	choose := func(lang string) *httptest.ResponseRecorder {
+		form := url.Values{"locale": {lang}, "next": {"/"}, csrfField: {csrf}}
+		r := httptest.NewRequest(http.MethodPost, "/locale", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		return serve(r, cookies)
+	}
+
+	w := choose("en")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Fatalf("Expected to be sent back to the journal, got %d", w.Code)
+	}
+
+	// the choice of the user goes before that of the browser:
+	if body := get(); !strings.Contains(body, "Total distance") {
+		t.Errorf("Expected the journal in the language of the user")
+	}
+
+	// so do the messages of failed actions:
+	r := actionRequest(url.Values{"action": {"classify"}, "year": {"2021"}, "month": {"3"}, "car": {"1"}}, csrf)
+	if w := serve(r, cookies); !strings.Contains(w.Body.String(), "Invalid classification") {
+		t.Errorf("Expected the error in English, got %s", w.Body.String())
+	}
+
+	if w := choose("fi"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown language to be refused, got %d", w.Code)
+	}
+
+	choose("")
+	if body := get(); !strings.Contains(body, "Total körsträcka") {
+		t.Errorf("Expected the browser to choose again")
+	}
+
+	// the user command changes it too:
+	var out bytes.Buffer
+	err := runUserCommand([]string{"locale", "anna", "en"}, nil, &out)
+	if err != nil || !strings.Contains(get(), "Total distance") {
+		t.Errorf("Expected the user command to set the language, got %v", err)
+	}
+
+	if runUserCommand([]string{"locale", "anna", "fi"}, nil, &out) == nil {
+		t.Error("Expected an unknown language to be refused")
+	}
+
+	if runUserCommand([]string{"locale", "bertil"}, nil, &out) == nil {
+		t.Error("Expected an unknown user to be refused")
+	}
+}
+
+func TestExportsInLocale(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/export?car=1&year=2021&month=3&columns=date,distance", nil)
+	r.Header.Set("Accept-Language", "en")
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	records := readCSV(t, w.Body.String(), ';')
+	if strings.Join(records[0], ",") != "Date,Distance (km)" {
+		t.Errorf("Expected English headers, got %v", records[0])
+	}
+
+	if last := records[len(records)-1]; last[0] != "Total" {
+		t.Errorf("Expected the English total last, got %v", last)
+	}
+
+	data, err := getAnnualData(Car{Id: 1, Model: "3", Name: "Tesla"}, 2021, Driver{}, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Months[0].Name != "January" {
+		t.Errorf("Expected the months in English, got %s", data.Months[0].Name)
+	}
+
+	var buf bytes.Buffer
+	options, _ := getExportOptions("", "", "", "")
+	err = writeAnnualCSV(&buf, data, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "Date;Odometer at start;") {
+		t.Errorf("Expected English headers, got %q", buf.String())
+	}
+}
@@ -0,0 +1,782 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// decorateDrive fills in the display strings of a drive in the language.
+func decorateDrive(drive *Drive, categories map[int]Category, lang string) {
+	drive.ClassificationClass, drive.ClassificationString = classificationStrings(drive.Classification, categories, lang)
+
+	drive.StartTime = convertTime(drive.StartDate).Format("15:04")
+	drive.EndTime = convertTime(drive.EndDate).Format("15:04")
+
+	h, m := minutesToHoursAndMinutes(drive.Duration)
+	drive.DurationString = fmt.Sprintf("%d:%02d", h, m)
+	drive.DistanceString = fmt.Sprintf("%.2f", drive.Distance)
+}
+
+// reimburseDrive fills in the amount owed for a drive.
+func reimburseDrive(drive *Drive, table reimbursementTable) {
+	drive.Reimbursement = table.amount(drive.Classification, drive.StartDate, drive.Distance)
+	drive.ReimbursementString = formatAmount(drive.Reimbursement, ".")
+}
+
+// decorateGroupedDrives fills in the display strings of a grouped drive in the language.
+func decorateGroupedDrives(gd *GroupedDrives, categories map[int]Category, lang string) {
+	gd.ClassificationClass, gd.ClassificationString = classificationStrings(gd.Classification, categories, lang)
+
+	gd.StartTime = convertTime(gd.StartDate).Format("15:04")
+	gd.EndTime = convertTime(gd.EndDate).Format("15:04")
+
+	h, m := minutesToHoursAndMinutes(gd.Duration)
+	gd.DurationString = fmt.Sprintf("%d:%02d", h, m)
+	gd.DistanceString = fmt.Sprintf("%.2f", gd.Distance)
+}
+
+// reimburseGroupedDrives fills in the amount owed for a grouped drive.
+func reimburseGroupedDrives(gd *GroupedDrives, table reimbursementTable) {
+	gd.Reimbursement = table.amount(gd.Classification, gd.StartDate, gd.Distance)
+	gd.ReimbursementString = formatAmount(gd.Reimbursement, ".")
+}
+
+func getCars() ([]Car, error) {
+	return store.GetCars()
+}
+
+func getFirstAndLastYears() (int, int, error) {
+	return store.GetFirstAndLastYears()
+}
+
+func getPositions(driveIds []int64) ([]Position, error) {
+	return store.GetPositions(driveIds)
+}
+
+func getDriveIdsForGroups(groupedDrives []int64) ([]int64, error) {
+	return store.GetDriveIdsForGroups(groupedDrives)
+}
+
+// getDriverNames returns the names of the drivers of a car by their ids.
+func getDriverNames(carId int) map[int32]string {
+	names := make(map[int32]string)
+
+	drivers, err := store.GetDrivers(carId)
+	if err != nil {
+		log.Println("Error retrieving drivers from database: " + err.Error())
+	}
+
+	for _, d := range drivers {
+		names[int32(d.Id)] = d.Name
+	}
+
+	return names
+}
+
+// ofDriver tells if a drive assigned to assigned belongs in the journal of the driver; all
+// drives do if driverId is 0.
+func ofDriver(assigned sql.NullInt32, driverId int) bool {
+	return driverId == 0 || (assigned.Valid && int(assigned.Int32) == driverId)
+}
+
+// getDrives returns the drives of the period, only those of the driver unless driverId is 0,
+// displayed in the language.
+func getDrives(carId int, from, to time.Time, driverId int, lang string) ([]Drive, error) {
+	categories, err := getCategoryMap()
+	if err != nil {
+		log.Println("Error retrieving categories from database: " + err.Error())
+	}
+
+	table, err := getReimbursementTable()
+	if err != nil {
+		log.Println("Error retrieving rates from database: " + err.Error())
+	}
+
+	all, err := store.GetDrives(carId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	names := getDriverNames(carId)
+
+	var drives []Drive
+	for _, drive := range all {
+		if !ofDriver(drive.DriverId, driverId) {
+			continue
+		}
+
+		decorateDrive(&drive, categories, lang)
+		reimburseDrive(&drive, table)
+		drive.DriverName = names[drive.DriverId.Int32]
+
+		drives = append(drives, drive)
+	}
+
+	err = suggestClassifications(store, drives, categories, lang)
+	if err != nil {
+		log.Println("Error suggesting classifications: " + err.Error())
+	}
+
+	return drives, nil
+}
+
+// getDrivesPage returns a page of the drives of the period along with the number of drives in
+// all, see JournalStore.GetDrivesPage. Unlike getDrives, it doesn't suggest classifications.
+func getDrivesPage(carId, driverId int, from, to time.Time, classification, limit, offset int, lang string) ([]Drive, int, error) {
+	categories, err := getCategoryMap()
+	if err != nil {
+		log.Println("Error retrieving categories from database: " + err.Error())
+	}
+
+	table, err := getReimbursementTable()
+	if err != nil {
+		log.Println("Error retrieving rates from database: " + err.Error())
+	}
+
+	drives, total, err := store.GetDrivesPage(carId, driverId, from, to, classification, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	names := getDriverNames(carId)
+	for i := range drives {
+		decorateDrive(&drives[i], categories, lang)
+		reimburseDrive(&drives[i], table)
+		drives[i].DriverName = names[drives[i].DriverId.Int32]
+	}
+
+	return drives, total, nil
+}
+
+func getDriveById(driveId int, lang string) (Drive, string, error) {
+	categories, err := getCategoryMap()
+	if err != nil {
+		log.Println("Error retrieving categories from database: " + err.Error())
+	}
+
+	table, err := getReimbursementTable()
+	if err != nil {
+		log.Println("Error retrieving rates from database: " + err.Error())
+	}
+
+	drive, err := store.GetDriveById(driveId)
+	if err != nil {
+		return drive, "", err
+	}
+
+	decorateDrive(&drive, categories, lang)
+	reimburseDrive(&drive, table)
+	drive.DriverName = getDriverNames(drive.CarId)[drive.DriverId.Int32]
+
+	drives := []Drive{drive}
+	err = suggestClassifications(store, drives, categories, lang)
+	if err != nil {
+		log.Println("Error suggesting classification: " + err.Error())
+	}
+
+	return drives[0], drive.Comment.String, nil
+}
+
+func getGroupedDrivesById(id int, lang string) (GroupedDrives, error) {
+	categories, err := getCategoryMap()
+	if err != nil {
+		log.Println("Error retrieving categories from database: " + err.Error())
+	}
+
+	table, err := getReimbursementTable()
+	if err != nil {
+		log.Println("Error retrieving rates from database: " + err.Error())
+	}
+
+	gd, err := store.GetGroupedDrivesById(id)
+	if err != nil {
+		return gd, err
+	}
+
+	decorateGroupedDrives(&gd, categories, lang)
+	reimburseGroupedDrives(&gd, table)
+	gd.DriverName = getDriverNames(gd.CarId)[gd.DriverId.Int32]
+
+	return gd, nil
+}
+
+// getGroupedDrives returns the grouped drives of the period by the date they start, displayed
+// in the language. Unless driverId is 0, only the groups whose drives are all the driver's are
+// returned.
+func getGroupedDrives(carId int, from, to time.Time, driverId int, lang string) (map[time.Time][]GroupedDrives, error) {
+	groupedDrives := make(map[time.Time][]GroupedDrives)
+
+	categories, err := getCategoryMap()
+	if err != nil {
+		log.Println("Error retrieving categories from database: " + err.Error())
+	}
+
+	table, err := getReimbursementTable()
+	if err != nil {
+		log.Println("Error retrieving rates from database: " + err.Error())
+	}
+
+	groups, err := store.GetGroupedDrives(carId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	names := getDriverNames(carId)
+
+	for _, gd := range groups {
+		if !ofDriver(gd.DriverId, driverId) {
+			continue
+		}
+
+		decorateGroupedDrives(&gd, categories, lang)
+		reimburseGroupedDrives(&gd, table)
+		gd.DriverName = names[gd.DriverId.Int32]
+
+		key := stripTime(convertTime(gd.StartDate))
+		groupedDrives[key] = append(groupedDrives[key], gd)
+	}
+
+	return groupedDrives, nil
+}
+
+// getTotals returns the totals of the month, or of the whole year if month is 0, including
+// the amount owed for the drives at the rates valid when they were made. Unless driverId
+// is 0, only the drives of the driver are counted.
+func getTotals(year, month, carId, driverId int) (Totals, error) {
+	from := localDate(year, month, 1)
+	to := from.AddDate(0, 1, 0)
+
+	if month == 0 {
+		from = localDate(year, 1, 1)
+		to = from.AddDate(1, 0, 0)
+	}
+
+	return getTotalsBetween(carId, driverId, from, to)
+}
+
+// getTotalsBetween returns the totals of the drives starting within [from, to), like getTotals.
+func getTotalsBetween(carId, driverId int, from, to time.Time) (Totals, error) {
+	totals, err := store.GetTotals(carId, driverId, from, to)
+	if err != nil {
+		return totals, err
+	}
+
+	table, err := getReimbursementTable()
+	if err != nil {
+		return totals, err
+	}
+
+	drives, err := store.GetDrives(carId, from, to)
+	if err != nil {
+		return totals, err
+	}
+
+	for _, drive := range drives {
+		if ofDriver(drive.DriverId, driverId) {
+			totals.Reimbursement += table.amount(drive.Classification, drive.StartDate, drive.Distance)
+		}
+	}
+
+	return totals, nil
+}
+
+// getAffectedDates returns the range of whole days covered by the drives and grouped drives.
+func getAffectedDates(s JournalStore, driveIds []int64, groupedDriveIds []int64) (*time.Time, *time.Time, error) {
+	minD, maxD, err := s.GetDateRange(driveIds, groupedDriveIds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	minDate := stripTime(convertTime(minD))
+	maxDate := stripTime(convertTime(maxD)).AddDate(0, 0, 1)
+
+	return &minDate, &maxDate, nil
+}
+
+var errMonthClosed = errors.New("The drives are in a closed month")
+
+// monthStart returns the first day of the month that t is in, in the time zone of the journal.
+// Like the months in tj_closed_months, it is a date rather than an instant, so it is given in UTC.
+func monthStart(t time.Time) time.Time {
+	t = convertTime(t)
+
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// checkMonthsOpen fails with errMonthClosed if any of the drives or grouped drives start in a
+// closed month.
+func checkMonthsOpen(s JournalStore, driveIds []int64, groupedDriveIds []int64) error {
+	closed, err := s.GetClosedMonthsOf(driveIds, groupedDriveIds)
+	if err != nil {
+		return err
+	}
+
+	if len(closed) > 0 {
+		return fmt.Errorf("%w: %s", errMonthClosed, closed[0].Format("2006-01"))
+	}
+
+	return nil
+}
+
+func changeClassification(s JournalStore, classification int, drives []int64, groupedDrives []int64, user string) (*time.Time, *time.Time, error) {
+	if (len(drives) + len(groupedDrives)) == 0 {
+		return nil, nil, errors.New("Attempt to classify drives failed; no drive ids or grouped drive ids specified")
+	}
+
+	err := checkMonthsOpen(s, drives, groupedDrives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := classificationChanges(s, classification, drives, groupedDrives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.ChangeClassification(classification, drives, groupedDrives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = audit(s, user, changes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return getAffectedDates(s, drives, groupedDrives)
+}
+
+// assignDriver assigns the drives, and the drives of the grouped drives, to a driver, or
+// leaves them without one if driverId is 0.
+func assignDriver(s JournalStore, driverId int, drives []int64, groupedDrives []int64, user string) (*time.Time, *time.Time, error) {
+	if (len(drives) + len(groupedDrives)) == 0 {
+		return nil, nil, errors.New("Attempt to assign drives failed; no drive ids or grouped drive ids specified")
+	}
+
+	err := checkMonthsOpen(s, drives, groupedDrives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := driverChanges(s, driverId, drives, groupedDrives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.AssignDriver(driverId, drives, groupedDrives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = audit(s, user, changes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return getAffectedDates(s, drives, groupedDrives)
+}
+
+func changeComment(s JournalStore, comment string, drives []int64, groupedDrives []int64, user string) (*time.Time, *time.Time, error) {
+	if (len(drives) + len(groupedDrives)) == 0 {
+		return nil, nil, errors.New("Attempt to comment drives failed; no drive ids or grouped drive ids specified")
+	}
+
+	err := checkMonthsOpen(s, drives, groupedDrives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	comment = strings.TrimSpace(comment)
+
+	changes, err := commentChanges(s, comment, drives, groupedDrives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.ChangeComment(comment, drives, groupedDrives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = audit(s, user, changes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return getAffectedDates(s, drives, groupedDrives)
+}
+
+var errInvalidGroup = errors.New("The drives can't be grouped")
+
+// checkGroupable fails with errInvalidGroup unless all of the drives are drives of the car that
+// aren't grouped already.
+func checkGroupable(s JournalStore, car int, drives []int64) error {
+	for _, id := range drives {
+		d, err := s.GetDriveById(int(id))
+		if err == sql.ErrNoRows || (err == nil && d.CarId != car) {
+			return fmt.Errorf("%w: drive %d isn't a drive of car %d", errInvalidGroup, id, car)
+		} else if err != nil {
+			return err
+		}
+
+		if d.GroupId.Valid {
+			return fmt.Errorf("%w: drive %d is already grouped", errInvalidGroup, id)
+		}
+	}
+
+	return nil
+}
+
+func groupDrives(s JournalStore, car int, drives []int64, user string) (*time.Time, *time.Time, error) {
+	if len(drives) == 0 {
+		return nil, nil, errors.New("Attempt to group drives failed; no drive ids specified")
+	}
+
+	err := checkGroupable(s, car, drives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = checkMonthsOpen(s, drives, []int64{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.GroupDrives(car, drives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := groupChanges(s, drives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = audit(s, user, changes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return getAffectedDates(s, drives, []int64{})
+}
+
+func ungroupDrives(s JournalStore, car int, groupedDrives []int64, copyComment bool, user string) (*time.Time, *time.Time, error) {
+	if len(groupedDrives) == 0 {
+		return nil, nil, errors.New("Attempt to ungroup drives failed; no group drive ids specified")
+	}
+
+	err := checkMonthsOpen(s, []int64{}, groupedDrives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	from, to, err := getAffectedDates(s, []int64{}, groupedDrives)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := ungroupChanges(s, groupedDrives, copyComment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.UngroupDrives(groupedDrives, copyComment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = audit(s, user, changes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return from, to, nil
+}
+
+// closeMonth closes the month of a car so that its drives can't be changed any more.
+func closeMonth(s JournalStore, carId, year, month int, user string) (*time.Time, *time.Time, error) {
+	from := localDate(year, month, 1)
+	to := from.AddDate(0, 1, 0)
+
+	err := s.CloseMonth(carId, time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC), user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &from, &to, nil
+}
+
+// reopenMonth reopens a closed month of a car. The reason is logged along with the user.
+func reopenMonth(s JournalStore, carId, year, month int, user, reason string) (*time.Time, *time.Time, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, nil, errors.New("Attempt to reopen month failed; no reason given")
+	}
+
+	from := localDate(year, month, 1)
+	to := from.AddDate(0, 1, 0)
+
+	err := s.ReopenMonth(carId, time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC), user, reason)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &from, &to, nil
+}
+
+// isMonthClosed tells whether the month of a car has been closed.
+func isMonthClosed(carId, year, month int) (bool, error) {
+	closed, err := store.GetClosedMonths(carId)
+	if err != nil {
+		return false, err
+	}
+
+	first := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	for _, m := range closed {
+		if m.Equal(first) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// generateMain returns the journal of the month in the language, only with the drives of the
+// driver unless driverId is 0.
+func generateMain(year, month, carId, driverId int, lang string) MainData {
+	var data MainData
+
+	data.Year = year
+	data.Month = month
+	data.CarId = carId
+	data.DriverId = driverId
+
+	cars, err := getCars()
+	if err != nil {
+		log.Println("Error retrieving cars from database: " + err.Error())
+	}
+	data.DropdownCars = cars
+
+	data.Categories, err = store.GetCategories()
+	if err != nil {
+		log.Println("Error retrieving categories from database: " + err.Error())
+	}
+	localizeCategories(data.Categories, lang)
+
+	data.Drivers, err = store.GetDrivers(carId)
+	if err != nil {
+		log.Println("Error retrieving drivers from database: " + err.Error())
+	}
+
+	data.Closed, err = isMonthClosed(carId, year, month)
+	if err != nil {
+		log.Println("Error retrieving closed months from database: " + err.Error())
+	}
+
+	categories := make(map[int]Category)
+	for _, c := range data.Categories {
+		categories[c.Id] = c
+	}
+
+	from := localDate(year, month, 1)
+	to := from.AddDate(0, 1, 0)
+
+	drives, err := getDrives(carId, from, to, driverId, lang)
+	if err != nil {
+		log.Println("Error retrieving drives from database: " + err.Error())
+	}
+
+	groupedDrives, err := getGroupedDrives(carId, from, to, driverId, lang)
+	if err != nil {
+		log.Println("Error retrieving grouped drives from database: " + err.Error())
+	}
+
+	var days []Day
+	var day *Day = nil
+	current := -1
+	for _, drive := range drives {
+		d := convertTime(drive.StartDate).Day()
+		if d != current {
+			if day != nil {
+				days = append(days, *day)
+			}
+
+			day = new(Day)
+			day.Date = stripTime(convertTime(drive.StartDate))
+
+			if gd, exists := groupedDrives[day.Date]; exists {
+				day.GroupedDrives = gd
+			}
+
+			day.DateString = dateString(day.Date, lang)
+			day.DateAsTs = day.Date.Unix()
+
+			current = d
+		}
+
+		day.Drives = append(day.Drives, drive)
+	}
+
+	if day != nil {
+		days = append(days, *day)
+	}
+
+	// the drives are only shown from here on:
+	data.Unit = carUnit(carId)
+	convertDays(days, data.Unit)
+	data.Days = days
+
+	data.DropdownYears = make([]int, 0)
+	firstYear, lastYear, err := getFirstAndLastYears()
+	if err != nil {
+		log.Println("Error retrieving year span")
+		firstYear = 2020
+		lastYear = 2020
+	}
+
+	for y := firstYear; y <= lastYear; y++ {
+		data.DropdownYears = append(data.DropdownYears, y)
+	}
+
+	data.DropdownMonths = monthNames(lang)
+
+	var totalDuration, totalBusinessDuration, totalPrivateDuration, unclassifiedDuration int
+	var totalDistance, totalBusinessDistance, totalPrivateDistance, unclassifiedDistance, unassignedDistance float32
+	var totalReimbursement float64
+
+	for _, day := range data.Days {
+		for _, drive := range day.Drives {
+			totalDuration += drive.Duration
+			totalDistance += drive.Distance
+			totalReimbursement += drive.Reimbursement
+
+			if !drive.DriverId.Valid {
+				unassignedDistance += drive.Distance
+			}
+
+			category, known := categories[int(drive.Classification.Int32)]
+			if drive.Classification.Valid && known {
+				if category.Deductible {
+					totalBusinessDuration += drive.Duration
+					totalBusinessDistance += drive.Distance
+				} else {
+					totalPrivateDuration += drive.Duration
+					totalPrivateDistance += drive.Distance
+				}
+			} else {
+				unclassifiedDuration += drive.Duration
+				unclassifiedDistance += drive.Distance
+			}
+		}
+	}
+
+	h, m := minutesToHoursAndMinutes(totalDuration)
+	data.TotalDurationString = fmt.Sprintf("%d:%02d", h, m)
+	data.TotalDistanceString = fmt.Sprintf("%.1f", totalDistance)
+
+	h, m = minutesToHoursAndMinutes(totalBusinessDuration)
+	data.TotalBusinessDurationString = fmt.Sprintf("%d:%02d", h, m)
+	data.TotalBusinessDistanceString = fmt.Sprintf("%.1f", totalBusinessDistance)
+
+	h, m = minutesToHoursAndMinutes(totalPrivateDuration)
+	data.TotalPrivateDurationString = fmt.Sprintf("%d:%02d", h, m)
+	data.TotalPrivateDistanceString = fmt.Sprintf("%.1f", totalPrivateDistance)
+
+	data.TotalReimbursementString = formatAmount(totalReimbursement, ".")
+
+	if unclassifiedDuration > 0 || unclassifiedDistance > 0 {
+		data.UnclassifiedDrivesRemaining = true
+		h, m = minutesToHoursAndMinutes(unclassifiedDuration)
+		data.UnclassifiedDurationString = fmt.Sprintf("%d:%02d", h, m)
+		data.UnclassifiedDistanceString = fmt.Sprintf("%.1f", unclassifiedDistance)
+	}
+
+	// drives only need a driver once the car has drivers:
+	if len(data.Drivers) > 0 && unassignedDistance > 0 {
+		data.UnassignedDistanceString = fmt.Sprintf("%.1f", unassignedDistance)
+	}
+
+	return data
+}
+
+func getDay(year, month, day, carId, driverId int, lang string) (Day, error) {
+	var d Day
+
+	from := localDate(year, month, day)
+	to := from.AddDate(0, 0, 1)
+
+	drives, err := getDrives(carId, from, to, driverId, lang)
+	if err != nil {
+		log.Println("Error retrieving drives from database: " + err.Error())
+	}
+	d.Drives = drives
+
+	groupedDrives, err := getGroupedDrives(carId, from, to, driverId, lang)
+	if err != nil {
+		log.Println("Error retrieving grouped drives from database: " + err.Error())
+	}
+
+	d.Date = stripTime(from)
+
+	if gd, exists := groupedDrives[d.Date]; exists {
+		d.GroupedDrives = gd
+	}
+
+	d.DateString = dateString(d.Date, lang)
+	d.DateAsTs = d.Date.Unix()
+
+	days := []Day{d}
+	convertDays(days, carUnit(carId))
+
+	return days[0], nil
+}
+
+func getDays(from, to time.Time, carId, driverId int, lang string) ([]Day, error) {
+	drives, err := getDrives(carId, from, to, driverId, lang)
+	if err != nil {
+		log.Println("Error retrieving drives from database: " + err.Error())
+	}
+
+	groupedDrives, err := getGroupedDrives(carId, from, to, driverId, lang)
+	if err != nil {
+		log.Println("Error retrieving grouped drives from database: " + err.Error())
+	}
+
+	var days []Day
+	var day *Day = nil
+	current := -1
+	for _, drive := range drives {
+		d := convertTime(drive.StartDate).Day()
+		if d != current {
+			if day != nil {
+				days = append(days, *day)
+			}
+
+			day = new(Day)
+			day.Date = stripTime(convertTime(drive.StartDate))
+
+			if gd, exists := groupedDrives[day.Date]; exists {
+				day.GroupedDrives = gd
+			}
+
+			day.DateString = dateString(day.Date, lang)
+			day.DateAsTs = day.Date.Unix()
+
+			current = d
+		}
+
+		day.Drives = append(day.Drives, drive)
+	}
+
+	if day != nil {
+		days = append(days, *day)
+	}
+
+	convertDays(days, carUnit(carId))
+
+	return days, nil
+}
@@ -0,0 +1,717 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func driveIds(drives []Drive) []int {
+	var ids []int
+	for _, d := range drives {
+		ids = append(ids, d.Id)
+	}
+
+	return ids
+}
+
+func equalIds(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestGenerateMainBucketsDrivesByDay(t *testing.T) {
+	useFixtures(t)
+
+	data := generateMain(2021, 3, 1, 0, defaultLocale)
+
+	expected := []struct {
+		date   time.Time
+		drives []int
+	}{
+		{localDate(2021, 3, 5), []int{6}},
+		{localDate(2021, 3, 2), []int{5, 4}},
+		{localDate(2021, 3, 1), []int{3, 2}},
+	}
+
+	if len(data.Days) != len(expected) {
+		t.Fatalf("Expected %d days, got %d", len(expected), len(data.Days))
+	}
+
+	for i, e := range expected {
+		day := data.Days[i]
+		if !day.Date.Equal(e.date) {
+			t.Errorf("Day %d: expected date %v, got %v", i, e.date, day.Date)
+		}
+
+		if !equalIds(driveIds(day.Drives), e.drives) {
+			t.Errorf("Day %d: expected drives %v, got %v", i, e.drives, driveIds(day.Drives))
+		}
+	}
+
+	if data.Days[2].Drives[1].DurationString != "0:30" || data.Days[2].Drives[1].DistanceString != "20.00" {
+		t.Errorf("Unexpected display strings %q, %q", data.Days[2].Drives[1].DurationString, data.Days[2].Drives[1].DistanceString)
+	}
+}
+
+func TestGetDaysOnlyReturnsDaysWithDrives(t *testing.T) {
+	useFixtures(t)
+
+	days, err := getDays(time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC), 1, 0, defaultLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(days) != 1 || days[0].Date.Day() != 2 || len(days[0].Drives) != 2 {
+		t.Errorf("Expected only the 2nd with two drives, got %+v", days)
+	}
+}
+
+func TestTotals(t *testing.T) {
+	useFixtures(t)
+
+	totals, err := getTotals(2021, 3, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Totals{
+		TotalDuration:         175,
+		TotalBusinessDuration: 30,
+		TotalPrivateDuration:  20,
+		TotalDistance:         110.5,
+		TotalBusinessDistance: 20,
+		TotalPrivateDistance:  5,
+		UnclassifiedDuration:  125,
+		UnclassifiedDistance:  85.5,
+		// the car has no drivers, so none of its drives has one:
+		UnassignedDistance: 110.5,
+	}
+
+	if totals != expected {
+		t.Errorf("Expected totals %+v, got %+v", expected, totals)
+	}
+
+	data := generateMain(2021, 3, 1, 0, defaultLocale)
+	if data.TotalDistanceString != "110.5" || data.TotalBusinessDurationString != "0:30" || data.UnclassifiedDistanceString != "85.5" {
+		t.Errorf("Unexpected total strings in %+v", data)
+	}
+}
+
+func TestTotalsOfEmptyMonth(t *testing.T) {
+	useFixtures(t)
+
+	totals, err := getTotals(2020, 1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if totals != (Totals{}) {
+		t.Errorf("Expected zero totals, got %+v", totals)
+	}
+}
+
+func TestGroupDrives(t *testing.T) {
+	useFixtures(t)
+
+	from, to, err := groupDrives(store, 1, []int64{2, 3}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !from.Equal(localDate(2021, 3, 1)) || !to.Equal(localDate(2021, 3, 2)) {
+		t.Errorf("Unexpected affected range %v - %v", from, to)
+	}
+
+	days, err := getDays(*from, *to, 1, 0, defaultLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(days) != 1 || len(days[0].GroupedDrives) != 1 {
+		t.Fatalf("Expected one day with one group, got %+v", days)
+	}
+
+	gd := days[0].GroupedDrives[0]
+	if gd.Duration != 75 || gd.Distance != 40.5 || gd.StartAddress != "Hemma" || gd.EndAddress != "Hemma" {
+		t.Errorf("Unexpected group %+v", gd)
+	}
+
+	if gd.StartOdometer != 1020 || gd.EndOdometer != 1061 {
+		t.Errorf("Unexpected odometer readings %d - %d", gd.StartOdometer, gd.EndOdometer)
+	}
+
+	// only one of the drives was classified, so the group can't be:
+	if gd.Classification.Valid {
+		t.Errorf("Expected an unclassified group, got %v", gd.Classification)
+	}
+
+	for _, d := range days[0].Drives {
+		if d.GroupIdInt() != gd.Id {
+			t.Errorf("Drive %d is not part of group %d", d.Id, gd.Id)
+		}
+	}
+
+	// a drive can only be in one group:
+	_, _, err = groupDrives(store, 1, []int64{3, 4}, "test")
+	if !errors.Is(err, errInvalidGroup) {
+		t.Errorf("Expected drive 3 to be grouped already, got %v", err)
+	}
+}
+
+func TestGroupKeepsCommonClassification(t *testing.T) {
+	s := useFixtures(t)
+
+	_, _, err := changeClassification(store, business, []int64{6}, []int64{}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = groupDrives(store, 1, []int64{2, 6}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gd, err := getGroupedDrivesById(s.groups[0].Id, defaultLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gd.Classification.Int32 != business || gd.ClassificationClass != "business" {
+		t.Errorf("Expected a business group, got %v (%s)", gd.Classification, gd.ClassificationClass)
+	}
+}
+
+func TestUngroupDrivesCopiesComment(t *testing.T) {
+	s := useFixtures(t)
+
+	_, _, err := groupDrives(store, 1, []int64{4, 5}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := int64(s.groups[0].Id)
+
+	_, _, err = changeComment(store, "  Handla  ", []int64{}, []int64{group}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = ungroupDrives(store, 1, []int64{group}, true, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []int{4, 5} {
+		d, comment, err := getDriveById(id, defaultLocale)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if d.GroupId.Valid || comment != "Handla" {
+			t.Errorf("Drive %d: expected ungrouped drive with comment, got group %v and comment %q", id, d.GroupId, comment)
+		}
+	}
+}
+
+func TestUngroupDrivesWithoutCopy(t *testing.T) {
+	s := useFixtures(t)
+
+	groupDrives(store, 1, []int64{4, 5}, "test")
+	group := int64(s.groups[0].Id)
+	changeComment(store, "Handla", []int64{}, []int64{group}, "test")
+
+	_, _, err := ungroupDrives(store, 1, []int64{group}, false, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, comment, _ := getDriveById(4, defaultLocale)
+	if comment != "" {
+		t.Errorf("Expected no comment, got %q", comment)
+	}
+}
+
+func TestClassifyGroupClassifiesItsDrives(t *testing.T) {
+	s := useFixtures(t)
+
+	groupDrives(store, 1, []int64{4, 5}, "test")
+	group := int64(s.groups[0].Id)
+
+	from, to, err := changeClassification(store, business, []int64{}, []int64{group}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if from.Day() != 2 || to.Day() != 3 {
+		t.Errorf("Unexpected affected range %v - %v", from, to)
+	}
+
+	for _, id := range []int{4, 5} {
+		d, _, _ := getDriveById(id, defaultLocale)
+		if d.Classification.Int32 != business || d.ClassificationString != "Tjänsteresa" {
+			t.Errorf("Drive %d: expected business, got %v (%q)", id, d.Classification, d.ClassificationString)
+		}
+	}
+
+	totals, _ := getTotals(2021, 3, 1, 0)
+	if totals.TotalBusinessDistance != 30 || totals.TotalPrivateDistance != 0 {
+		t.Errorf("Unexpected totals %+v", totals)
+	}
+}
+
+func TestChangeClassificationRequiresDrives(t *testing.T) {
+	useFixtures(t)
+
+	_, _, err := changeClassification(store, business, []int64{}, []int64{}, "test")
+	if err == nil {
+		t.Error("Expected an error when classifying nothing")
+	}
+}
+
+func TestChangeComment(t *testing.T) {
+	useFixtures(t)
+
+	changeComment(store, " Leverans ", []int64{3}, []int64{}, "test")
+
+	_, comment, _ := getDriveById(3, defaultLocale)
+	if comment != "Leverans" {
+		t.Errorf("Expected trimmed comment, got %q", comment)
+	}
+
+	changeComment(store, "  ", []int64{3}, []int64{}, "test")
+
+	d, _, _ := getDriveById(3, defaultLocale)
+	if d.Comment.Valid {
+		t.Errorf("Expected the comment to be removed, got %q", d.Comment.String)
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	drive := Drive{
+		StartDate:       time.Date(2021, 3, 1, 7, 0, 0, 0, time.UTC), // Monday 08:00 in Sweden
+		StartAddress:    "Hemma",
+		EndAddress:      "Kontoret",
+		StartGeofenceId: sql.NullInt32{Int32: 1, Valid: true},
+		EndGeofenceId:   sql.NullInt32{Int32: 2, Valid: true},
+		Distance:        20,
+	}
+
+	tests := []struct {
+		name    string
+		rule    Rule
+		matches bool
+	}{
+		{"empty", Rule{}, true},
+		{"geofences", Rule{StartGeofenceId: sql.NullInt32{Int32: 1, Valid: true}, EndGeofenceId: sql.NullInt32{Int32: 2, Valid: true}}, true},
+		{"wrong geofence", Rule{EndGeofenceId: sql.NullInt32{Int32: 1, Valid: true}}, false},
+		{"address", Rule{EndAddress: "kontor"}, true},
+		{"wrong address", Rule{EndAddress: "Skolan"}, false},
+		{"weekday", Rule{Weekdays: []int64{1, 2, 3, 4, 5}}, true},
+		{"weekend", Rule{Weekdays: []int64{0, 6}}, false},
+		{"time", Rule{TimeFrom: sql.NullInt32{Int32: 7 * 60, Valid: true}, TimeTo: sql.NullInt32{Int32: 9 * 60, Valid: true}}, true},
+		{"evening", Rule{TimeFrom: sql.NullInt32{Int32: 17 * 60, Valid: true}}, false},
+		{"night", Rule{TimeFrom: sql.NullInt32{Int32: 22 * 60, Valid: true}, TimeTo: sql.NullInt32{Int32: 2 * 60, Valid: true}}, false},
+		{"distance", Rule{MinDistance: sql.NullFloat64{Float64: 10, Valid: true}, MaxDistance: sql.NullFloat64{Float64: 30, Valid: true}}, true},
+		{"too short", Rule{MinDistance: sql.NullFloat64{Float64: 25, Valid: true}}, false},
+	}
+
+	for _, test := range tests {
+		if test.rule.Matches(drive) != test.matches {
+			t.Errorf("%s: expected %v", test.name, test.matches)
+		}
+	}
+}
+
+func TestApplyRulesLeavesManualClassifications(t *testing.T) {
+	useFixtures(t)
+
+	store.CreateRule(Rule{StartGeofenceId: sql.NullInt32{Int32: 1, Valid: true}, Classification: business, Comment: "Jobb"})
+
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	drives, _ := store.GetDrives(1, from, from.AddDate(0, 1, 0))
+
+	classified, err := applyRules(store, 1, drives, false, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// drives 2 and 4 start at home too, but were classified by hand:
+	if classified != 1 {
+		t.Errorf("Expected one drive to be classified, got %d", classified)
+	}
+
+	d, comment, _ := getDriveById(6, defaultLocale)
+	if !d.IsAutoClassified() || d.Classification.Int32 != business || comment != "Jobb" {
+		t.Errorf("Expected drive 6 to be classified by the rule, got %+v", d)
+	}
+
+	d, comment, _ = getDriveById(4, defaultLocale)
+	if d.IsAutoClassified() || d.Classification.Int32 != private || comment != "" {
+		t.Errorf("Expected drive 4 to be left alone, got %+v", d)
+	}
+}
+
+func TestRulesCommand(t *testing.T) {
+	useFixtures(t)
+
+	store.CreateRule(Rule{StartGeofenceId: sql.NullInt32{Int32: 1, Valid: true}, Classification: business, Comment: "Jobb"})
+
+	// showing the journal doesn't apply the rules:
+	generateMain(2021, 3, 1, 0, defaultLocale)
+	if d, _ := store.GetDriveById(6); d.Classification.Valid {
+		t.Errorf("Expected drive 6 to be left unclassified by the journal, got %v", d.Classification)
+	}
+
+	if runRulesCommand([]string{"-year", "2021", "-user", " "}, io.Discard) == nil {
+		t.Error("Expected the rules not to be applied without a user")
+	}
+
+	var out strings.Builder
+	err := runRulesCommand([]string{"-year", "2021", "-month", "3", "-user", "cron"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "Classified 1 drives of car 1") {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+
+	log, _ := store.GetAuditLog()
+	if len(log) == 0 || log[len(log)-1].User != "cron" || log[len(log)-1].EntityId != 6 {
+		t.Errorf("Expected the classification of drive 6 to be logged as made by cron, got %+v", log)
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	useFixtures(t)
+
+	// drives 1 and 2 went to the office on business, drive 3 is the way back:
+	d, _, _ := getDriveById(3, defaultLocale)
+	if d.SuggestedClassification.Int32 != business || d.SuggestionString != "Tjänsteresa (67%)" {
+		t.Errorf("Expected a business suggestion for drive 3, got %v %q", d.SuggestedClassification, d.SuggestionString)
+	}
+
+	// drive 5 is matched on its addresses:
+	d, _, _ = getDriveById(5, defaultLocale)
+	if d.SuggestedClassification.Int32 != private {
+		t.Errorf("Expected a private suggestion for drive 5, got %v", d.SuggestedClassification)
+	}
+
+	d, _, _ = getDriveById(6, defaultLocale)
+	if d.SuggestedClassification.Valid {
+		t.Errorf("Expected no suggestion for drive 6, got %v", d.SuggestedClassification)
+	}
+
+	from, to, err := acceptSuggestions(store, 1, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), "test")
+	if err != nil || from == nil || to == nil {
+		t.Fatal(err)
+	}
+
+	d, _, _ = getDriveById(3, defaultLocale)
+	if d.Classification.Int32 != business || d.IsAutoClassified() {
+		t.Errorf("Expected drive 3 to be classified by hand, got %+v", d)
+	}
+}
+
+func TestAcceptedSuggestionsDontTrainTheModel(t *testing.T) {
+	useFixtures(t)
+
+	office := Drive{StartGeofenceId: sql.NullInt32{Int32: 2, Valid: true}, EndGeofenceId: sql.NullInt32{Int32: 1, Valid: true}}
+
+	model, _ := getSuggestionModel(store)
+	_, before, _ := model.Suggest(office)
+
+	_, _, err := acceptSuggestions(store, 1, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, _ = getSuggestionModel(store)
+	classification, after, _ := model.Suggest(office)
+	if classification != business || after != before {
+		t.Errorf("Expected business with an unchanged confidence of %.2f, got %d with %.2f", before, classification, after)
+	}
+
+	// classifying the drive by hand afterwards trains the model like any other:
+	_, _, err = changeClassification(store, business, []int64{3}, []int64{}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, _ = getSuggestionModel(store)
+	if _, confidence, _ := model.Suggest(office); confidence <= before {
+		t.Errorf("Expected a drive classified by hand to raise the confidence above %.2f, got %.2f", before, confidence)
+	}
+}
+
+func TestDeleteCategoryInUse(t *testing.T) {
+	useFixtures(t)
+
+	err := store.DeleteCategory(business)
+	if err != errCategoryInUse {
+		t.Errorf("Expected errCategoryInUse, got %v", err)
+	}
+
+	c, _ := store.CreateCategory(Category{Label: "Pendling", CssClass: "commute"})
+	err = store.DeleteCategory(c.Id)
+	if err != nil {
+		t.Errorf("Expected unused category to be deleted, got %v", err)
+	}
+}
+
+func TestTransactionRollsBackOnError(t *testing.T) {
+	useFixtures(t)
+
+	failure := errors.New("failure")
+	err := store.Transaction(func(tx JournalStore) error {
+		_, _, err := changeClassification(tx, private, []int64{3}, []int64{}, "test")
+		if err != nil {
+			return err
+		}
+
+		_, _, err = groupDrives(tx, 1, []int64{2, 3}, "test")
+		if err != nil {
+			return err
+		}
+
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("Expected the error of the transaction, got %v", err)
+	}
+
+	d, _, _ := getDriveById(3, defaultLocale)
+	if d.Classification.Valid || d.GroupId.Valid {
+		t.Errorf("Expected drive 3 to be unchanged, got %+v", d)
+	}
+}
+
+func TestClosedMonthRefusesChanges(t *testing.T) {
+	useFixtures(t)
+
+	_, _, err := groupDrives(store, 1, []int64{4, 5}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = closeMonth(store, 1, 2021, 3, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, change := range map[string]func() error{
+		"classify": func() error {
+			_, _, err := changeClassification(store, private, []int64{3}, []int64{}, "test")
+			return err
+		},
+		"comment": func() error {
+			_, _, err := changeComment(store, "Ändrad", []int64{2}, []int64{}, "test")
+			return err
+		},
+		"group": func() error {
+			_, _, err := groupDrives(store, 1, []int64{2, 3}, "test")
+			return err
+		},
+		"ungroup": func() error {
+			_, _, err := ungroupDrives(store, 1, []int64{1}, false, "test")
+			return err
+		},
+		"classify group": func() error {
+			_, _, err := changeClassification(store, business, []int64{}, []int64{1}, "test")
+			return err
+		},
+	} {
+		if err := change(); !errors.Is(err, errMonthClosed) {
+			t.Errorf("%s: expected errMonthClosed, got %v", name, err)
+		}
+	}
+
+	// February is still open:
+	_, _, err = changeComment(store, "Ändrad", []int64{1}, []int64{}, "test")
+	if err != nil {
+		t.Errorf("Expected drives of an open month to be changed, got %v", err)
+	}
+
+	// nor do rules touch a closed month:
+	store.CreateRule(Rule{Classification: business})
+	drives, _ := store.GetDrives(1, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC))
+	classified, err := applyRules(store, 1, drives, false, "test")
+	if err != nil || classified != 0 {
+		t.Errorf("Expected no drives of a closed month to be classified by rules, got %d (%v)", classified, err)
+	}
+}
+
+func TestReopenMonth(t *testing.T) {
+	useFixtures(t)
+
+	_, _, err := reopenMonth(store, 1, 2021, 3, "test", "Fel klassificering")
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a month that isn't closed, got %v", err)
+	}
+
+	closeMonth(store, 1, 2021, 3, "test")
+
+	_, _, err = reopenMonth(store, 1, 2021, 3, "test", "  ")
+	if err == nil {
+		t.Error("Expected a reason to be required")
+	}
+
+	_, _, err = reopenMonth(store, 1, 2021, 3, "admin", "Fel klassificering")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = changeClassification(store, private, []int64{3}, []int64{}, "test")
+	if err != nil {
+		t.Errorf("Expected a reopened month to be changed, got %v", err)
+	}
+
+	log, _ := store.GetClosedMonthLog(1)
+	if len(log) != 2 || log[0].Action != "close" || log[1].Action != "reopen" || log[1].User != "admin" || log[1].Reason != "Fel klassificering" {
+		t.Errorf("Expected the closing and reopening to be logged, got %+v", log)
+	}
+}
+
+// addNightDrives adds drives of car 2 around midnight and the changes to and from daylight
+// saving time in Stockholm: 2021-03-28 02:00 CET became 03:00 CEST, and 2021-10-31 03:00 CEST
+// became 02:00 CET.
+func addNightDrives(s *memoryStore) {
+	for i, start := range []string{
+		"2021-03-27T23:30:00Z", // 00:30 CET on the 28th
+		"2021-03-28T21:45:00Z", // 23:45 CEST on the 28th
+		"2021-03-31T22:30:00Z", // 00:30 CEST on April 1st
+		"2021-10-31T00:30:00Z", // 02:30 CEST on the 31st, before the change
+		"2021-10-31T01:30:00Z", // 02:30 CET on the 31st, after the change
+		"2021-10-31T22:30:00Z", // 23:30 CET on the 31st
+		"2021-10-31T23:10:00Z", // 00:10 CET on November 1st
+	} {
+		t, _ := time.Parse(time.RFC3339, start)
+		s.drives = append(s.drives, Drive{Id: 100 + i, CarId: 2, StartDate: t, EndDate: t.Add(10 * time.Minute), Duration: 10, Distance: 1})
+	}
+}
+
+func TestDaysInTimeZone(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+	addNightDrives(s)
+
+	for _, c := range []struct {
+		year, month int
+		days        map[int][]int
+		times       map[int]string
+	}{
+		{2021, 3, map[int][]int{28: {101, 100}, 1: {7}}, map[int]string{100: "00:30", 101: "23:45"}},
+		{2021, 4, map[int][]int{1: {102}}, map[int]string{102: "00:30"}},
+		{2021, 10, map[int][]int{31: {105, 104, 103}}, map[int]string{103: "02:30", 104: "02:30", 105: "23:30"}},
+		{2021, 11, map[int][]int{1: {106}}, map[int]string{106: "00:10"}},
+	} {
+		data := generateMain(c.year, c.month, 2, 0, defaultLocale)
+
+		if len(data.Days) != len(c.days) {
+			t.Errorf("%d-%02d: expected %d days, got %d", c.year, c.month, len(c.days), len(data.Days))
+			continue
+		}
+
+		for _, day := range data.Days {
+			if !day.Date.Equal(localDate(c.year, c.month, day.Date.Day())) {
+				t.Errorf("%d-%02d: expected the day to start at local midnight, got %v", c.year, c.month, day.Date)
+			}
+
+			if !equalIds(driveIds(day.Drives), c.days[day.Date.Day()]) {
+				t.Errorf("%d-%02d-%02d: expected drives %v, got %v", c.year, c.month, day.Date.Day(), c.days[day.Date.Day()], driveIds(day.Drives))
+			}
+
+			for _, d := range day.Drives {
+				if e, ok := c.times[d.Id]; ok && d.StartTime != e {
+					t.Errorf("Drive %d: expected start time %s, got %s", d.Id, e, d.StartTime)
+				}
+			}
+		}
+
+		totals, err := getTotals(c.year, c.month, 2, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var drives int
+		for _, ids := range c.days {
+			drives += len(ids)
+		}
+
+		// car 2 has one drive of 8 km in the fixtures:
+		expected := float32(drives)
+		if c.month == 3 {
+			expected += 7
+		}
+
+		if totals.TotalDistance != expected {
+			t.Errorf("%d-%02d: expected a total distance of %.0f, got %.0f", c.year, c.month, expected, totals.TotalDistance)
+		}
+	}
+
+	// the 31st of October has 25 hours; the affected days of a change cover all of it:
+	from, to, err := getAffectedDates(store, []int64{103, 105}, []int64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !from.Equal(localDate(2021, 10, 31)) || to.Sub(*from) != 25*time.Hour {
+		t.Errorf("Expected the affected range to be the 31st of October, got %v - %v", from, to)
+	}
+}
+
+func TestOtherTimeZone(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+	addNightDrives(s)
+
+	err := loadTimeZone("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 00:30 CEST on April 1st is 18:30 EDT on March 31st:
+	data := generateMain(2021, 3, 2, 0, defaultLocale)
+	if len(data.Days) != 4 || data.Days[0].Date.Day() != 31 || data.Days[0].Drives[0].StartTime != "18:30" {
+		t.Errorf("Expected the drive at 22:30 UTC to be filed on March 31st at 18:30, got %+v", data.Days)
+	}
+
+	if err := loadTimeZone("Local"); err == nil {
+		t.Errorf("Expected a time zone unknown to PostgreSQL to be refused")
+	}
+
+	if err := loadTimeZone("Europe/Nowhere"); err == nil {
+		t.Errorf("Expected an unknown time zone to be refused")
+	}
+}
+
+func TestClosedMonthInTimeZone(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+	addNightDrives(s)
+
+	// drive 102 starts on March 31st in UTC, but in April in Stockholm:
+	closeMonth(store, 2, 2021, 3, "test")
+
+	_, _, err := changeClassification(store, private, []int64{102}, []int64{}, "test")
+	if err != nil {
+		t.Errorf("Expected a drive of an open month to be changed, got %v", err)
+	}
+
+	_, _, err = changeClassification(store, private, []int64{101}, []int64{}, "test")
+	if !errors.Is(err, errMonthClosed) {
+		t.Errorf("Expected errMonthClosed, got %v", err)
+	}
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -15,18 +18,44 @@ import (
 	"gopkg.in/gcfg.v1"
 )
 
-var mainTemplate *template.Template = template.Must(template.ParseFiles("main.html"))
-var detailsTemplate *template.Template = template.Must(template.ParseFiles("details.html"))
-
-func main() {
+// the pages, by language:
+var mainTemplate = parseTemplates("main.html")
+var detailsTemplate = parseTemplates("details.html")
+var annualTemplate = parseTemplates("annual.html")
+var yearTemplate = parseTemplates("year.html")
+var ratesTemplate = parseTemplates("rates.html")
+var loginTemplate = parseTemplates("login.html")
+var driversTemplate = parseTemplates("drivers.html")
+
+// defaultConfig returns sane default config values.
+func defaultConfig() Config {
 	var config Config
 
-	// sane default config values:
 	config.Connection.Host = "localhost"
 	config.Connection.Port = 5432
 	config.Connection.User = "teslamate"
 	config.Connection.DB = "teslamate"
 	config.Service.Port = 4001
+	config.Service.TimeZone = "Europe/Stockholm"
+	config.Service.Locale = defaultLocale
+
+	// suits Excel with Swedish settings:
+	config.Export.Delimiter = ";"
+	config.Export.DecimalSeparator = ","
+	config.Export.Columns = strings.Join(exportColumns, ",")
+	config.Export.Grouped = true
+
+	// the tax free allowance set by Skatteverket for a private car, 25 kr/mil:
+	config.Report.RatePerKm = 2.5
+
+	config.Auth.Enabled = true
+	config.Auth.SessionHours = 7 * 24
+
+	return config
+}
+
+func main() {
+	config := defaultConfig()
 
 	err := gcfg.ReadFileInto(&config, "tesla_journal.cfg")
 	if err != nil {
@@ -34,6 +63,29 @@ func main() {
 		os.Exit(1)
 	}
 
+	err = loadTimeZone(config.Service.TimeZone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load time zone %q: %v\n", config.Service.TimeZone, err)
+		os.Exit(1)
+	}
+
+	if !isLocale(config.Service.Locale) {
+		fmt.Fprintf(os.Stderr, "Unknown locale %q; there are %s\n", config.Service.Locale, strings.Join(locales(), ", "))
+		os.Exit(1)
+	}
+
+	if config.Service.Units != "" && !isUnit(config.Service.Units) {
+		fmt.Fprintf(os.Stderr, "Unknown unit %q; there are %s and %s\n", config.Service.Units, unitKm, unitMi)
+		os.Exit(1)
+	}
+
+	for car, c := range config.Car {
+		if c.Units != "" && !isUnit(c.Units) {
+			fmt.Fprintf(os.Stderr, "Unknown unit %q of car %s; there are %s and %s\n", c.Units, car, unitKm, unitMi)
+			os.Exit(1)
+		}
+	}
+
 	err = connectDB(config)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
@@ -41,19 +93,78 @@ func main() {
 	}
 	defer database.Close()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = runMigrateCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	err = migrateUp()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to migrate database schema: %v\n", err)
+		os.Exit(1)
+	}
+
+	store = postgresStore{}
+
+	if len(os.Args) > 1 && os.Args[1] == "user" {
+		err = runUserCommand(os.Args[2:], os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "User command failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		err = runTokenCommand(os.Args[2:], os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Token command failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		err = runVerifyCommand()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Verification failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "applyrules" {
+		err = runRulesCommand(os.Args[2:], os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Applying rules failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		err = runExportCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	// start serving requests:
 	port := strconv.Itoa(config.Service.Port)
 
-	r := mux.NewRouter()
-
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
-	r.HandleFunc("/", serveGet).Methods(http.MethodGet)
-	r.HandleFunc("/", servePost).Methods(http.MethodPost)
-	r.HandleFunc("/details/{id}", serveDriveDetails).Methods(http.MethodGet)
-	r.HandleFunc("/drive/{id}", getDriveDetails).Methods(http.MethodGet)
-	r.HandleFunc("/drive/group/{id}", getGroupDriveDetails).Methods(http.MethodGet)
-	r.HandleFunc("/groupdetails/{id}", serveGroupedDriveDetails).Methods(http.MethodGet)
-	r.HandleFunc("/action", postAction).Methods(http.MethodPost)
+	r := newRouter()
 
 	secure := config.Service.CertFile != "" && config.Service.KeyFile != ""
 	if secure {
@@ -73,6 +184,64 @@ func main() {
 	log.Fatal(err)
 }
 
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
+	r.HandleFunc("/", serveGet).Methods(http.MethodGet)
+	r.HandleFunc("/", servePost).Methods(http.MethodPost)
+	r.HandleFunc("/details/{id}", serveDriveDetails).Methods(http.MethodGet)
+	r.HandleFunc("/drive/{id}", getDriveDetails).Methods(http.MethodGet)
+	r.HandleFunc("/drive/group/{id}", getGroupDriveDetails).Methods(http.MethodGet)
+	r.HandleFunc("/groupdetails/{id}", serveGroupedDriveDetails).Methods(http.MethodGet)
+	r.HandleFunc("/action", postAction).Methods(http.MethodPost)
+	r.HandleFunc("/export", exportHandler).Methods(http.MethodGet)
+	r.HandleFunc("/report/{car}/{year}/{month}.pdf", reportHandler).Methods(http.MethodGet)
+	r.HandleFunc("/annual/{car}/{year}.pdf", annualPDFHandler).Methods(http.MethodGet)
+	r.HandleFunc("/annual/{car}/{year}.csv", annualCSVHandler).Methods(http.MethodGet)
+	r.HandleFunc("/annual/{car}/{year}", annualHandler).Methods(http.MethodGet)
+	r.HandleFunc("/year/{car}/{year}", yearHandler).Methods(http.MethodGet)
+	r.HandleFunc("/categories", getCategoriesHandler).Methods(http.MethodGet)
+	r.HandleFunc("/categories", postCategory).Methods(http.MethodPost)
+	r.HandleFunc("/categories/{id}", putCategory).Methods(http.MethodPut)
+	r.HandleFunc("/categories/{id}", removeCategory).Methods(http.MethodDelete)
+	r.HandleFunc("/rules", getRulesHandler).Methods(http.MethodGet)
+	r.HandleFunc("/rules", postRule).Methods(http.MethodPost)
+	r.HandleFunc("/rules/{id}", putRule).Methods(http.MethodPut)
+	r.HandleFunc("/rules/{id}", removeRule).Methods(http.MethodDelete)
+	r.HandleFunc("/rates", getRatesHandler).Methods(http.MethodGet)
+	r.HandleFunc("/rates", postRate).Methods(http.MethodPost)
+	r.HandleFunc("/rates/{id}", putRate).Methods(http.MethodPut)
+	r.HandleFunc("/rates/{id}", removeRate).Methods(http.MethodDelete)
+	r.HandleFunc("/settings/rates", serveRates).Methods(http.MethodGet)
+	r.HandleFunc("/drivers/{car}", getDriversHandler).Methods(http.MethodGet)
+	r.HandleFunc("/drivers/{car}", postDriver).Methods(http.MethodPost)
+	r.HandleFunc("/drivers/{car}/{id}", putDriver).Methods(http.MethodPut)
+	r.HandleFunc("/drivers/{car}/{id}", removeDriver).Methods(http.MethodDelete)
+	r.HandleFunc("/settings/drivers/{car}", serveDrivers).Methods(http.MethodGet)
+	r.HandleFunc("/closedmonths/{car}", getClosedMonths).Methods(http.MethodGet)
+	r.HandleFunc("/history/drive/{id}", getDriveHistory).Methods(http.MethodGet)
+	r.HandleFunc("/history/group/{id}", getGroupHistory).Methods(http.MethodGet)
+	r.HandleFunc("/api/openapi.json", serveOpenAPI).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/cars", apiGetCars).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/drives", apiGetDrives).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/drives/{id}", apiGetDrive).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/drives/{id}", apiPatchDrive).Methods(http.MethodPatch)
+	r.HandleFunc("/api/v1/groups", apiPostGroup).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/groups/{id}", apiDeleteGroup).Methods(http.MethodDelete)
+	r.HandleFunc("/api/v1/totals", apiGetTotals).Methods(http.MethodGet)
+
+	if config.Auth.Enabled {
+		r.HandleFunc("/login", serveLogin).Methods(http.MethodGet)
+		r.HandleFunc("/login", postLogin).Methods(http.MethodPost)
+		r.HandleFunc("/logout", postLogout).Methods(http.MethodPost)
+		r.HandleFunc("/locale", postLocale).Methods(http.MethodPost)
+		r.Use(authenticate, authorize)
+	}
+
+	return r
+}
+
 func getIntParamPost(r *http.Request, param string, into *int) error {
 	val, err := strconv.Atoi(r.Form.Get(param))
 	if err != nil {
@@ -83,23 +252,123 @@ func getIntParamPost(r *http.Request, param string, into *int) error {
 	return nil
 }
 
-func serveGet(w http.ResponseWriter, r *http.Request) {
-	year := int(time.Now().Year())
-	month := int(time.Now().Month())
-	car := 1
+// getIdsParamPost parses all values of a form parameter as database ids. Any value that
+// isn't a positive integer makes the whole parameter invalid.
+func getIdsParamPost(r *http.Request, param string) ([]int64, error) {
+	var ids []int64
+
+	for _, s := range r.Form[param] {
+		id, err := parseId(s)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func parseId(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || id < 1 {
+		return 0, errors.New("Invalid id: " + strconv.Quote(s))
+	}
+
+	return id, nil
+}
+
+// getIdVar returns the {id} variable of the request path as a database id.
+func getIdVar(r *http.Request) (int, error) {
+	id, err := parseId(mux.Vars(r)["id"])
+
+	return int(id), err
+}
+
+// carYear is what the reports of a year, or of a month of it, are about.
+type carYear struct {
+	Car  Car
+	Year int
+	// the zero Driver for the drives of all drivers:
+	Driver Driver
+}
 
-	data := generateMain(year, month, car)
+// carYearRequest parses the {car} and {year} variables of the request path and the driver
+// parameter of its query. The returned status tells how a failure should be reported.
+func carYearRequest(r *http.Request) (carYear, int, error) {
+	vars := mux.Vars(r)
 
-	err := mainTemplate.Execute(w, data)
+	car, err := parseId(vars["car"])
 	if err != nil {
-		log.Fatal("Error while executing template: " + err.Error())
+		return carYear{}, http.StatusBadRequest, err
+	}
+
+	year, err := strconv.Atoi(vars["year"])
+	if err != nil || year < 1 {
+		return carYear{}, http.StatusBadRequest, errors.New("Invalid year: " + strconv.Quote(vars["year"]))
+	}
+
+	cars, err := getCars()
+	if err != nil {
+		log.Println("Error retrieving cars from database: " + err.Error())
+		return carYear{}, http.StatusInternalServerError, errors.New("Error retrieving cars")
+	}
+
+	var found *Car
+	for i := range cars {
+		if cars[i].Id == int(car) {
+			found = &cars[i]
+		}
+	}
+
+	if found == nil {
+		return carYear{}, http.StatusNotFound, errors.New("Unknown car: " + vars["car"])
+	}
+
+	driver, err := getDriverParam(r.URL.Query(), int(car))
+	if errors.Is(err, errUnknownDriver) {
+		return carYear{}, http.StatusBadRequest, err
+	} else if err != nil {
+		log.Println("Error retrieving drivers from database: " + err.Error())
+		return carYear{}, http.StatusInternalServerError, errors.New("Error retrieving drivers")
+	}
+
+	return carYear{Car: *found, Year: year, Driver: driver}, http.StatusOK, nil
+}
+
+func getDateParamPost(r *http.Request, param string, into *time.Time) error {
+	val, err := parseDate(r.Form.Get(param))
+	if err != nil {
+		return err
+	}
+
+	*into = val
+	return nil
+}
+
+func serveGet(w http.ResponseWriter, r *http.Request) {
+	now := convertTime(time.Now())
+	year := now.Year()
+	month := int(now.Month())
+	car := homeCar(currentAccess(r))
+
+	// a month may be linked to, like the year overview does:
+	var driver int
+	if r.ParseForm() == nil {
+		getIntParamPost(r, "year", &year)
+		getIntParamPost(r, "month", &month)
+		getIntParamPost(r, "car", &car)
+		getIntParamPost(r, "driver", &driver)
 	}
+
+	serveMain(w, r, year, month, car, driver)
 }
 
 func servePost(w http.ResponseWriter, r *http.Request) {
-	year := int(time.Now().Year())
-	month := int(time.Now().Month())
-	car := 1
+	now := convertTime(time.Now())
+	year := now.Year()
+	month := int(now.Month())
+	car := homeCar(currentAccess(r))
 
 	err := r.ParseForm()
 	if err != nil {
@@ -110,64 +379,109 @@ func servePost(w http.ResponseWriter, r *http.Request) {
 	getIntParamPost(r, "month", &month)
 	getIntParamPost(r, "car", &car)
 
-	data := generateMain(year, month, car)
+	// the driver only applies to the car they were chosen for:
+	var driver int
+	if r.Form.Get("previouscar") == "" || r.Form.Get("previouscar") == strconv.Itoa(car) {
+		getIntParamPost(r, "driver", &driver)
+	}
+
+	serveMain(w, r, year, month, car, driver)
+}
+
+// serveMain serves the journal of the month, with the cars and buttons the user has access to.
+func serveMain(w http.ResponseWriter, r *http.Request, year int, month int, car int, driver int) {
+	a := currentAccess(r)
+	lang := requestLocale(r)
+
+	data := generateMain(year, month, car, driver, lang)
+	session, _ := currentSession(r)
+	data.User, data.UserLocale = session.Username, session.Locale
+	data.DropdownCars = a.visibleCars(data.DropdownCars)
+	data.CanEdit, data.CanManage = a.canEdit(), a.canManage()
 
-	err = mainTemplate.Execute(w, data)
+	err := mainTemplate[lang].Execute(w, data)
 	if err != nil {
 		log.Fatal("Error while executing template: " + err.Error())
 	}
 }
 
 func getDriveDetails(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-
-	var ids []string
-	ids = append(ids, vars["id"])
-
-	positions, err := getPositions(ids)
+	id, err := getIdVar(r)
 	if err != nil {
-		log.Println("Error while getting positions")
+		writeError(w, r, http.StatusBadRequest, "invalid_id", err)
+		return
 	}
 
-	var coordinates [][]float64
-	for _, pos := range positions {
-		var c []float64
-		c = append(c, pos.Longitude)
-		c = append(c, pos.Latitude)
-		coordinates = append(coordinates, c)
+	var response GetDriveResponse
+	response.Drive, response.Comment, err = getDriveById(id, requestLocale(r))
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "drive_not_found", fmt.Errorf("No drive %d", id))
+		return
+	} else if err != nil {
+		log.Println("Error getting drive details: " + err.Error())
+		writeError(w, r, http.StatusInternalServerError, "drive_not_retrieved", err)
+		return
 	}
 
-	featureCollection := geojson.NewFeatureCollection()
-	feature := geojson.NewLineStringFeature(coordinates)
-	featureCollection.AddFeature(feature)
-
-	var response GetDriveResponse
-	response.MapData = *featureCollection
-	response.Drive, response.Comment, err = getDriveById(vars["id"])
+	positions, err := getPositions([]int64{int64(id)})
 	if err != nil {
-		log.Println("Error getting drive details: " + err.Error())
+		log.Println("Error while getting positions: " + err.Error())
+		writeError(w, r, http.StatusInternalServerError, "drive_not_retrieved", err)
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	response.MapData = *lineStringFeatures(positions)
+	response.Unit = carUnit(response.Drive.CarId)
+	convertDrive(&response.Drive, response.Unit)
+
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
 func getGroupDriveDetails(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
+	id, err := getIdVar(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", err)
+		return
+	}
 
-	var ids []string
-	ids = append(ids, vars["id"])
-	driveIds, err := getDriveIdsForGroups(ids)
+	var response GetGroupedDrivesResponse
+	response.Drives, err = getGroupedDrivesById(id, requestLocale(r))
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "drive_not_found", fmt.Errorf("No grouped drive %d", id))
+		return
+	} else if err != nil {
+		log.Println("Error getting drive details: " + err.Error())
+		writeError(w, r, http.StatusInternalServerError, "drive_not_retrieved", err)
+		return
+	}
+
+	driveIds, err := getDriveIdsForGroups([]int64{int64(id)})
 	if err != nil {
-		log.Println("Error while getting drive ids for grouped drive")
+		log.Println("Error while getting drive ids for grouped drive: " + err.Error())
+		writeError(w, r, http.StatusInternalServerError, "drive_not_retrieved", err)
+		return
 	}
 
 	positions, err := getPositions(driveIds)
 	if err != nil {
-		log.Println("Error while getting positions")
+		log.Println("Error while getting positions: " + err.Error())
+		writeError(w, r, http.StatusInternalServerError, "drive_not_retrieved", err)
+		return
 	}
 
+	response.MapData = *lineStringFeatures(positions)
+	response.Unit = carUnit(response.Drives.CarId)
+	convertGroupedDrives(&response.Drives, response.Unit)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// lineStringFeatures returns the positions as the line of a map.
+func lineStringFeatures(positions []Position) *geojson.FeatureCollection {
 	var coordinates [][]float64
 	for _, pos := range positions {
 		var c []float64
@@ -180,108 +494,373 @@ func getGroupDriveDetails(w http.ResponseWriter, r *http.Request) {
 	feature := geojson.NewLineStringFeature(coordinates)
 	featureCollection.AddFeature(feature)
 
-	var response GetGroupedDrivesResponse
-	response.MapData = *featureCollection
-	response.Drives, err = getGroupedDrivesById(vars["id"])
-	if err != nil {
-		log.Println("Error getting drive details: " + err.Error())
+	return featureCollection
+}
+
+type DetailsData struct {
+	Id    int
+	Group bool
+	// whether the user may not comment the drive, see roles.go:
+	ReadOnly bool
+}
+
+func getDetailsData(r *http.Request, id int, group bool) (DetailsData, error) {
+	data := DetailsData{Id: id, Group: group}
+
+	var driverId sql.NullInt32
+	if group {
+		gd, err := store.GetGroupedDrivesById(id)
+		if err != nil {
+			return data, err
+		}
+		driverId = gd.DriverId
+	} else {
+		d, err := store.GetDriveById(id)
+		if err != nil {
+			return data, err
+		}
+		driverId = d.DriverId
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	data.ReadOnly = !currentAccess(r).isOwnDrive(driverId)
+	return data, nil
 }
 
 func serveDriveDetails(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
+	id, err := getIdVar(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	var data struct {
-		Id    string
-		Group bool
+	data, err := getDetailsData(r, id, false)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Println("Error retrieving drive: " + err.Error())
+		http.Error(w, "Error retrieving drive", http.StatusInternalServerError)
+		return
 	}
-	data.Id = vars["id"]
-	data.Group = false
 
-	err := detailsTemplate.Execute(w, data)
+	err = detailsTemplate[requestLocale(r)].Execute(w, data)
 	if err != nil {
 		log.Fatal("Error while executing template: " + err.Error())
 	}
 }
 
 func serveGroupedDriveDetails(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
+	id, err := getIdVar(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	var data struct {
-		Id    string
-		Group bool
+	data, err := getDetailsData(r, id, true)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Println("Error retrieving drive: " + err.Error())
+		http.Error(w, "Error retrieving drive", http.StatusInternalServerError)
+		return
 	}
-	data.Id = vars["id"]
-	data.Group = true
 
-	err := detailsTemplate.Execute(w, data)
+	err = detailsTemplate[requestLocale(r)].Execute(w, data)
 	if err != nil {
 		log.Fatal("Error while executing template: " + err.Error())
 	}
 }
 
+// the keys of the messages shown to the user when an action fails; an action missing here is
+// unknown:
+var actionFailures = map[string]string{
+	"classify":          "classify_failed",
+	"comment":           "comment_failed",
+	"applyrules":        "applyrules_failed",
+	"acceptsuggestions": "acceptsuggestions_failed",
+	"group":             "group_failed",
+	"ungroup":           "ungroup_failed",
+	"closemonth":        "closemonth_failed",
+	"reopenmonth":       "reopenmonth_failed",
+	"assign":            "assign_failed",
+}
+
+// the actions concerning the car as a whole rather than the drives given, which have to name
+// the car instead of falling back on the home car of the user:
+var carActions = map[string]bool{
+	"applyrules":        true,
+	"acceptsuggestions": true,
+	"group":             true,
+	"ungroup":           true,
+	"closemonth":        true,
+	"reopenmonth":       true,
+	"assign":            true,
+}
+
 func postAction(w http.ResponseWriter, r *http.Request) {
-	year := int(time.Now().Year())
-	month := int(time.Now().Month())
-	car := 1
+	now := convertTime(time.Now())
+	year := now.Year()
+	month := int(now.Month())
+	car := homeCar(currentAccess(r))
 
 	err := r.ParseForm()
 	if err != nil {
-		panic(err)
+		writeError(w, r, http.StatusBadRequest, "bad_request", err)
+		return
 	}
 
 	getIntParamPost(r, "year", &year)
 	getIntParamPost(r, "month", &month)
 	getIntParamPost(r, "car", &car)
 
-	var from, to *time.Time
+	// the driver whose journal is shown, which the affected days and totals are limited to:
+	var driver int
+	getIntParamPost(r, "driver", &driver)
+
+	drives, err := getIdsParamPost(r, "drive")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", err)
+		return
+	}
+
+	groupedDrives, err := getIdsParamPost(r, "groupeddrive")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", err)
+		return
+	}
 
 	action := r.Form.Get("action")
+	failure, known := actionFailures[action]
+	if !known {
+		writeError(w, r, http.StatusBadRequest, "unknown_action", errors.New("Unknown action: "+strconv.Quote(action)))
+		return
+	}
+
+	if carActions[action] && r.Form.Get("car") == "" {
+		writeError(w, r, http.StatusBadRequest, "car_missing", errors.New("No car given for action "+strconv.Quote(action)))
+		return
+	}
+
+	var classification int
 	if action == "classify" {
-		from, to, err = changeClassification(getClassificationId(r.Form.Get("classification")), r.Form["drive"], r.Form["groupeddrive"])
+		getIntParamPost(r, "classification", &classification)
+
+		categories, err := getCategoryMap()
 		if err != nil {
-			log.Println("Error changing drive classification")
+			writeError(w, r, http.StatusInternalServerError, failure, err)
+			return
 		}
-	} else if action == "group" {
-		from, to, err = groupDrives(car, r.Form["drive"])
-		if err != nil {
-			log.Println("Error grouping drives")
+
+		if _, exists := categories[classification]; !exists {
+			writeError(w, r, http.StatusBadRequest, "invalid_classification", errors.New("Invalid classification: "+strconv.Quote(r.Form.Get("classification"))))
+			return
 		}
-	} else if action == "ungroup" {
-		from, to, err = ungroupDrives(car, r.Form["groupeddrive"])
-		if err != nil {
-			log.Println("Error ungrouping drives")
+	}
+
+	var assignee int
+	if action == "assign" {
+		getIntParamPost(r, "assignee", &assignee)
+
+		if assignee != 0 {
+			exists, err := isDriverOf(car, assignee)
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, failure, err)
+				return
+			}
+
+			if !exists {
+				writeError(w, r, http.StatusBadRequest, "invalid_driver", errors.New("Invalid driver: "+strconv.Quote(r.Form.Get("assignee"))))
+				return
+			}
+		}
+	}
+
+	err = checkSelection(action, drives, groupedDrives)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "select_drives", err)
+		return
+	}
+
+	err = checkAction(currentAccess(r), action, drives, groupedDrives)
+	if errors.Is(err, errNotOwnDrive) {
+		writeError(w, r, http.StatusForbidden, "not_own_drive", err)
+		return
+	} else if errors.Is(err, errNotAllowed) {
+		writeError(w, r, http.StatusForbidden, "not_allowed", err)
+		return
+	} else if err != nil {
+		log.Println("Error checking the drives of the action: " + err.Error())
+		writeError(w, r, http.StatusInternalServerError, failure, err)
+		return
+	}
+
+	if action == "reopenmonth" && strings.TrimSpace(r.Form.Get("reason")) == "" {
+		writeError(w, r, http.StatusBadRequest, "reopen_reason_missing", errors.New("No reason given for reopening the month"))
+		return
+	}
+
+	var from, to *time.Time
+
+	// all changes made by the action are undone if any part of it fails:
+	err = store.Transaction(func(tx JournalStore) error {
+		var err error
+
+		if action == "classify" {
+			from, to, err = changeClassification(tx, classification, drives, groupedDrives, actingUser(r))
+		} else if action == "assign" {
+			from, to, err = assignDriver(tx, assignee, drives, groupedDrives, actingUser(r))
+		} else if action == "comment" {
+			from, to, err = changeComment(tx, r.Form.Get("comment"), drives, groupedDrives, actingUser(r))
+		} else if action == "applyrules" {
+			// defaults to the selected month; a given end date is inclusive:
+			rangeFrom := localDate(year, month, 1)
+			rangeTo := rangeFrom.AddDate(0, 1, 0)
+			getDateParamPost(r, "from", &rangeFrom)
+			if getDateParamPost(r, "to", &rangeTo) == nil {
+				rangeTo = rangeTo.AddDate(0, 0, 1)
+			}
+
+			from, to, err = reapplyRules(tx, car, rangeFrom, rangeTo, actingUser(r))
+		} else if action == "acceptsuggestions" {
+			monthFrom := localDate(year, month, 1)
+			from, to, err = acceptSuggestions(tx, car, monthFrom, monthFrom.AddDate(0, 1, 0), actingUser(r))
+		} else if action == "group" {
+			from, to, err = groupDrives(tx, car, drives, actingUser(r))
+		} else if action == "ungroup" {
+			from, to, err = ungroupDrives(tx, car, groupedDrives, r.Form.Get("copycomment") == "true", actingUser(r))
+		} else if action == "closemonth" {
+			from, to, err = closeMonth(tx, car, year, month, actingUser(r))
+		} else if action == "reopenmonth" {
+			from, to, err = reopenMonth(tx, car, year, month, actingUser(r), r.Form.Get("reason"))
 		}
+
+		return err
+	})
+	if errors.Is(err, errMonthClosed) {
+		writeError(w, r, http.StatusConflict, "month_closed_error", err)
+		return
+	} else if errors.Is(err, errInvalidGroup) {
+		writeError(w, r, http.StatusBadRequest, "group_invalid", err)
+		return
+	} else if action == "reopenmonth" && err == sql.ErrNoRows {
+		writeError(w, r, http.StatusConflict, "month_not_closed", err)
+		return
+	} else if err != nil {
+		log.Println("Error performing action " + action + ": " + err.Error())
+		writeError(w, r, http.StatusInternalServerError, failure, err)
+		return
 	}
 
 	var affectedDays []Day
 	if from != nil && to != nil {
-		affectedDays, err = getDays(*from, *to, car)
+		affectedDays, err = getDays(*from, *to, car, driver, requestLocale(r))
 		if err != nil {
-			log.Println("Error retrieving affected days")
+			log.Println("Error retrieving affected days: " + err.Error())
+			writeError(w, r, http.StatusInternalServerError, "drives_not_retrieved", err)
+			return
 		}
 	} else {
 		log.Println("The action did not return a useful date range")
 	}
 
-	totals, err := getTotals(year, month, car)
+	totals, err := getTotals(year, month, car, driver)
 	if err != nil {
-		log.Println("Error retrieving totals")
+		log.Println("Error retrieving totals: " + err.Error())
+		writeError(w, r, http.StatusInternalServerError, "totals_not_retrieved", err)
+		return
 	}
 
+	convertTotals(&totals, carUnit(car))
+
 	var response PostResponse
 	response.Totals = totals
 	response.AffectedDays = affectedDays
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// checkSelection tells whether the drives and grouped drives selected are enough for the action.
+func checkSelection(action string, drives []int64, groupedDrives []int64) error {
+	switch action {
+	case "classify", "comment", "assign":
+		if len(drives)+len(groupedDrives) == 0 {
+			return errors.New("No drive ids or grouped drive ids specified")
+		}
+	case "group":
+		if len(drives) == 0 {
+			return errors.New("No drive ids specified")
+		}
+	case "ungroup":
+		if len(groupedDrives) == 0 {
+			return errors.New("No grouped drive ids specified")
+		}
+	}
+
+	return nil
+}
+
+// actingUser tells who made a request, for logging changes. Without logging in, the address
+// of the client is used.
+func actingUser(r *http.Request) string {
+	if user := currentUser(r); user != "" {
+		return user
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// ClosedMonthsResponse tells which months of a car are closed and when they were closed and reopened.
+type ClosedMonthsResponse struct {
+	Closed []time.Time
+	Log    []ClosedMonthEvent
+}
+
+func getClosedMonths(w http.ResponseWriter, r *http.Request) {
+	car, err := parseId(mux.Vars(r)["car"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var response ClosedMonthsResponse
+
+	response.Closed, err = store.GetClosedMonths(int(car))
+	if err == nil {
+		response.Log, err = store.GetClosedMonthLog(int(car))
+	}
+	if err != nil {
+		log.Println("Error retrieving closed months: " + err.Error())
+		http.Error(w, "Error retrieving closed months", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
+// ErrorResponse is returned by actions that fail. Message is meant for the user,
+// Error tells what went wrong.
+type ErrorResponse struct {
+	Message string
+	Error   string
+}
+
+// writeError answers with the message of the key in the language of the request.
+func writeError(w http.ResponseWriter, r *http.Request, status int, key string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Message: translate(requestLocale(r), key), Error: err.Error()})
+}
+
 type PostResponse struct {
 	Totals       Totals
 	AffectedDays []Day
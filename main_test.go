@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var hostileIds = []string{
+	"",
+	"0",
+	"-1",
+	"1 OR 1=1",
+	"1; DROP TABLE tj_classifications; --",
+	"1) OR (1=1",
+	"1}') OR ('1'='1",
+	"1,2",
+	"'",
+	" 1",
+	"1e3",
+	"0x10",
+	"99999999999",
+}
+
+func TestParseIdAcceptsIntegers(t *testing.T) {
+	for _, s := range []string{"1", "42", "2147483647"} {
+		_, err := parseId(s)
+		if err != nil {
+			t.Errorf("parseId(%q) failed: %v", s, err)
+		}
+	}
+}
+
+func TestParseIdRejectsHostileInput(t *testing.T) {
+	for _, s := range hostileIds {
+		_, err := parseId(s)
+		if err == nil {
+			t.Errorf("parseId(%q) accepted hostile input", s)
+		}
+	}
+}
+
+func TestGetIdsParamPostRejectsHostileInput(t *testing.T) {
+	for _, s := range hostileIds {
+		r := httptest.NewRequest(http.MethodPost, "/action", strings.NewReader(url.Values{"drive": {"1", s}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ParseForm()
+
+		ids, err := getIdsParamPost(r, "drive")
+		if err == nil {
+			t.Errorf("getIdsParamPost accepted hostile input %q, got %v", s, ids)
+		}
+	}
+}
+
+// The handlers must reject hostile ids before they reach the database; no database
+// is connected during the tests, so any query would make the handler panic.
+func TestHandlersRejectHostileIds(t *testing.T) {
+	router := newRouter()
+
+	for _, path := range []string{"/drive/", "/drive/group/", "/details/", "/groupdetails/"} {
+		for _, s := range hostileIds[1:] {
+			r := httptest.NewRequest(http.MethodGet, path+url.PathEscape(s), nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusBadRequest && w.Code != http.StatusNotFound {
+				t.Errorf("GET %s%q: expected status 400, got %d", path, s, w.Code)
+			}
+		}
+	}
+}
+
+func TestPostActionRejectsHostileIds(t *testing.T) {
+	router := newRouter()
+
+	for _, param := range []string{"drive", "groupeddrive"} {
+		for _, s := range hostileIds {
+			form := url.Values{"action": {"classify"}, "classification": {"1"}, param: {s}}
+			r := httptest.NewRequest(http.MethodPost, "/action", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("POST /action with %s=%q: expected status 400, got %d", param, s, w.Code)
+			}
+		}
+	}
+}
+
+func TestGetDriveDetails(t *testing.T) {
+	useFixtures(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/drive/2", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response GetDriveResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Drive.Id != 2 || response.Comment != "Möte med kund" || response.Drive.ClassificationString != "Tjänsteresa" {
+		t.Errorf("Unexpected drive %+v", response)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/drive/2", nil)
+	r.Header.Set("Accept-Language", "en")
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	var english GetDriveResponse
+	json.NewDecoder(w.Body).Decode(&english)
+	if english.Drive.ClassificationString != "Business trip" {
+		t.Errorf("Expected the classification in English, got %q", english.Drive.ClassificationString)
+	}
+
+	if len(response.MapData.Features) != 1 || len(response.MapData.Features[0].Geometry.LineString) != 3 {
+		t.Errorf("Expected a line of three positions, got %+v", response.MapData)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON, got %q", ct)
+	}
+
+	for _, path := range []string{"/drive/99", "/drive/group/99"} {
+		w = httptest.NewRecorder()
+		newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		var e ErrorResponse
+		if w.Code != http.StatusNotFound || json.NewDecoder(w.Body).Decode(&e) != nil || e.Message != "Resan finns inte" {
+			t.Errorf("Expected %s to be not found, got %d %+v", path, w.Code, e)
+		}
+	}
+}
+
+func TestGetGroupDriveDetails(t *testing.T) {
+	s := useFixtures(t)
+	groupDrives(store, 1, []int64{2, 3}, "test")
+
+	r := httptest.NewRequest(http.MethodGet, "/drive/group/"+strconv.Itoa(s.groups[0].Id), nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response GetGroupedDrivesResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Drives.DriveIds) != 2 || response.Drives.DurationString != "1:15" {
+		t.Errorf("Unexpected group %+v", response.Drives)
+	}
+
+	if len(response.MapData.Features[0].Geometry.LineString) != 5 {
+		t.Errorf("Expected the positions of both drives, got %+v", response.MapData)
+	}
+}
+
+func postForm(t *testing.T, path string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func TestPostActionClassify(t *testing.T) {
+	useFixtures(t)
+
+	form := url.Values{"action": {"classify"}, "classification": {"2"}, "drive": {"3"}, "year": {"2021"}, "month": {"3"}, "car": {"1"}}
+	w := postForm(t, "/action", form)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response PostResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Totals.TotalPrivateDistance != 25.5 {
+		t.Errorf("Expected the private distance to include drive 3, got %+v", response.Totals)
+	}
+
+	if len(response.AffectedDays) != 1 || response.AffectedDays[0].Date.Day() != 1 {
+		t.Fatalf("Expected the 1st to be affected, got %+v", response.AffectedDays)
+	}
+
+	for _, d := range response.AffectedDays[0].Drives {
+		if d.Id == 3 && d.ClassificationClass != "private" {
+			t.Errorf("Expected drive 3 to be private, got %q", d.ClassificationClass)
+		}
+	}
+}
+
+func TestPostActionGroup(t *testing.T) {
+	s := useFixtures(t)
+
+	form := url.Values{"action": {"group"}, "drive": {"4", "5"}, "year": {"2021"}, "month": {"3"}, "car": {"1"}}
+	w := postForm(t, "/action", form)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	if len(s.groups) != 1 || len(s.groups[0].DriveIds) != 2 {
+		t.Errorf("Expected drives 4 and 5 to be grouped, got %+v", s.groups)
+	}
+
+	var response PostResponse
+	json.NewDecoder(w.Body).Decode(&response)
+	if len(response.AffectedDays) != 1 || len(response.AffectedDays[0].GroupedDrives) != 1 {
+		t.Errorf("Expected the group in the affected days, got %+v", response.AffectedDays)
+	}
+}
+
+func TestServePostRendersMonth(t *testing.T) {
+	useFixtures(t)
+
+	w := postForm(t, "/", url.Values{"year": {"2021"}, "month": {"3"}, "car": {"1"}})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "Möte med kund") || !strings.Contains(body, "Industrivägen 5, Uppsala") {
+		t.Error("Expected the drives of March 2021 in the page")
+	}
+
+	if strings.Contains(body, "Skolan") {
+		t.Error("Expected no drives of the other car in the page")
+	}
+}
+
+func TestPostActionReturnsStructuredErrors(t *testing.T) {
+	s := useFixtures(t)
+
+	tests := []struct {
+		form   url.Values
+		status int
+	}{
+		{url.Values{"action": {"classify"}, "classification": {"1"}}, http.StatusBadRequest},
+		{url.Values{"action": {"classify"}, "classification": {"99"}, "drive": {"3"}}, http.StatusBadRequest},
+		{url.Values{"action": {"explode"}, "drive": {"3"}}, http.StatusBadRequest},
+		{url.Values{"action": {"ungroup"}, "drive": {"3"}}, http.StatusBadRequest},
+		// actions on the car as a whole need to name it:
+		{url.Values{"action": {"closemonth"}, "year": {"2021"}, "month": {"3"}}, http.StatusBadRequest},
+		{url.Values{"action": {"group"}, "drive": {"4", "5"}}, http.StatusBadRequest},
+		// drive 7 belongs to another car, and drive 9 doesn't exist:
+		{url.Values{"action": {"group"}, "drive": {"7"}, "car": {"1"}}, http.StatusBadRequest},
+		{url.Values{"action": {"group"}, "drive": {"2", "3", "7"}, "car": {"1"}}, http.StatusBadRequest},
+		{url.Values{"action": {"group"}, "drive": {"2", "9"}, "car": {"1"}}, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		w := postForm(t, "/action", test.form)
+
+		if w.Code != test.status {
+			t.Errorf("%v: expected status %d, got %d", test.form, test.status, w.Code)
+		}
+
+		var response ErrorResponse
+		err := json.NewDecoder(w.Body).Decode(&response)
+		if err != nil || response.Message == "" || response.Error == "" {
+			t.Errorf("%v: expected a structured error, got %q (%v)", test.form, w.Body.String(), err)
+		}
+	}
+
+	if len(s.groups) != 0 {
+		t.Errorf("Expected no groups, got %+v", s.groups)
+	}
+
+	if len(s.closedMonths[1]) != 0 {
+		t.Errorf("Expected no closed months, got %v", s.closedMonths)
+	}
+}
+
+func TestPostActionCloseAndReopenMonth(t *testing.T) {
+	useFixtures(t)
+
+	month := url.Values{"year": {"2021"}, "month": {"3"}, "car": {"1"}}
+	with := func(values url.Values) url.Values {
+		form := url.Values{}
+		for k, v := range month {
+			form[k] = v
+		}
+		for k, v := range values {
+			form[k] = v
+		}
+
+		return form
+	}
+
+	w := postForm(t, "/action", with(url.Values{"action": {"closemonth"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = postForm(t, "/action", with(url.Values{"action": {"classify"}, "classification": {"2"}, "drive": {"3"}}))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 when classifying in a closed month, got %d", w.Code)
+	}
+
+	var response ErrorResponse
+	json.NewDecoder(w.Body).Decode(&response)
+	if response.Message != "Månaden är stängd och kan inte ändras" {
+		t.Errorf("Unexpected message %q", response.Message)
+	}
+
+	if !generateMain(2021, 3, 1, 0, defaultLocale).Closed || generateMain(2021, 2, 1, 0, defaultLocale).Closed {
+		t.Error("Expected only March to be shown as closed")
+	}
+
+	w = postForm(t, "/action", with(url.Values{"action": {"reopenmonth"}}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 when reopening without a reason, got %d", w.Code)
+	}
+
+	w = postForm(t, "/action", with(url.Values{"action": {"reopenmonth"}, "reason": {"Rättelse"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = postForm(t, "/action", with(url.Values{"action": {"reopenmonth"}, "reason": {"Rättelse"}}))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 when reopening an open month, got %d", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/closedmonths/1", nil)
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, r)
+
+	var closed ClosedMonthsResponse
+	json.NewDecoder(rec.Body).Decode(&closed)
+	if len(closed.Closed) != 0 || len(closed.Log) != 2 || closed.Log[1].Reason != "Rättelse" {
+		t.Errorf("Unexpected closed months %+v", closed)
+	}
+}
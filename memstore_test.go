@@ -0,0 +1,1393 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// memoryStore is a JournalStore keeping everything in memory, mirroring the semantics
+// of the queries in postgresStore. It is seeded from the fixtures in testdata.
+type memoryStore struct {
+	mu sync.Mutex
+
+	cars            []Car
+	drives          []Drive
+	positions       map[int][]Position
+	classifications map[int]memoryClassification
+	comments        map[int]string
+	groups          []GroupedDrives
+	categories      []Category
+	rules           []Rule
+	rates           []Rate
+	closedMonths    map[int]map[time.Time]bool
+	closedMonthLog  []ClosedMonthEvent
+	auditLog        []AuditEntry
+	users           []User
+	sessions        map[string]Session
+	drivers         []Driver
+	assignments     map[int]int
+	apiTokens       []ApiToken
+	// the unit of length of TeslaMate's settings:
+	unitOfLength string
+
+	nextGroupId    int
+	nextCategoryId int
+	nextRuleId     int
+	nextRateId     int
+	nextUserId     int
+	nextDriverId   int
+	nextTokenId    int
+}
+
+type memoryClassification struct {
+	Classification int
+	RuleId         sql.NullInt32
+	Suggested      bool
+}
+
+type fixtures struct {
+	Cars       []Car
+	Categories []Category
+	Drives     []fixtureDrive
+}
+
+type fixtureDrive struct {
+	Id             int
+	Car            int
+	Start          time.Time
+	End            time.Time
+	Duration       int
+	Distance       float32
+	StartAddress   string
+	EndAddress     string
+	StartGeofence  int32
+	EndGeofence    int32
+	StartOdometer  int
+	EndOdometer    int
+	Classification int
+	Comment        string
+	Positions      [][2]float64
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		positions:       make(map[int][]Position),
+		classifications: make(map[int]memoryClassification),
+		comments:        make(map[int]string),
+		closedMonths:    make(map[int]map[time.Time]bool),
+		sessions:        make(map[string]Session),
+		assignments:     make(map[int]int),
+		nextGroupId:     1,
+		nextCategoryId:  1,
+		nextRuleId:      1,
+		nextRateId:      1,
+		nextUserId:      1,
+		nextDriverId:    1,
+		nextTokenId:     1,
+	}
+}
+
+func nullInt32(i int32) sql.NullInt32 {
+	return sql.NullInt32{Int32: i, Valid: i != 0}
+}
+
+// LoadFixtures adds the cars, categories and drives of a fixture file to the store.
+func (s *memoryStore) LoadFixtures(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var f fixtures
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cars = append(s.cars, f.Cars...)
+
+	for _, c := range f.Categories {
+		s.categories = append(s.categories, c)
+		if c.Id >= s.nextCategoryId {
+			s.nextCategoryId = c.Id + 1
+		}
+	}
+
+	for _, fd := range f.Drives {
+		s.drives = append(s.drives, Drive{
+			Id:              fd.Id,
+			CarId:           fd.Car,
+			StartDate:       fd.Start.UTC(),
+			EndDate:         fd.End.UTC(),
+			Duration:        fd.Duration,
+			Distance:        fd.Distance,
+			StartAddress:    fd.StartAddress,
+			EndAddress:      fd.EndAddress,
+			StartGeofenceId: nullInt32(fd.StartGeofence),
+			EndGeofenceId:   nullInt32(fd.EndGeofence),
+			StartOdometer:   fd.StartOdometer,
+			EndOdometer:     fd.EndOdometer,
+		})
+
+		if fd.Classification != 0 {
+			s.classifications[fd.Id] = memoryClassification{Classification: fd.Classification}
+		}
+
+		if fd.Comment != "" {
+			s.comments[fd.Id] = fd.Comment
+		}
+
+		for _, p := range fd.Positions {
+			s.positions[fd.Id] = append(s.positions[fd.Id], Position{Longitude: p[0], Latitude: p[1]})
+		}
+	}
+
+	return nil
+}
+
+// drive returns a stored drive with its classification, comment, driver and group filled in.
+func (s *memoryStore) drive(d Drive) Drive {
+	if c, ok := s.classifications[d.Id]; ok {
+		d.Classification = sql.NullInt32{Int32: int32(c.Classification), Valid: true}
+		d.RuleId = c.RuleId
+	}
+
+	if comment, ok := s.comments[d.Id]; ok {
+		d.Comment = sql.NullString{String: comment, Valid: true}
+	}
+
+	if driver, ok := s.assignments[d.Id]; ok {
+		d.DriverId = sql.NullInt32{Int32: int32(driver), Valid: true}
+	}
+
+	for _, g := range s.groups {
+		if g.CarId == d.CarId && containsId(g.DriveIds, int64(d.Id)) {
+			d.GroupId = sql.NullInt32{Int32: int32(g.Id), Valid: true}
+		}
+	}
+
+	return d
+}
+
+func (s *memoryStore) findDrive(id int) (Drive, bool) {
+	for _, d := range s.drives {
+		if d.Id == id {
+			return d, true
+		}
+	}
+
+	return Drive{}, false
+}
+
+// group returns a stored group with the odometer readings of its drives filled in, and
+// their driver if they all share one.
+func (s *memoryStore) group(g GroupedDrives) GroupedDrives {
+	first := true
+	for _, d := range s.drives {
+		if !containsId(g.DriveIds, int64(d.Id)) {
+			continue
+		}
+
+		driver, assigned := s.assignments[d.Id]
+		if first && assigned {
+			g.DriverId = sql.NullInt32{Int32: int32(driver), Valid: true}
+		} else if !assigned || int32(driver) != g.DriverId.Int32 {
+			g.DriverId = sql.NullInt32{}
+		}
+
+		if first || d.StartOdometer < g.StartOdometer {
+			g.StartOdometer = d.StartOdometer
+		}
+
+		if first || d.EndOdometer > g.EndOdometer {
+			g.EndOdometer = d.EndOdometer
+		}
+
+		first = false
+	}
+
+	return g
+}
+
+func containsId(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func inPeriod(t, from, to time.Time) bool {
+	return !t.Before(from) && t.Before(to)
+}
+
+func (s *memoryStore) GetCars() ([]Car, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Car{}, s.cars...), nil
+}
+
+func (s *memoryStore) GetUnitOfLength() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.unitOfLength, nil
+}
+
+func (s *memoryStore) GetFirstAndLastYears() (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.drives) == 0 {
+		return 0, 0, sql.ErrNoRows
+	}
+
+	first, last := convertTime(s.drives[0].StartDate).Year(), convertTime(s.drives[0].StartDate).Year()
+	for _, d := range s.drives {
+		if year := convertTime(d.StartDate).Year(); year < first {
+			first = year
+		}
+
+		if year := convertTime(d.StartDate).Year(); year > last {
+			last = year
+		}
+	}
+
+	return first, last, nil
+}
+
+func (s *memoryStore) GetDrives(carId int, from, to time.Time) ([]Drive, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var drives []Drive
+	for _, d := range s.drives {
+		if d.CarId == carId && inPeriod(d.StartDate, from, to) {
+			drives = append(drives, s.drive(d))
+		}
+	}
+
+	sort.SliceStable(drives, func(i, j int) bool { return drives[i].StartDate.After(drives[j].StartDate) })
+
+	return drives, nil
+}
+
+func (s *memoryStore) GetDrivesPage(carId, driverId int, from, to time.Time, classification, limit, offset int) ([]Drive, int, error) {
+	all, _ := s.GetDrives(carId, from, to)
+
+	var drives []Drive
+	total := 0
+	for _, d := range all {
+		if driverId != 0 && (!d.DriverId.Valid || int(d.DriverId.Int32) != driverId) {
+			continue
+		}
+
+		if (classification == noClassification && d.Classification.Valid) || (classification > 0 && (!d.Classification.Valid || int(d.Classification.Int32) != classification)) {
+			continue
+		}
+
+		if total >= offset && len(drives) < limit {
+			drives = append(drives, d)
+		}
+		total++
+	}
+
+	return drives, total, nil
+}
+
+func (s *memoryStore) GetDriveById(id int) (Drive, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.findDrive(id)
+	if !ok {
+		return Drive{}, sql.ErrNoRows
+	}
+
+	return s.drive(d), nil
+}
+
+func (s *memoryStore) GetPositions(driveIds []int64) ([]Position, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var drives []Drive
+	for _, d := range s.drives {
+		if containsId(driveIds, int64(d.Id)) {
+			drives = append(drives, d)
+		}
+	}
+
+	sort.SliceStable(drives, func(i, j int) bool { return drives[i].StartDate.Before(drives[j].StartDate) })
+
+	var positions []Position
+	for _, d := range drives {
+		positions = append(positions, s.positions[d.Id]...)
+	}
+
+	return positions, nil
+}
+
+func (s *memoryStore) GetTotals(carId, driverId int, from, to time.Time) (Totals, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var t Totals
+	for _, d := range s.drives {
+		if d.CarId != carId || !inPeriod(d.StartDate, from, to) {
+			continue
+		}
+
+		driver, assigned := s.assignments[d.Id]
+		if driverId != 0 && driver != driverId {
+			continue
+		}
+
+		t.TotalDuration += d.Duration
+		t.TotalDistance += d.Distance
+
+		if !assigned {
+			t.UnassignedDistance += d.Distance
+		}
+
+		c, classified := s.classifications[d.Id]
+		category, known := s.category(c.Classification)
+		if classified && known && category.Deductible {
+			t.TotalBusinessDuration += d.Duration
+			t.TotalBusinessDistance += d.Distance
+		} else if classified && known {
+			t.TotalPrivateDuration += d.Duration
+			t.TotalPrivateDistance += d.Distance
+		} else {
+			t.UnclassifiedDuration += d.Duration
+			t.UnclassifiedDistance += d.Distance
+		}
+	}
+
+	return t, nil
+}
+
+func (s *memoryStore) category(id int) (Category, bool) {
+	for _, c := range s.categories {
+		if c.Id == id {
+			return c, true
+		}
+	}
+
+	return Category{}, false
+}
+
+func (s *memoryStore) GetDateRange(driveIds []int64, groupIds []int64) (time.Time, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var dates []time.Time
+	for _, d := range s.drives {
+		if containsId(driveIds, int64(d.Id)) {
+			dates = append(dates, d.StartDate, d.EndDate)
+		}
+	}
+
+	for _, g := range s.groups {
+		if containsId(groupIds, int64(g.Id)) {
+			dates = append(dates, g.StartDate, g.EndDate)
+		}
+	}
+
+	if len(dates) == 0 {
+		return time.Time{}, time.Time{}, errors.New("Error retrieving first/last dates of range of drives")
+	}
+
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	return dates[0], dates[len(dates)-1], nil
+}
+
+func (s *memoryStore) GetGroupedDrives(carId int, from, to time.Time) ([]GroupedDrives, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var groups []GroupedDrives
+	for _, g := range s.groups {
+		if g.CarId == carId && inPeriod(g.StartDate, from, to) {
+			groups = append(groups, s.group(g))
+		}
+	}
+
+	return groups, nil
+}
+
+func (s *memoryStore) GetGroupedDrivesById(id int) (GroupedDrives, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, g := range s.groups {
+		if g.Id == id {
+			return s.group(g), nil
+		}
+	}
+
+	return GroupedDrives{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) GetDriveIdsForGroups(groupIds []int64) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.driveIdsForGroups(groupIds), nil
+}
+
+func (s *memoryStore) driveIdsForGroups(groupIds []int64) []int64 {
+	var ids []int64
+	for _, g := range s.groups {
+		if containsId(groupIds, int64(g.Id)) {
+			ids = append(ids, g.DriveIds...)
+		}
+	}
+
+	return ids
+}
+
+func (s *memoryStore) GroupDrives(carId int, driveIds []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var drives []Drive
+	for _, d := range s.drives {
+		if d.CarId == carId && containsId(driveIds, int64(d.Id)) {
+			drives = append(drives, s.drive(d))
+		}
+	}
+
+	if len(drives) == 0 {
+		return sql.ErrNoRows
+	}
+
+	sort.SliceStable(drives, func(i, j int) bool { return drives[i].StartDate.Before(drives[j].StartDate) })
+
+	g := GroupedDrives{
+		Id:           s.nextGroupId,
+		CarId:        carId,
+		DriveIds:     pq.Int64Array(append([]int64{}, driveIds...)),
+		StartDate:    drives[0].StartDate,
+		EndDate:      drives[0].EndDate,
+		StartAddress: drives[0].StartAddress,
+		EndAddress:   drives[len(drives)-1].EndAddress,
+	}
+	s.nextGroupId++
+
+	// the group only gets a classification if all of its drives share the same one:
+	classification := drives[0].Classification
+	for _, d := range drives {
+		if d.EndDate.After(g.EndDate) {
+			g.EndDate = d.EndDate
+		}
+
+		g.Duration += d.Duration
+		g.Distance += d.Distance
+
+		if d.Classification != classification {
+			classification = sql.NullInt32{}
+		}
+	}
+	g.Classification = classification
+
+	s.groups = append(s.groups, g)
+
+	return nil
+}
+
+func (s *memoryStore) UngroupDrives(groupIds []int64, copyComment bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var groups []GroupedDrives
+	for _, g := range s.groups {
+		if !containsId(groupIds, int64(g.Id)) {
+			groups = append(groups, g)
+			continue
+		}
+
+		if copyComment && g.Comment.Valid {
+			for _, id := range g.DriveIds {
+				s.comments[int(id)] = g.Comment.String
+			}
+		}
+	}
+	s.groups = groups
+
+	return nil
+}
+
+func (s *memoryStore) ChangeClassification(classification int, driveIds []int64, groupIds []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := append(append([]int64{}, driveIds...), s.driveIdsForGroups(groupIds)...)
+	for _, id := range ids {
+		s.classifications[int(id)] = memoryClassification{Classification: classification}
+	}
+
+	for i := range s.groups {
+		if containsId(groupIds, int64(s.groups[i].Id)) {
+			s.groups[i].Classification = sql.NullInt32{Int32: int32(classification), Valid: true}
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) ChangeComment(comment string, driveIds []int64, groupIds []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range driveIds {
+		if comment == "" {
+			delete(s.comments, int(id))
+		} else {
+			s.comments[int(id)] = comment
+		}
+	}
+
+	for i := range s.groups {
+		if containsId(groupIds, int64(s.groups[i].Id)) {
+			s.groups[i].Comment = sql.NullString{String: comment, Valid: comment != ""}
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) ClassifyByRule(driveId int, rule Rule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.classifications[driveId] = memoryClassification{Classification: rule.Classification, RuleId: sql.NullInt32{Int32: int32(rule.Id), Valid: true}}
+
+	if _, exists := s.comments[driveId]; rule.Comment != "" && !exists {
+		s.comments[driveId] = rule.Comment
+	}
+
+	return nil
+}
+
+func (s *memoryStore) AcceptSuggestion(classification int, driveIds []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range driveIds {
+		s.classifications[int(id)] = memoryClassification{Classification: classification, Suggested: true}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) GetClassifiedTrips() ([]ClassifiedTrip, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var trips []ClassifiedTrip
+	for _, d := range s.drives {
+		c, ok := s.classifications[d.Id]
+		if !ok || c.RuleId.Valid || c.Suggested {
+			continue
+		}
+
+		trips = append(trips, ClassifiedTrip{
+			StartGeofenceId: d.StartGeofenceId,
+			EndGeofenceId:   d.EndGeofenceId,
+			StartAddress:    d.StartAddress,
+			EndAddress:      d.EndAddress,
+			Classification:  c.Classification,
+			Count:           1,
+		})
+	}
+
+	return trips, nil
+}
+
+func (s *memoryStore) GetCategories() ([]Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	categories := append([]Category{}, s.categories...)
+	sort.SliceStable(categories, func(i, j int) bool {
+		if categories[i].SortOrder != categories[j].SortOrder {
+			return categories[i].SortOrder < categories[j].SortOrder
+		}
+
+		return categories[i].Id < categories[j].Id
+	})
+
+	return categories, nil
+}
+
+func (s *memoryStore) CreateCategory(c Category) (Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.Id = s.nextCategoryId
+	s.nextCategoryId++
+	s.categories = append(s.categories, c)
+
+	return c, nil
+}
+
+func (s *memoryStore) UpdateCategory(c Category) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.categories {
+		if s.categories[i].Id == c.Id {
+			s.categories[i] = c
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) DeleteCategory(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.classifications {
+		if c.Classification == id {
+			return errCategoryInUse
+		}
+	}
+
+	for _, g := range s.groups {
+		if g.Classification.Valid && int(g.Classification.Int32) == id {
+			return errCategoryInUse
+		}
+	}
+
+	for i, c := range s.categories {
+		if c.Id == id {
+			s.categories = append(s.categories[:i], s.categories[i+1:]...)
+
+			// the rates of the category are deleted along with it:
+			var rates []Rate
+			for _, r := range s.rates {
+				if r.Category != id {
+					rates = append(rates, r)
+				}
+			}
+			s.rates = rates
+
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) sortedRules(carId int, all bool) []Rule {
+	var rules []Rule
+	for _, r := range s.rules {
+		if all || !r.CarId.Valid || int(r.CarId.Int32) == carId {
+			rules = append(rules, r)
+		}
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+
+		return rules[i].Id < rules[j].Id
+	})
+
+	return rules
+}
+
+func (s *memoryStore) GetRules(carId int) ([]Rule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedRules(carId, false), nil
+}
+
+func (s *memoryStore) GetAllRules() ([]Rule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedRules(0, true), nil
+}
+
+func (s *memoryStore) CreateRule(rule Rule) (Rule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule.Id = s.nextRuleId
+	s.nextRuleId++
+	s.rules = append(s.rules, rule)
+
+	return rule, nil
+}
+
+func (s *memoryStore) UpdateRule(rule Rule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.rules {
+		if s.rules[i].Id == rule.Id {
+			s.rules[i] = rule
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) DeleteRule(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, r := range s.rules {
+		if r.Id == id {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) GetRates() ([]Rate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rates := append([]Rate{}, s.rates...)
+	sort.SliceStable(rates, func(i, j int) bool {
+		if rates[i].Category != rates[j].Category {
+			return rates[i].Category < rates[j].Category
+		}
+
+		return rates[i].ValidFrom.Before(rates[j].ValidFrom)
+	})
+
+	return rates, nil
+}
+
+func (s *memoryStore) CreateRate(rate Rate) (Rate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.category(rate.Category); !exists {
+		return rate, errors.New("Unknown category")
+	}
+
+	rate.Id = s.nextRateId
+	s.nextRateId++
+	s.rates = append(s.rates, rate)
+
+	return rate, nil
+}
+
+func (s *memoryStore) UpdateRate(rate Rate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.rates {
+		if s.rates[i].Id == rate.Id {
+			s.rates[i] = rate
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) DeleteRate(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, r := range s.rates {
+		if r.Id == id {
+			s.rates = append(s.rates[:i], s.rates[i+1:]...)
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) GetClosedMonths(carId int) ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var months []time.Time
+	for month := range s.closedMonths[carId] {
+		months = append(months, month)
+	}
+
+	sort.Slice(months, func(i, j int) bool { return months[i].Before(months[j]) })
+
+	return months, nil
+}
+
+func (s *memoryStore) GetClosedMonthsOf(driveIds []int64, groupIds []int64) ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := make(map[time.Time]bool)
+	for _, d := range s.drives {
+		if containsId(driveIds, int64(d.Id)) && s.closedMonths[d.CarId][monthStart(d.StartDate)] {
+			found[monthStart(d.StartDate)] = true
+		}
+	}
+
+	for _, g := range s.groups {
+		if containsId(groupIds, int64(g.Id)) && s.closedMonths[g.CarId][monthStart(g.StartDate)] {
+			found[monthStart(g.StartDate)] = true
+		}
+	}
+
+	var months []time.Time
+	for month := range found {
+		months = append(months, month)
+	}
+
+	sort.Slice(months, func(i, j int) bool { return months[i].Before(months[j]) })
+
+	return months, nil
+}
+
+func (s *memoryStore) CloseMonth(carId int, month time.Time, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closedMonths[carId][month] {
+		return nil
+	}
+
+	if s.closedMonths[carId] == nil {
+		s.closedMonths[carId] = make(map[time.Time]bool)
+	}
+	s.closedMonths[carId][month] = true
+	s.closedMonthLog = append(s.closedMonthLog, ClosedMonthEvent{CarId: carId, Month: month, Action: "close", User: user, Time: time.Now()})
+
+	return nil
+}
+
+func (s *memoryStore) ReopenMonth(carId int, month time.Time, user, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closedMonths[carId][month] {
+		return sql.ErrNoRows
+	}
+
+	delete(s.closedMonths[carId], month)
+	s.closedMonthLog = append(s.closedMonthLog, ClosedMonthEvent{CarId: carId, Month: month, Action: "reopen", User: user, Reason: reason, Time: time.Now()})
+
+	return nil
+}
+
+func (s *memoryStore) GetClosedMonthLog(carId int) ([]ClosedMonthEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []ClosedMonthEvent
+	for _, e := range s.closedMonthLog {
+		if e.CarId == carId {
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
+
+func (s *memoryStore) AppendAudit(entries []AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := ""
+	if len(s.auditLog) > 0 {
+		last = s.auditLog[len(s.auditLog)-1].Hash
+	}
+
+	chainAuditEntries(last, entries)
+
+	for _, e := range entries {
+		e.Id = len(s.auditLog) + 1
+		s.auditLog = append(s.auditLog, e)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) GetAuditLog() ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]AuditEntry{}, s.auditLog...), nil
+}
+
+func (s *memoryStore) GetAuditHistory(entity string, entityId int) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []AuditEntry
+	for _, e := range s.auditLog {
+		if e.Entity == entity && e.EntityId == entityId {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *memoryStore) GetUsers() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := append([]User{}, s.users...)
+	sort.SliceStable(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	return users, nil
+}
+
+func (s *memoryStore) GetUser(username string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+
+	return User{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) CreateUser(user User) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Username == user.Username {
+			return user, errors.New("duplicate key value violates unique constraint")
+		}
+	}
+
+	if !isRole(user.Role) {
+		return user, errors.New("new row violates check constraint")
+	}
+
+	user.Id = s.nextUserId
+	user.Created = time.Now()
+	s.nextUserId++
+	s.users = append(s.users, user)
+
+	return user, nil
+}
+
+func (s *memoryStore) SetPassword(username, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].Username == username {
+			s.users[i].PasswordHash = passwordHash
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) SetRole(username, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !isRole(role) {
+		return errors.New("new row violates check constraint")
+	}
+
+	for i := range s.users {
+		if s.users[i].Username == username {
+			s.users[i].Role = role
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) SetLocale(username, locale string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].Username == username {
+			s.users[i].Locale = locale
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, u := range s.users {
+		if u.Username != username {
+			continue
+		}
+
+		s.users = append(s.users[:i:i], s.users[i+1:]...)
+
+		for hash, session := range s.sessions {
+			if session.UserId == u.Id {
+				delete(s.sessions, hash)
+			}
+		}
+
+		for j := range s.drivers {
+			if s.drivers[j].UserId.Valid && int(s.drivers[j].UserId.Int32) == u.Id {
+				s.drivers[j].UserId = sql.NullInt32{}
+			}
+		}
+
+		var tokens []ApiToken
+		for _, t := range s.apiTokens {
+			if t.UserId != u.Id {
+				tokens = append(tokens, t)
+			}
+		}
+		s.apiTokens = tokens
+
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) CreateSession(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, existing := range s.sessions {
+		if existing.Expires.Before(time.Now()) {
+			delete(s.sessions, hash)
+		}
+	}
+
+	s.sessions[session.TokenHash] = session
+
+	return nil
+}
+
+func (s *memoryStore) GetSession(tokenHash string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[tokenHash]
+	if !ok || !session.Expires.After(time.Now()) {
+		return Session{}, sql.ErrNoRows
+	}
+
+	for _, u := range s.users {
+		if u.Id == session.UserId {
+			session.Username = u.Username
+			session.Role = u.Role
+			session.Locale = u.Locale
+		}
+	}
+
+	return session, nil
+}
+
+func (s *memoryStore) DeleteSession(tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, tokenHash)
+
+	return nil
+}
+
+// withUser fills in the username and role of the user of a token.
+func (s *memoryStore) withUser(t ApiToken) ApiToken {
+	for _, u := range s.users {
+		if u.Id == t.UserId {
+			t.Username, t.Role, t.Locale = u.Username, u.Role, u.Locale
+		}
+	}
+
+	return t
+}
+
+func (s *memoryStore) GetApiTokens() ([]ApiToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tokens []ApiToken
+	for _, t := range s.apiTokens {
+		tokens = append(tokens, s.withUser(t))
+	}
+
+	return tokens, nil
+}
+
+func (s *memoryStore) GetApiToken(tokenHash string) (ApiToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.apiTokens {
+		if t.TokenHash == tokenHash && !t.Revoked.Valid && (!t.Expires.Valid || t.Expires.Time.After(time.Now())) {
+			return s.withUser(t), nil
+		}
+	}
+
+	return ApiToken{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) CreateApiToken(token ApiToken) (ApiToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token.Scope != scopeRead && token.Scope != scopeWrite {
+		return token, errors.New("new row violates check constraint")
+	}
+
+	token.Id = s.nextTokenId
+	token.Created = time.Now()
+	s.nextTokenId++
+	s.apiTokens = append(s.apiTokens, token)
+
+	return token, nil
+}
+
+func (s *memoryStore) RevokeApiToken(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.apiTokens {
+		if s.apiTokens[i].Id == id && !s.apiTokens[i].Revoked.Valid {
+			s.apiTokens[i].Revoked = sql.NullTime{Time: time.Now(), Valid: true}
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) GetDrivers(carId int) ([]Driver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var drivers []Driver
+	for _, d := range s.drivers {
+		if d.CarId == carId {
+			drivers = append(drivers, d)
+		}
+	}
+
+	sort.SliceStable(drivers, func(i, j int) bool { return drivers[i].Name < drivers[j].Name })
+
+	return drivers, nil
+}
+
+func (s *memoryStore) GetDriversOfUser(userId int) ([]Driver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var drivers []Driver
+	for _, d := range s.drivers {
+		if d.UserId.Valid && int(d.UserId.Int32) == userId {
+			drivers = append(drivers, d)
+		}
+	}
+
+	sort.SliceStable(drivers, func(i, j int) bool { return drivers[i].Name < drivers[j].Name })
+
+	return drivers, nil
+}
+
+func (s *memoryStore) CreateDriver(d Driver) (Driver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.drivers {
+		if existing.CarId == d.CarId && existing.Name == d.Name {
+			return d, errors.New("Duplicate driver")
+		}
+	}
+
+	d.Id = s.nextDriverId
+	s.nextDriverId++
+	s.drivers = append(s.drivers, d)
+
+	return d, nil
+}
+
+func (s *memoryStore) UpdateDriver(d Driver) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.drivers {
+		if s.drivers[i].Id == d.Id {
+			s.drivers[i].Name = d.Name
+			s.drivers[i].UserId = d.UserId
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) DeleteDriver(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, d := range s.drivers {
+		if d.Id != id {
+			continue
+		}
+
+		s.drivers = append(s.drivers[:i:i], s.drivers[i+1:]...)
+
+		for drive, driver := range s.assignments {
+			if driver == id {
+				delete(s.assignments, drive)
+			}
+		}
+
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) AssignDriver(driverId int, driveIds []int64, groupIds []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range append(append([]int64{}, driveIds...), s.driveIdsForGroups(groupIds)...) {
+		if driverId == 0 {
+			delete(s.assignments, int(id))
+		} else {
+			s.assignments[int(id)] = driverId
+		}
+	}
+
+	return nil
+}
+
+// useFixtures makes the journal use a memory store seeded with testdata/fixtures.json
+// for the duration of the test.
+func useFixtures(t *testing.T) *memoryStore {
+	t.Helper()
+
+	s := newMemoryStore()
+	err := s.LoadFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatalf("Unable to load fixtures: %v", err)
+	}
+
+	previous := store
+	store = s
+	t.Cleanup(func() { store = previous })
+
+	return s
+}
+
+// Transaction restores the contents of the store if fn fails.
+func (s *memoryStore) Transaction(fn func(JournalStore) error) error {
+	s.mu.Lock()
+	snapshot := s.copy()
+	s.mu.Unlock()
+
+	err := fn(s)
+	if err != nil {
+		s.mu.Lock()
+		s.restore(snapshot)
+		s.mu.Unlock()
+	}
+
+	return err
+}
+
+func (s *memoryStore) copy() *memoryStore {
+	c := newMemoryStore()
+
+	c.cars = append([]Car{}, s.cars...)
+	c.drives = append([]Drive{}, s.drives...)
+	c.categories = append([]Category{}, s.categories...)
+	c.rules = append([]Rule{}, s.rules...)
+	c.rates = append([]Rate{}, s.rates...)
+	c.closedMonthLog = append([]ClosedMonthEvent{}, s.closedMonthLog...)
+	c.auditLog = append([]AuditEntry{}, s.auditLog...)
+	c.users = append([]User{}, s.users...)
+	c.drivers = append([]Driver{}, s.drivers...)
+	c.apiTokens = append([]ApiToken{}, s.apiTokens...)
+	c.unitOfLength = s.unitOfLength
+
+	for id, driver := range s.assignments {
+		c.assignments[id] = driver
+	}
+
+	for hash, session := range s.sessions {
+		c.sessions[hash] = session
+	}
+
+	for car, months := range s.closedMonths {
+		c.closedMonths[car] = make(map[time.Time]bool)
+		for month := range months {
+			c.closedMonths[car][month] = true
+		}
+	}
+
+	for _, g := range s.groups {
+		g.DriveIds = append(pq.Int64Array{}, g.DriveIds...)
+		c.groups = append(c.groups, g)
+	}
+
+	for id, p := range s.positions {
+		c.positions[id] = p
+	}
+
+	for id, classification := range s.classifications {
+		c.classifications[id] = classification
+	}
+
+	for id, comment := range s.comments {
+		c.comments[id] = comment
+	}
+
+	c.nextGroupId, c.nextCategoryId, c.nextRuleId, c.nextRateId, c.nextUserId = s.nextGroupId, s.nextCategoryId, s.nextRuleId, s.nextRateId, s.nextUserId
+	c.nextDriverId, c.nextTokenId = s.nextDriverId, s.nextTokenId
+
+	return c
+}
+
+func (s *memoryStore) restore(c *memoryStore) {
+	s.cars, s.drives, s.categories, s.rules, s.rates, s.groups = c.cars, c.drives, c.categories, c.rules, c.rates, c.groups
+	s.positions, s.classifications, s.comments = c.positions, c.classifications, c.comments
+	s.closedMonths, s.closedMonthLog, s.auditLog = c.closedMonths, c.closedMonthLog, c.auditLog
+	s.users, s.sessions = c.users, c.sessions
+	s.drivers, s.assignments, s.nextDriverId = c.drivers, c.assignments, c.nextDriverId
+	s.apiTokens, s.nextTokenId = c.apiTokens, c.nextTokenId
+	s.unitOfLength = c.unitOfLength
+	s.nextGroupId, s.nextCategoryId, s.nextRuleId, s.nextRateId, s.nextUserId = c.nextGroupId, c.nextCategoryId, c.nextRuleId, c.nextRateId, c.nextUserId
+}
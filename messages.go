@@ -0,0 +1,381 @@
+package main
+
+// catalogs holds the texts of the pages, scripts, exports and reports by language and key, see
+// i18n.go. Texts taking values are formatted with fmt.Sprintf. Every catalog must have the same
+// keys; language_name is the name of the language in itself.
+var catalogs = map[string]map[string]string{
+	"sv": {
+		"language_name":    "Svenska",
+		"browser_language": "Webbläsarens språk",
+		"language":         "Språk",
+		"locale_tag":       "sv-SE",
+
+		// the pages:
+		"app_title":             "Tesla Körjournal",
+		"all_drivers":           "Alla förare",
+		"group":                 "Gruppera",
+		"ungroup":               "Avgruppera",
+		"no_driver":             "Ingen förare",
+		"assign_driver":         "Tilldela förare",
+		"rules":                 "Regler",
+		"accept_suggestions":    "Acceptera förslag",
+		"export":                "Exportera",
+		"print":                 "Skriv ut",
+		"annual_report":         "Årsrapport",
+		"rates":                 "Ersättningar",
+		"drivers":               "Förare",
+		"reopen_month":          "Öppna månad",
+		"month_closed":          "Månaden är stängd",
+		"close_month":           "Stäng månad",
+		"logged_in_as":          "Inloggad som %s",
+		"logout":                "Logga ut",
+		"total_distance":        "Total körsträcka",
+		"of_which_business":     "Varav tjänsteresor",
+		"of_which_private":      "Varav privatresor",
+		"without_driver":        "Utan förare",
+		"unclassified_distance": "Oklassificerad sträcka",
+		"total_duration":        "Total tid",
+		"unclassified_duration": "Oklassificerad tid",
+		"drive_distance":        "Körsträcka",
+		"duration":              "Tid",
+		"reimbursement":         "Ersättning",
+		"auto_classified":       "Automatiskt klassificerad",
+		"back":                  "Tillbaka",
+		"odometer_start":        "Odometer vid start",
+		"odometer_end":          "Odometer vid mål",
+		"distance":              "Sträcka",
+		"purpose_placeholder":   "Syfte med resan",
+		"save":                  "Spara",
+		"delete":                "Ta bort",
+		"add":                   "Lägg till",
+		"history":               "Historik",
+		"time":                  "Tid",
+		"user":                  "Användare",
+		"change":                "Ändring",
+		"from":                  "Från",
+		"to":                    "Till",
+		"classification":        "Klassificering",
+		"category_business":     "Tjänsteresa",
+		"category_private":      "Privat resa",
+		"comment":               "Kommentar",
+		"grouping":              "Gruppering",
+		"ungrouping":            "Avgruppering",
+		"driver":                "Förare",
+		"rule":                  "Regel",
+		"login":                 "Logga in",
+		"login_failed":          "Fel användarnamn eller lösenord.",
+		"username":              "Användarnamn",
+		"password":              "Lösenord",
+		"rate_per_km":           "Ersättning per km",
+		"default_rate":          "Tjänsteresor utan giltig ersättning räknas med %.2f kr/km.",
+		"category":              "Kategori",
+		"valid_from":            "Gäller från",
+		"valid_to":              "Gäller till",
+		"drivers_of":            "Förare av %s",
+		"drivers_note":          "Resor som tas bort från en förare blir utan förare.",
+		"name":                  "Namn",
+		"user_hint":             "Användaren som loggar in som föraren",
+
+		// the scripts:
+		"error_occurred":        "Ett fel inträffade.",
+		"confirm_close_month":   "Vill du stänga månaden? Resorna kan inte ändras förrän månaden öppnas igen.",
+		"reopen_reason_prompt":  "Ange varför månaden öppnas igen:",
+		"confirm_copy_comment":  "Vill du kopiera gruppens kommentar till de enskilda resorna?",
+		"rate_not_saved":        "Ersättningen kunde inte sparas.",
+		"confirm_delete_rate":   "Vill du ta bort ersättningen?",
+		"rate_not_deleted":      "Ersättningen kunde inte tas bort.",
+		"driver_not_saved":      "Föraren kunde inte sparas.",
+		"confirm_delete_driver": "Vill du ta bort föraren? Förarens resor blir utan förare.",
+		"driver_not_deleted":    "Föraren kunde inte tas bort.",
+
+		// the annual report:
+		"business_trips_of":        "Tjänsteresor %d",
+		"allowance":                "Milersättning",
+		"missing_purposes":         "%d tjänsteresor saknar ärende",
+		"n_trips":                  "%d resor",
+		"date":                     "Datum",
+		"odometer_start_short":     "Mätare start",
+		"odometer_end_short":       "Mätare slut",
+		"km":                       "Km",
+		"mi":                       "Mi",
+		"kr":                       "Kr",
+		"purpose":                  "Ärende",
+		"purpose_missing":          "Ärende saknas",
+		"no_business_trips":        "Inga tjänsteresor under året.",
+		"business_journal":         "Körjournal för tjänsteresor",
+		"income_year":              "Inkomstår",
+		"business_trips_per_month": "Tjänsteresor per månad",
+
+		// the year overview:
+		"year_overview":       "Årsöversikt",
+		"month":               "Månad",
+		"total":               "Totalt",
+		"business":            "Tjänsteresor",
+		"private":             "Privatresor",
+		"months_unclassified": "Månader med oklassificerade resor: %d",
+		"all_classified":      "Alla resor under året är klassificerade.",
+
+		// the exports:
+		"start":               "Start",
+		"end":                 "Slut",
+		"odometer_start_full": "Mätarställning start",
+		"odometer_end_full":   "Mätarställning slut",
+		"distance_km":         "Sträcka (km)",
+		"distance_mi":         "Sträcka (mi)",
+		"reimbursement_kr":    "Ersättning (kr)",
+		"remark":              "Anmärkning",
+		"sum_business":        "Summa tjänsteresor",
+		"sum_private":         "Summa privatresor",
+		"sum_unclassified":    "Summa oklassificerade resor",
+		"sum":                 "Summa",
+
+		// the printed journal:
+		"journal":            "Körjournal",
+		"page_of":            "Sida %d av {nb}",
+		"car":                "Bil",
+		"owner":              "Ägare",
+		"period":             "Period",
+		"printed":            "Utskriven",
+		"no_drives":          "Inga resor under perioden.",
+		"summary":            "Summering",
+		"unclassified":       "Oklassificerat",
+		"signature":          "Underskrift",
+		"name_clarification": "Namnförtydligande",
+
+		"month_1":  "Januari",
+		"month_2":  "Februari",
+		"month_3":  "Mars",
+		"month_4":  "April",
+		"month_5":  "Maj",
+		"month_6":  "Juni",
+		"month_7":  "Juli",
+		"month_8":  "Augusti",
+		"month_9":  "September",
+		"month_10": "Oktober",
+		"month_11": "November",
+		"month_12": "December",
+
+		// the messages of failed requests:
+		"not_logged_in":            "Du är inte inloggad",
+		"invalid_csrf":             "Ogiltig förfrågan, ladda om sidan och försök igen",
+		"bad_request":              "Felaktig förfrågan",
+		"invalid_id":               "Ogiltigt id",
+		"unknown_action":           "Okänd åtgärd",
+		"invalid_classification":   "Ogiltig klassificering",
+		"invalid_driver":           "Ogiltig förare",
+		"select_drives":            "Välj de resor åtgärden gäller",
+		"not_own_drive":            "Du får bara ändra dina egna resor",
+		"not_allowed":              "Du har inte behörighet till det här",
+		"car_not_allowed":          "Du har inte behörighet till den här bilen",
+		"car_missing":              "Ange vilken bil åtgärden gäller",
+		"access_not_checked":       "Behörigheten kunde inte kontrolleras",
+		"reopen_reason_missing":    "Ange varför månaden öppnas",
+		"month_closed_error":       "Månaden är stängd och kan inte ändras",
+		"month_not_closed":         "Månaden är inte stängd",
+		"group_invalid":            "Bara ogrupperade resor med den valda bilen kan grupperas",
+		"drives_not_retrieved":     "Resorna kunde inte hämtas",
+		"drive_not_found":          "Resan finns inte",
+		"drive_not_retrieved":      "Resan kunde inte hämtas",
+		"totals_not_retrieved":     "Summeringen kunde inte hämtas",
+		"tokens_not_accepted":      "API-nycklar kan inte användas här",
+		"token_not_checked":        "API-nyckeln kunde inte kontrolleras",
+		"invalid_token":            "Ogiltig API-nyckel",
+		"token_read_only":          "API-nyckeln får bara läsa",
+		"classify_failed":          "Resorna kunde inte klassificeras",
+		"comment_failed":           "Kommentaren kunde inte sparas",
+		"applyrules_failed":        "Reglerna kunde inte tillämpas",
+		"acceptsuggestions_failed": "Förslagen kunde inte accepteras",
+		"group_failed":             "Resorna kunde inte grupperas",
+		"ungroup_failed":           "Grupperingen kunde inte tas bort",
+		"closemonth_failed":        "Månaden kunde inte stängas",
+		"reopenmonth_failed":       "Månaden kunde inte öppnas",
+		"assign_failed":            "Föraren kunde inte tilldelas",
+	},
+	"en": {
+		"language_name":    "English",
+		"browser_language": "Browser language",
+		"language":         "Language",
+		"locale_tag":       "en-GB",
+
+		// the pages:
+		"app_title":             "Tesla Driving Journal",
+		"all_drivers":           "All drivers",
+		"group":                 "Group",
+		"ungroup":               "Ungroup",
+		"no_driver":             "No driver",
+		"assign_driver":         "Assign driver",
+		"rules":                 "Rules",
+		"accept_suggestions":    "Accept suggestions",
+		"export":                "Export",
+		"print":                 "Print",
+		"annual_report":         "Annual report",
+		"rates":                 "Allowances",
+		"drivers":               "Drivers",
+		"reopen_month":          "Reopen month",
+		"month_closed":          "The month is closed",
+		"close_month":           "Close month",
+		"logged_in_as":          "Logged in as %s",
+		"logout":                "Log out",
+		"total_distance":        "Total distance",
+		"of_which_business":     "Of which business",
+		"of_which_private":      "Of which private",
+		"without_driver":        "Without driver",
+		"unclassified_distance": "Unclassified distance",
+		"total_duration":        "Total duration",
+		"unclassified_duration": "Unclassified duration",
+		"drive_distance":        "Distance",
+		"duration":              "Duration",
+		"reimbursement":         "Allowance",
+		"auto_classified":       "Classified automatically",
+		"back":                  "Back",
+		"odometer_start":        "Odometer at start",
+		"odometer_end":          "Odometer at destination",
+		"distance":              "Distance",
+		"purpose_placeholder":   "Purpose of the trip",
+		"save":                  "Save",
+		"delete":                "Delete",
+		"add":                   "Add",
+		"history":               "History",
+		"time":                  "Time",
+		"user":                  "User",
+		"change":                "Change",
+		"from":                  "From",
+		"to":                    "To",
+		"classification":        "Classification",
+		"category_business":     "Business trip",
+		"category_private":      "Private trip",
+		"comment":               "Comment",
+		"grouping":              "Grouping",
+		"ungrouping":            "Ungrouping",
+		"driver":                "Driver",
+		"rule":                  "Rule",
+		"login":                 "Log in",
+		"login_failed":          "Wrong username or password.",
+		"username":              "Username",
+		"password":              "Password",
+		"rate_per_km":           "Allowance per km",
+		"default_rate":          "Business trips without a valid rate are counted at %.2f kr/km.",
+		"category":              "Category",
+		"valid_from":            "Valid from",
+		"valid_to":              "Valid to",
+		"drivers_of":            "Drivers of %s",
+		"drivers_note":          "Drives removed from a driver are left without a driver.",
+		"name":                  "Name",
+		"user_hint":             "The user who logs in as the driver",
+
+		// the scripts:
+		"error_occurred":        "An error occurred.",
+		"confirm_close_month":   "Do you want to close the month? Its drives can't be changed until the month is reopened.",
+		"reopen_reason_prompt":  "Tell why the month is reopened:",
+		"confirm_copy_comment":  "Do you want to copy the comment of the group to its drives?",
+		"rate_not_saved":        "The allowance couldn't be saved.",
+		"confirm_delete_rate":   "Do you want to delete the allowance?",
+		"rate_not_deleted":      "The allowance couldn't be deleted.",
+		"driver_not_saved":      "The driver couldn't be saved.",
+		"confirm_delete_driver": "Do you want to delete the driver? The drives of the driver are left without a driver.",
+		"driver_not_deleted":    "The driver couldn't be deleted.",
+
+		// the annual report:
+		"business_trips_of":        "Business trips %d",
+		"allowance":                "Mileage allowance",
+		"missing_purposes":         "%d business trips lack a purpose",
+		"n_trips":                  "%d trips",
+		"date":                     "Date",
+		"odometer_start_short":     "Odometer start",
+		"odometer_end_short":       "Odometer end",
+		"km":                       "Km",
+		"mi":                       "Mi",
+		"kr":                       "Kr",
+		"purpose":                  "Purpose",
+		"purpose_missing":          "Purpose missing",
+		"no_business_trips":        "No business trips during the year.",
+		"business_journal":         "Journal of business trips",
+		"income_year":              "Income year",
+		"business_trips_per_month": "Business trips per month",
+
+		// the year overview:
+		"year_overview":       "Year overview",
+		"month":               "Month",
+		"total":               "Total",
+		"business":            "Business",
+		"private":             "Private",
+		"months_unclassified": "Months with unclassified drives: %d",
+		"all_classified":      "All drives of the year are classified.",
+
+		// the exports:
+		"start":               "Start",
+		"end":                 "End",
+		"odometer_start_full": "Odometer at start",
+		"odometer_end_full":   "Odometer at end",
+		"distance_km":         "Distance (km)",
+		"distance_mi":         "Distance (mi)",
+		"reimbursement_kr":    "Allowance (kr)",
+		"remark":              "Remark",
+		"sum_business":        "Total business trips",
+		"sum_private":         "Total private trips",
+		"sum_unclassified":    "Total unclassified trips",
+		"sum":                 "Total",
+
+		// the printed journal:
+		"journal":            "Driving journal",
+		"page_of":            "Page %d of {nb}",
+		"car":                "Car",
+		"owner":              "Owner",
+		"period":             "Period",
+		"printed":            "Printed",
+		"no_drives":          "No drives during the period.",
+		"summary":            "Summary",
+		"unclassified":       "Unclassified",
+		"signature":          "Signature",
+		"name_clarification": "Name in block letters",
+
+		"month_1":  "January",
+		"month_2":  "February",
+		"month_3":  "March",
+		"month_4":  "April",
+		"month_5":  "May",
+		"month_6":  "June",
+		"month_7":  "July",
+		"month_8":  "August",
+		"month_9":  "September",
+		"month_10": "October",
+		"month_11": "November",
+		"month_12": "December",
+
+		// the messages of failed requests:
+		"not_logged_in":            "You aren't logged in",
+		"invalid_csrf":             "Invalid request, reload the page and try again",
+		"bad_request":              "Bad request",
+		"invalid_id":               "Invalid id",
+		"unknown_action":           "Unknown action",
+		"invalid_classification":   "Invalid classification",
+		"invalid_driver":           "Invalid driver",
+		"select_drives":            "Select the drives the action applies to",
+		"not_own_drive":            "You may only change your own drives",
+		"not_allowed":              "You aren't allowed to do this",
+		"car_not_allowed":          "You aren't allowed to see this car",
+		"car_missing":              "Say which car the action concerns",
+		"access_not_checked":       "Access couldn't be checked",
+		"reopen_reason_missing":    "Tell why the month is reopened",
+		"month_closed_error":       "The month is closed and can't be changed",
+		"month_not_closed":         "The month isn't closed",
+		"group_invalid":            "Only ungrouped drives of the selected car can be grouped",
+		"drives_not_retrieved":     "The drives couldn't be retrieved",
+		"drive_not_found":          "There is no such drive",
+		"drive_not_retrieved":      "The drive couldn't be retrieved",
+		"totals_not_retrieved":     "The totals couldn't be retrieved",
+		"tokens_not_accepted":      "API tokens can't be used here",
+		"token_not_checked":        "The API token couldn't be checked",
+		"invalid_token":            "Invalid API token",
+		"token_read_only":          "The API token may only read",
+		"classify_failed":          "The drives couldn't be classified",
+		"comment_failed":           "The comment couldn't be saved",
+		"applyrules_failed":        "The rules couldn't be applied",
+		"acceptsuggestions_failed": "The suggestions couldn't be accepted",
+		"group_failed":             "The drives couldn't be grouped",
+		"ungroup_failed":           "The group couldn't be removed",
+		"closemonth_failed":        "The month couldn't be closed",
+		"reopenmonth_failed":       "The month couldn't be reopened",
+		"assign_failed":            "The driver couldn't be assigned",
+	},
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// The schema of the tj_ tables is defined by the numbered SQL files in the migrations
+// directory. Each migration has an up file, applying it, and a down file, reverting it.
+// The versions applied to the database are recorded in tj_schema_version. The tables
+// are owned by the configured database user; {{owner}} in a file is replaced by its name.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrationStep is a migration to apply (up) or revert (down).
+type migrationStep struct {
+	Migration Migration
+	Up        bool
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, errors.New("Invalid migration file name: " + entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("Migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		contents, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("Migration %d (%s) needs both an up and a down file", m.Version, m.Name)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// planMigration returns the steps taking the schema from the current version to the target
+// version: the missing migrations up to the target in ascending order, or the applied
+// migrations above the target in descending order.
+func planMigration(migrations []Migration, current, target int) []migrationStep {
+	var steps []migrationStep
+
+	if target >= current {
+		for _, m := range migrations {
+			if m.Version > current && m.Version <= target {
+				steps = append(steps, migrationStep{Migration: m, Up: true})
+			}
+		}
+	} else {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if m.Version <= current && m.Version > target {
+				steps = append(steps, migrationStep{Migration: m, Up: false})
+			}
+		}
+	}
+
+	return steps
+}
+
+func latestVersion(migrations []Migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+
+	return migrations[len(migrations)-1].Version
+}
+
+// schemaVersion returns the latest version applied to the database, 0 if none.
+func schemaVersion(tx *sql.Tx) (int, error) {
+	var version int
+
+	err := tx.QueryRow("SELECT COALESCE(max(version), 0) FROM public.tj_schema_version;").Scan(&version)
+
+	return version, err
+}
+
+// migrateTo applies or reverts migrations until the schema is at the target version.
+// Everything is done in a single transaction, so a failing migration leaves the
+// schema as it was.
+func migrateTo(target int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if target < 0 || target > latestVersion(migrations) {
+		return fmt.Errorf("Unknown schema version %d; the latest version is %d", target, latestVersion(migrations))
+	}
+
+	tx, err := db().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	owner := pq.QuoteIdentifier(config.Connection.User)
+
+	statement := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS public.tj_schema_version
+    (
+        version integer PRIMARY KEY,
+        name character varying NOT NULL,
+        applied_at timestamp without time zone NOT NULL DEFAULT (now() at time zone 'utc')
+    );
+    ALTER TABLE public.tj_schema_version
+    OWNER to %s;`, owner)
+
+	_, err = tx.Exec(statement)
+	if err != nil {
+		return err
+	}
+
+	// keep two instances from migrating at the same time:
+	_, err = tx.Exec("LOCK TABLE public.tj_schema_version IN EXCLUSIVE MODE;")
+	if err != nil {
+		return err
+	}
+
+	current, err := schemaVersion(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range planMigration(migrations, current, target) {
+		m := step.Migration
+
+		if step.Up {
+			_, err = tx.Exec(strings.ReplaceAll(m.Up, "{{owner}}", owner))
+			if err == nil {
+				_, err = tx.Exec("INSERT INTO public.tj_schema_version (version, name) VALUES ($1, $2);", m.Version, m.Name)
+			}
+		} else {
+			_, err = tx.Exec(strings.ReplaceAll(m.Down, "{{owner}}", owner))
+			if err == nil {
+				_, err = tx.Exec("DELETE FROM public.tj_schema_version WHERE version=$1;", m.Version)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("Migration %04d_%s failed: %v", m.Version, m.Name, err)
+		}
+
+		if step.Up {
+			fmt.Printf("Applied migration %04d_%s.\n", m.Version, m.Name)
+		} else {
+			fmt.Printf("Reverted migration %04d_%s.\n", m.Version, m.Name)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database schema is at version %d.\n", target)
+
+	return nil
+}
+
+// migrateUp applies all migrations that haven't been applied yet.
+func migrateUp() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return migrateTo(latestVersion(migrations))
+}
+
+func currentSchemaVersion() (int, error) {
+	tx, err := db().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow("SELECT to_regclass('public.tj_schema_version') IS NOT NULL;").Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return schemaVersion(tx)
+}
+
+// runMigrateCommand implements the migrate subcommand:
+//
+//	migrate [up]       apply all pending migrations
+//	migrate down       revert the latest migration
+//	migrate to N       migrate up or down to version N
+//	migrate status     list the migrations and whether they are applied
+func runMigrateCommand(args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	current, err := currentSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		return migrateTo(latestVersion(migrations))
+	case "down":
+		if current == 0 {
+			return errors.New("No migrations to revert")
+		}
+
+		target := 0
+		for _, m := range migrations {
+			if m.Version < current {
+				target = m.Version
+			}
+		}
+
+		return migrateTo(target)
+	case "to":
+		if len(args) < 2 {
+			return errors.New("Usage: migrate to <version>")
+		}
+
+		target, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errors.New("Invalid version: " + args[1])
+		}
+
+		return migrateTo(target)
+	case "status":
+		for _, m := range migrations {
+			state := "pending"
+			if m.Version <= current {
+				state = "applied"
+			}
+
+			fmt.Printf("%04d_%s: %s\n", m.Version, m.Name, state)
+		}
+
+		return nil
+	}
+
+	return errors.New("Unknown migrate command: " + command + " (use up, down, to <version> or status)")
+}
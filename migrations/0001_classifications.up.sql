@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS public.tj_classifications
+(
+    drive_id integer NOT NULL,
+    classification integer,
+    PRIMARY KEY (drive_id)
+);
+ALTER TABLE public.tj_classifications
+OWNER to {{owner}};
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS public.tj_grouped_drives
+(
+    id SERIAL PRIMARY KEY,
+    car_id integer NOT NULL,
+    drive_ids integer[] NOT NULL,
+    start_date timestamp without time zone NOT NULL,
+    end_date timestamp without time zone NOT NULL,
+    start_address character varying NOT NULL,
+    end_address character varying NOT NULL,
+    distance double precision NOT NULL,
+    duration_min smallint NOT NULL,
+    classification integer
+);
+ALTER TABLE public.tj_grouped_drives
+OWNER to {{owner}};
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS public.tj_comments
+(
+    drive_id integer NOT NULL,
+    comment text,
+    PRIMARY KEY (drive_id)
+);
+ALTER TABLE public.tj_comments
+OWNER to {{owner}};
@@ -0,0 +1,2 @@
+ALTER TABLE public.tj_grouped_drives
+DROP COLUMN IF EXISTS comment;
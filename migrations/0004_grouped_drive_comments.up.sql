@@ -0,0 +1,2 @@
+ALTER TABLE public.tj_grouped_drives
+ADD COLUMN IF NOT EXISTS comment text;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS public.tj_categories
+(
+    id SERIAL PRIMARY KEY,
+    label character varying NOT NULL,
+    css_class character varying NOT NULL,
+    deductible boolean NOT NULL DEFAULT false,
+    sort_order integer NOT NULL DEFAULT 0
+);
+ALTER TABLE public.tj_categories
+OWNER to {{owner}};
+
+-- the built-in business and private trips keep the ids classifications have always used:
+INSERT INTO public.tj_categories (id, label, css_class, deductible, sort_order)
+VALUES (1, 'Tjänsteresa', 'business', true, 1), (2, 'Privat resa', 'private', false, 2)
+ON CONFLICT(id) DO NOTHING;
+SELECT setval(pg_get_serial_sequence('public.tj_categories', 'id'), (SELECT max(id) FROM public.tj_categories));
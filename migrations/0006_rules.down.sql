@@ -0,0 +1,3 @@
+ALTER TABLE public.tj_classifications
+DROP COLUMN IF EXISTS rule_id;
+DROP TABLE IF EXISTS public.tj_rules;
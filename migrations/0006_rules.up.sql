@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS public.tj_rules
+(
+    id SERIAL PRIMARY KEY,
+    car_id integer,
+    priority integer NOT NULL DEFAULT 0,
+    start_geofence_id integer,
+    end_geofence_id integer,
+    start_address character varying NOT NULL DEFAULT '',
+    end_address character varying NOT NULL DEFAULT '',
+    weekdays integer[],
+    time_from smallint,
+    time_to smallint,
+    min_distance double precision,
+    max_distance double precision,
+    classification integer NOT NULL,
+    comment text NOT NULL DEFAULT ''
+);
+ALTER TABLE public.tj_rules
+OWNER to {{owner}};
+
+-- drives classified by a rule refer to it; drives classified by hand have no rule:
+ALTER TABLE public.tj_classifications
+ADD COLUMN IF NOT EXISTS rule_id integer;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS public.tj_rates
+(
+    id SERIAL PRIMARY KEY,
+    category_id integer NOT NULL REFERENCES public.tj_categories(id) ON DELETE CASCADE,
+    rate_per_km numeric(10, 4) NOT NULL,
+    valid_from date NOT NULL,
+    valid_to date,
+    CHECK (valid_to IS NULL OR valid_to >= valid_from)
+);
+ALTER TABLE public.tj_rates
+OWNER to {{owner}};
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.tj_closed_month_log;
+DROP TABLE IF EXISTS public.tj_closed_months;
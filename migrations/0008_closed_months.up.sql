@@ -0,0 +1,24 @@
+-- a closed month is identified by its first day:
+CREATE TABLE IF NOT EXISTS public.tj_closed_months
+(
+    car_id integer NOT NULL,
+    month date NOT NULL,
+    closed_at timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (car_id, month)
+);
+ALTER TABLE public.tj_closed_months
+OWNER to {{owner}};
+
+-- every closing and reopening of a month, which is never deleted:
+CREATE TABLE IF NOT EXISTS public.tj_closed_month_log
+(
+    id SERIAL PRIMARY KEY,
+    car_id integer NOT NULL,
+    month date NOT NULL,
+    action character varying NOT NULL,
+    username character varying NOT NULL DEFAULT '',
+    reason text NOT NULL DEFAULT '',
+    logged_at timestamp with time zone NOT NULL DEFAULT now()
+);
+ALTER TABLE public.tj_closed_month_log
+OWNER to {{owner}};
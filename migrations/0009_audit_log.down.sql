@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.tj_audit_log;
+DROP FUNCTION IF EXISTS public.tj_audit_log_append_only();
@@ -0,0 +1,37 @@
+-- every change of the classification, comment or grouping of a drive or grouped drive. Each
+-- row holds the hash of the row before it, and rows can't be updated or deleted:
+CREATE TABLE IF NOT EXISTS public.tj_audit_log
+(
+    id SERIAL PRIMARY KEY,
+    logged_at timestamp with time zone NOT NULL,
+    username character varying NOT NULL DEFAULT '',
+    action character varying NOT NULL,
+    entity character varying NOT NULL,
+    entity_id integer NOT NULL,
+    old_value text NOT NULL DEFAULT '',
+    new_value text NOT NULL DEFAULT '',
+    prev_hash character varying NOT NULL,
+    hash character varying NOT NULL
+);
+ALTER TABLE public.tj_audit_log
+OWNER to {{owner}};
+
+CREATE INDEX IF NOT EXISTS tj_audit_log_entity ON public.tj_audit_log (entity, entity_id);
+
+CREATE OR REPLACE FUNCTION public.tj_audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'tj_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+ALTER FUNCTION public.tj_audit_log_append_only()
+OWNER to {{owner}};
+
+DROP TRIGGER IF EXISTS tj_audit_log_no_change ON public.tj_audit_log;
+CREATE TRIGGER tj_audit_log_no_change
+BEFORE UPDATE OR DELETE ON public.tj_audit_log
+FOR EACH ROW EXECUTE PROCEDURE public.tj_audit_log_append_only();
+
+DROP TRIGGER IF EXISTS tj_audit_log_no_truncate ON public.tj_audit_log;
+CREATE TRIGGER tj_audit_log_no_truncate
+BEFORE TRUNCATE ON public.tj_audit_log
+FOR EACH STATEMENT EXECUTE PROCEDURE public.tj_audit_log_append_only();
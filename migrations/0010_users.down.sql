@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.tj_sessions;
+DROP TABLE IF EXISTS public.tj_users;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS public.tj_users
+(
+    id SERIAL PRIMARY KEY,
+    username character varying NOT NULL UNIQUE,
+    password_hash character varying NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+ALTER TABLE public.tj_users
+OWNER to {{owner}};
+
+-- logins; the cookie holds a token of which only the hash is kept here:
+CREATE TABLE IF NOT EXISTS public.tj_sessions
+(
+    token_hash character varying PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.tj_users (id) ON DELETE CASCADE,
+    csrf_token character varying NOT NULL,
+    expires_at timestamp with time zone NOT NULL
+);
+ALTER TABLE public.tj_sessions
+OWNER to {{owner}};
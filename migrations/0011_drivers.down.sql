@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.tj_drive_drivers;
+DROP TABLE IF EXISTS public.tj_drivers;
@@ -0,0 +1,19 @@
+-- the people driving a car:
+CREATE TABLE IF NOT EXISTS public.tj_drivers
+(
+    id SERIAL PRIMARY KEY,
+    car_id integer NOT NULL,
+    name character varying NOT NULL,
+    UNIQUE (car_id, name)
+);
+ALTER TABLE public.tj_drivers
+OWNER to {{owner}};
+
+-- who drove each drive; a group has a driver if all of its drives have the same one:
+CREATE TABLE IF NOT EXISTS public.tj_drive_drivers
+(
+    drive_id integer PRIMARY KEY,
+    driver_id integer NOT NULL REFERENCES public.tj_drivers (id) ON DELETE CASCADE
+);
+ALTER TABLE public.tj_drive_drivers
+OWNER to {{owner}};
@@ -0,0 +1,2 @@
+ALTER TABLE public.tj_drivers DROP COLUMN IF EXISTS user_id;
+ALTER TABLE public.tj_users DROP COLUMN IF EXISTS role;
@@ -0,0 +1,9 @@
+-- what a user may do: owners may do anything, drivers classify their own drives and accountants
+-- only read; the users there were before roles keep doing anything:
+ALTER TABLE public.tj_users
+ADD COLUMN IF NOT EXISTS role character varying NOT NULL DEFAULT 'owner'
+CHECK (role IN ('owner', 'driver', 'accountant'));
+
+-- the user who is the driver, whose drives they may change:
+ALTER TABLE public.tj_drivers
+ADD COLUMN IF NOT EXISTS user_id integer REFERENCES public.tj_users (id) ON DELETE SET NULL;
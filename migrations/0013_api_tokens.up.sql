@@ -0,0 +1,15 @@
+-- tokens letting scripts use the journal on behalf of a user; like sessions, only the hash of the
+-- token is kept. Revoked tokens are kept for reference:
+CREATE TABLE IF NOT EXISTS public.tj_api_tokens
+(
+    id SERIAL PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.tj_users (id) ON DELETE CASCADE,
+    name character varying NOT NULL,
+    token_hash character varying NOT NULL UNIQUE,
+    scope character varying NOT NULL CHECK (scope IN ('read', 'write')),
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    expires_at timestamp with time zone,
+    revoked_at timestamp with time zone
+);
+ALTER TABLE public.tj_api_tokens
+OWNER to {{owner}};
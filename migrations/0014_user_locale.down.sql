@@ -0,0 +1 @@
+ALTER TABLE public.tj_users DROP COLUMN IF EXISTS locale;
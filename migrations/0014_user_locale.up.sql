@@ -0,0 +1,3 @@
+-- the language a user chose for the pages; without one, the browser chooses:
+ALTER TABLE public.tj_users
+ADD COLUMN IF NOT EXISTS locale character varying;
@@ -0,0 +1,2 @@
+ALTER TABLE public.tj_classifications
+DROP COLUMN IF EXISTS suggested;
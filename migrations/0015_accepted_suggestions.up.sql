@@ -0,0 +1,3 @@
+-- drives classified by accepting a suggestion are marked so that they don't train the suggestions:
+ALTER TABLE public.tj_classifications
+ADD COLUMN IF NOT EXISTS suggested boolean NOT NULL DEFAULT false;
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("Expected embedded migrations")
+	}
+
+	// versions must be numbered 1, 2, 3... so that none is skipped on upgrade:
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Expected migration %d, got %d (%s)", i+1, m.Version, m.Name)
+		}
+
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("Migration %d (%s) has an empty up or down file", m.Version, m.Name)
+		}
+	}
+}
+
+func versions(steps []migrationStep) []int {
+	var v []int
+	for _, s := range steps {
+		v = append(v, s.Migration.Version)
+	}
+
+	return v
+}
+
+func TestPlanMigration(t *testing.T) {
+	migrations := []Migration{{Version: 1}, {Version: 2}, {Version: 3}, {Version: 4}}
+
+	tests := []struct {
+		current, target int
+		up              bool
+		expected        []int
+	}{
+		{0, 4, true, []int{1, 2, 3, 4}},
+		{2, 4, true, []int{3, 4}},
+		{4, 4, true, nil},
+		{4, 1, false, []int{4, 3, 2}},
+		{3, 0, false, []int{3, 2, 1}},
+	}
+
+	for _, test := range tests {
+		steps := planMigration(migrations, test.current, test.target)
+
+		if !equalIds(versions(steps), test.expected) {
+			t.Errorf("%d -> %d: expected %v, got %v", test.current, test.target, test.expected, versions(steps))
+		}
+
+		for _, s := range steps {
+			if s.Up != test.up {
+				t.Errorf("%d -> %d: step %d goes the wrong way", test.current, test.target, s.Migration.Version)
+			}
+		}
+	}
+}
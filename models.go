@@ -8,8 +8,8 @@ import (
 	geojson "github.com/paulmach/go.geojson"
 )
 
+// ids of the built-in categories, created along with the tj_categories table:
 const (
-	unknown  = -1
 	business = 1
 	private  = 2
 )
@@ -26,6 +26,35 @@ type Config struct {
 		Port     int
 		CertFile string
 		KeyFile  string
+		// the time zone that days, months and times are given in:
+		TimeZone string
+		// the language of the pages, exports and reports, unless the user or browser chooses
+		// another, see i18n.go:
+		Locale string
+		// the unit of length, km or mi, of cars without one of their own; TeslaMate's if unset:
+		Units string
+	}
+	// the settings of single cars, by id, e.g. [Car "2"]:
+	Car map[string]*struct {
+		// the unit of length of the car, km or mi:
+		Units string
+	}
+	Export struct {
+		Delimiter        string
+		DecimalSeparator string
+		Columns          string
+		Grouped          bool
+	}
+	Report struct {
+		Owner string
+		// the mileage allowance (milersättning) per business km, in SEK:
+		RatePerKm float64
+	}
+	Auth struct {
+		// requires logging in; only disable it if no one else can reach the service:
+		Enabled bool
+		// how long a login lasts:
+		SessionHours int
 	}
 }
 
@@ -58,25 +87,47 @@ func (d Day) IsWeekend() bool {
 	return false
 }
 
+// the classification filters of JournalStore.GetDrivesPage besides the ids of categories:
+const (
+	anyClassification = 0
+	noClassification  = -1
+)
+
 type Drive struct {
-	Id                   int
-	StartDate            time.Time
-	EndDate              time.Time
-	StartTime            string
-	EndTime              string
-	Duration             int
-	DurationString       string
-	StartAddress         string
-	EndAddress           string
-	StartOdometer        int
-	EndOdometer          int
-	Distance             float32
-	DistanceString       string
-	Classification       sql.NullInt32
-	ClassificationClass  string
-	ClassificationString string
-	GroupId              sql.NullInt32
-	Comment              sql.NullString
+	Id                      int
+	CarId                   int
+	StartDate               time.Time
+	EndDate                 time.Time
+	StartTime               string
+	EndTime                 string
+	Duration                int
+	DurationString          string
+	StartAddress            string
+	EndAddress              string
+	StartOdometer           int
+	EndOdometer             int
+	Distance                float32
+	DistanceString          string
+	Classification          sql.NullInt32
+	ClassificationClass     string
+	ClassificationString    string
+	GroupId                 sql.NullInt32
+	Comment                 sql.NullString
+	StartGeofenceId         sql.NullInt32
+	EndGeofenceId           sql.NullInt32
+	RuleId                  sql.NullInt32
+	SuggestedClassification sql.NullInt32
+	SuggestionConfidence    float64
+	SuggestionString        string
+	Reimbursement           float64
+	ReimbursementString     string
+	DriverId                sql.NullInt32
+	DriverName              string
+}
+
+// IsAutoClassified tells whether the drive was classified by a rule rather than by hand.
+func (d Drive) IsAutoClassified() bool {
+	return d.Classification.Valid && d.RuleId.Valid
 }
 
 func (d Drive) GroupIdInt() int {
@@ -91,6 +142,8 @@ type GetDriveResponse struct {
 	Drive   Drive
 	Comment string
 	MapData geojson.FeatureCollection
+	// the unit of the distance and odometer readings of the drive:
+	Unit string
 }
 
 type GroupedDrives struct {
@@ -113,11 +166,139 @@ type GroupedDrives struct {
 	ClassificationClass  string
 	ClassificationString string
 	Comment              sql.NullString
+	Reimbursement        float64
+	ReimbursementString  string
+	// DriverId is set if all of the drives of the group have the same driver:
+	DriverId   sql.NullInt32
+	DriverName string
 }
 
 type GetGroupedDrivesResponse struct {
 	Drives  GroupedDrives
 	MapData geojson.FeatureCollection
+	// the unit of the distance and odometer readings of the drives:
+	Unit string
+}
+
+type Category struct {
+	Id         int
+	Label      string
+	CssClass   string
+	Deductible bool
+	SortOrder  int
+}
+
+// Rule classifies drives automatically. Every condition that is set must match for
+// the rule to apply; TimeFrom and TimeTo are given in minutes after midnight.
+type Rule struct {
+	Id              int
+	CarId           sql.NullInt32
+	Priority        int
+	StartGeofenceId sql.NullInt32
+	EndGeofenceId   sql.NullInt32
+	StartAddress    string
+	EndAddress      string
+	Weekdays        pq.Int64Array
+	TimeFrom        sql.NullInt32
+	TimeTo          sql.NullInt32
+	MinDistance     sql.NullFloat64
+	MaxDistance     sql.NullFloat64
+	Classification  int
+	Comment         string
+}
+
+// Rate is the reimbursement per km for drives of a category during a period. ValidTo is the
+// last day of the period; a rate without it applies until further notice.
+type Rate struct {
+	Id        int
+	Category  int
+	RatePerKm float64
+	ValidFrom time.Time
+	ValidTo   sql.NullTime
+}
+
+// ClosedMonthEvent records the closing or reopening of a month of a car. Month is the
+// first day of the month.
+type ClosedMonthEvent struct {
+	CarId  int
+	Month  time.Time
+	Action string
+	User   string
+	Reason string
+	Time   time.Time
+}
+
+// AuditEntry is a change of a drive or grouped drive in the audit log. Each entry holds the
+// hash of the entry before it, so that changing or removing an entry breaks the chain.
+type AuditEntry struct {
+	Id       int
+	Time     time.Time
+	User     string
+	Action   string
+	Entity   string
+	EntityId int
+	OldValue string
+	NewValue string
+	PrevHash string
+	Hash     string
+}
+
+// User is someone allowed to log in to the journal. Their role tells what they may do, see roles.go.
+type User struct {
+	Id           int
+	Username     string
+	PasswordHash string `json:"-"`
+	Role         string
+	// Locale is the language the user chose; empty if the browser chooses:
+	Locale  string
+	Created time.Time
+}
+
+// Session is a login of a user. Only the hash of the token in the session cookie is stored;
+// changes must carry the CSRF token of the session.
+type Session struct {
+	TokenHash string
+	UserId    int
+	Username  string
+	Role      string
+	Locale    string
+	CSRFToken string
+	Expires   time.Time
+}
+
+// ApiToken lets scripts use the journal on behalf of a user, see tokens.go. Only the hash of the
+// token is stored. A token is no good once revoked or expired.
+type ApiToken struct {
+	Id        int
+	UserId    int
+	Username  string
+	Role      string
+	Locale    string
+	Name      string
+	TokenHash string `json:"-"`
+	Scope     string
+	Created   time.Time
+	Expires   sql.NullTime
+	Revoked   sql.NullTime
+}
+
+// ClassifiedTrip is the number of drives between a pair of places having a classification.
+type ClassifiedTrip struct {
+	StartGeofenceId sql.NullInt32
+	EndGeofenceId   sql.NullInt32
+	StartAddress    string
+	EndAddress      string
+	Classification  int
+	Count           int
+}
+
+// Driver is someone driving a car. Each drive can be assigned to one of the drivers of its car.
+// A driver who logs in is linked to their user.
+type Driver struct {
+	Id     int
+	CarId  int
+	Name   string
+	UserId sql.NullInt32
 }
 
 type Car struct {
@@ -140,15 +321,23 @@ type Totals struct {
 	TotalPrivateDistance  float32
 	UnclassifiedDuration  int
 	UnclassifiedDistance  float32
+	// the distance of the drives without a driver:
+	UnassignedDistance float32
+	// Reimbursement is the amount owed for the drives of the period, in SEK:
+	Reimbursement float64
 }
 
 type MainData struct {
-	Year                        int
-	Month                       int
-	CarId                       int
+	Year  int
+	Month int
+	CarId int
+	// the driver whose drives are shown; 0 shows those of all drivers:
+	DriverId                    int
 	DropdownCars                []Car
+	Drivers                     []Driver
 	DropdownYears               []int
 	DropdownMonths              []Month
+	Categories                  []Category
 	Days                        []Day
 	TotalDurationString         string
 	TotalBusinessDurationString string
@@ -159,17 +348,18 @@ type MainData struct {
 	UnclassifiedDrivesRemaining bool
 	UnclassifiedDurationString  string
 	UnclassifiedDistanceString  string
-}
-
-func getClassificationId(classification string) int {
-	switch classification {
-	case "business":
-		return business
-	case "private":
-		return private
-	default:
-		return unknown
-	}
+	UnassignedDistanceString    string
+	TotalReimbursementString    string
+	Closed                      bool
+	// the unit of the distances, see units.go:
+	Unit string
+	// the logged in user; empty if logging in isn't required:
+	User string
+	// the language the user chose; empty if the browser chooses:
+	UserLocale string
+	// whether the user may change drives, and everything else, see roles.go:
+	CanEdit   bool
+	CanManage bool
 }
 
 type Position struct {
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// The OpenAPI document at /api/openapi.json describes every route of the router. The operations
+// are described in apiOperations, while the schemas of their JSON are derived from the Go types
+// they encode, so that the document can't drift from the structs in models.go.
+
+const openapiVersion = "3.0.3"
+
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+	// Multiple parameters may be given several times:
+	Multiple bool
+}
+
+func intParam(name, description string) apiParam {
+	return apiParam{Name: name, Type: "integer", Description: description}
+}
+
+func stringParam(name, description string) apiParam {
+	return apiParam{Name: name, Type: "string", Description: description}
+}
+
+func (p apiParam) required() apiParam {
+	p.Required = true
+	return p
+}
+
+func (p apiParam) multiple() apiParam {
+	p.Multiple = true
+	return p
+}
+
+// apiOperation describes what a route does. The parameters in its path are taken from the route.
+type apiOperation struct {
+	Summary string
+	// Params are the query parameters, or the form parameters if Form is set:
+	Params []apiParam
+	Form   bool
+	// Body is a value of the type of the JSON request body, if there is one:
+	Body interface{}
+	// Status is the status of a successful response, 200 if not set:
+	Status int
+	// Response is a value of the type of the JSON response; ContentType is set for other responses:
+	Response    interface{}
+	ContentType string
+	// Public operations don't need logging in:
+	Public bool
+}
+
+var (
+	carParam     = intParam("car", "Id of the car")
+	yearParam    = intParam("year", "Year")
+	monthParam   = intParam("month", "Month, 1 - 12")
+	driverParam  = intParam("driver", "Id of a driver of the car; only their drives are included")
+	fromParam    = stringParam("from", "First date (YYYY-MM-DD)")
+	toParam      = stringParam("to", "Last date (YYYY-MM-DD)")
+	decimalParam = stringParam("decimal", "Decimal separator")
+	idsParams    = []apiParam{intParam("drive", "Id of a drive").multiple(), intParam("groupeddrive", "Id of a grouped drive").multiple()}
+
+	categoryParams = []apiParam{stringParam("label", "Label").required(), stringParam("cssclass", "CSS class").required(),
+		{Name: "deductible", Type: "boolean", Description: "Whether the distance is business distance"}, intParam("sortorder", "Order of the categories")}
+	ruleParams = []apiParam{intParam("classification", "Id of the category to assign").required(), stringParam("comment", "Comment to write on the drive"),
+		intParam("priority", "Rules with higher priority are tried first"), intParam("car", "Only apply the rule to the car"),
+		intParam("startgeofence", "Id of the geofence where the drive must start"), intParam("endgeofence", "Id of the geofence where the drive must end"),
+		stringParam("startaddress", "Text the start address must contain"), stringParam("endaddress", "Text the end address must contain"),
+		intParam("weekday", "Day of the week the drive must start on, 0 (Sunday) - 6 (Saturday)").multiple(),
+		stringParam("timefrom", "Earliest start time (HH:MM)"), stringParam("timeto", "Latest start time (HH:MM)"),
+		{Name: "mindistance", Type: "number", Description: "Shortest distance in km"}, {Name: "maxdistance", Type: "number", Description: "Longest distance in km"}}
+	rateParams = []apiParam{intParam("category", "Id of the category").required(), {Name: "rate", Type: "number", Description: "SEK per km", Required: true},
+		stringParam("validfrom", "First day (YYYY-MM-DD)").required(), stringParam("validto", "Last day (YYYY-MM-DD)")}
+	driverParams = []apiParam{stringParam("name", "Name").required(), stringParam("user", "Username of the user who is the driver")}
+	periodParams = []apiParam{carParam.required(), stringParam("from", "First date (YYYY-MM-DD); the first drive if left out"),
+		stringParam("to", "Last date (YYYY-MM-DD); the last drive if left out"), driverParam}
+)
+
+// apiOperations describes the routes of newRouter, by method and path template.
+var apiOperations = map[string]apiOperation{
+	"GET /static/":           {Summary: "Scripts and stylesheets", ContentType: "*/*", Public: true},
+	"GET /":                  {Summary: "The journal of the current month, or of the month given", Params: []apiParam{yearParam, monthParam, carParam, driverParam}, ContentType: "text/html"},
+	"POST /":                 {Summary: "The journal of a month", Form: true, Params: []apiParam{yearParam, monthParam, carParam, driverParam, intParam("previouscar", "The car shown before; the driver is ignored if it was another car")}, ContentType: "text/html"},
+	"GET /details/{id}":      {Summary: "The details page of a drive", ContentType: "text/html"},
+	"GET /drive/{id}":        {Summary: "A drive with its comment and route", Response: GetDriveResponse{}},
+	"GET /drive/group/{id}":  {Summary: "A grouped drive with its route", Response: GetGroupedDrivesResponse{}},
+	"GET /groupdetails/{id}": {Summary: "The details page of a grouped drive", ContentType: "text/html"},
+	"POST /action": {Summary: "Change drives, returning the totals of the month and the days affected", Form: true, Response: PostResponse{},
+		Params: append([]apiParam{{Name: "action", Type: "string", Required: true, Description: "classify, comment, assign, applyrules, acceptsuggestions, group, ungroup, closemonth or reopenmonth"},
+			yearParam, monthParam, carParam, driverParam, intParam("classification", "Id of the category, for classify"), stringParam("comment", "The comment, for comment"),
+			intParam("assignee", "Id of the driver, or 0 for none, for assign"), fromParam, toParam,
+			stringParam("copycomment", "true copies the comment of the groups to their drives, for ungroup"), stringParam("reason", "Why the month is reopened, for reopenmonth")}, idsParams...)},
+	"GET /export": {Summary: "The drives of a period as CSV", ContentType: "text/csv",
+		Params: []apiParam{carParam.required(), yearParam, monthParam, fromParam, toParam, stringParam("delimiter", "Field delimiter"), decimalParam,
+			stringParam("columns", "Comma separated list of columns"), stringParam("grouped", "false exports the drives of groups"), driverParam}},
+	"GET /report/{car}/{year}/{month}.pdf": {Summary: "The journal of a month as PDF", Params: []apiParam{driverParam}, ContentType: "application/pdf"},
+	"GET /annual/{car}/{year}.pdf":         {Summary: "The annual report as PDF", Params: []apiParam{driverParam}, ContentType: "application/pdf"},
+	"GET /annual/{car}/{year}.csv":         {Summary: "The annual report as CSV", Params: []apiParam{driverParam, stringParam("delimiter", "Field delimiter"), decimalParam}, ContentType: "text/csv"},
+	"GET /annual/{car}/{year}":             {Summary: "The annual report", Params: []apiParam{driverParam}, ContentType: "text/html"},
+	"GET /year/{car}/{year}":               {Summary: "The totals of each month of the year", Params: []apiParam{driverParam}, ContentType: "text/html"},
+	"GET /categories":                      {Summary: "The categories", Response: []Category{}},
+	"POST /categories":                     {Summary: "Add a category", Form: true, Params: categoryParams, Status: http.StatusCreated, Response: Category{}},
+	"PUT /categories/{id}":                 {Summary: "Change a category", Form: true, Params: categoryParams, Response: Category{}},
+	"DELETE /categories/{id}":              {Summary: "Delete a category that no drive has", Status: http.StatusNoContent},
+	"GET /rules":                           {Summary: "The rules", Response: []Rule{}},
+	"POST /rules":                          {Summary: "Add a rule", Form: true, Params: ruleParams, Status: http.StatusCreated, Response: Rule{}},
+	"PUT /rules/{id}":                      {Summary: "Change a rule", Form: true, Params: ruleParams, Response: Rule{}},
+	"DELETE /rules/{id}":                   {Summary: "Delete a rule", Status: http.StatusNoContent},
+	"GET /rates":                           {Summary: "The reimbursement rates", Response: []Rate{}},
+	"POST /rates":                          {Summary: "Add a rate", Form: true, Params: rateParams, Status: http.StatusCreated, Response: Rate{}},
+	"PUT /rates/{id}":                      {Summary: "Change a rate", Form: true, Params: rateParams, Response: Rate{}},
+	"DELETE /rates/{id}":                   {Summary: "Delete a rate", Status: http.StatusNoContent},
+	"GET /settings/rates":                  {Summary: "The page for editing the rates", ContentType: "text/html"},
+	"GET /drivers/{car}":                   {Summary: "The drivers of a car", Response: []Driver{}},
+	"POST /drivers/{car}":                  {Summary: "Add a driver", Form: true, Params: driverParams, Status: http.StatusCreated, Response: Driver{}},
+	"PUT /drivers/{car}/{id}":              {Summary: "Change a driver", Form: true, Params: driverParams, Response: Driver{}},
+	"DELETE /drivers/{car}/{id}":           {Summary: "Delete a driver, leaving their drives without a driver", Status: http.StatusNoContent},
+	"GET /settings/drivers/{car}":          {Summary: "The page for editing the drivers of a car", ContentType: "text/html"},
+	"GET /closedmonths/{car}":              {Summary: "The closed months of a car and the log of their closing and reopening", Response: ClosedMonthsResponse{}},
+	"GET /history/drive/{id}":              {Summary: "The audit log of a drive", Response: []AuditEntry{}},
+	"GET /history/group/{id}":              {Summary: "The audit log of a grouped drive", Response: []AuditEntry{}},
+	"GET /api/openapi.json":                {Summary: "This document", ContentType: "application/json", Public: true},
+	"GET /api/v1/cars":                     {Summary: "The cars", Response: []apiCar{}},
+	"GET /api/v1/drives": {Summary: "The drives of a car, latest first", Response: apiDrivesPage{},
+		Params: append(periodParams, stringParam("classification", "Id of a category, or none for the unclassified drives"),
+			intParam("limit", "Number of drives, at most 1000; 100 if left out"), intParam("offset", "Number of drives to skip"))},
+	"GET /api/v1/drives/{id}":    {Summary: "A drive", Response: apiDrive{}},
+	"PATCH /api/v1/drives/{id}":  {Summary: "Change the classification and comment of a drive", Body: apiDrivePatch{}, Response: apiDrive{}},
+	"POST /api/v1/groups":        {Summary: "Group drives", Body: apiGroupRequest{}, Status: http.StatusCreated, Response: apiGroup{}},
+	"DELETE /api/v1/groups/{id}": {Summary: "Ungroup a group", Params: []apiParam{stringParam("copycomment", "true copies the comment of the group to its drives")}, Status: http.StatusNoContent},
+	"GET /api/v1/totals":         {Summary: "The totals of a car for a period", Params: periodParams, Response: apiTotals{}},
+	"GET /login":                 {Summary: "The login page", Params: []apiParam{stringParam("next", "Where to go after logging in")}, ContentType: "text/html", Public: true},
+	"POST /login": {Summary: "Log in, setting the session and CSRF cookies", Form: true, Status: http.StatusSeeOther, Public: true,
+		Params: []apiParam{stringParam("username", "").required(), stringParam("password", "").required(), stringParam("next", "Where to go after logging in")}},
+	"POST /logout": {Summary: "Log out", Status: http.StatusSeeOther},
+	"POST /locale": {Summary: "Choose the language of the pages of the user, going back to the page next", Form: true, Status: http.StatusSeeOther,
+		Params: []apiParam{stringParam("locale", "The language; empty lets the browser choose"), stringParam("next", "Where to go afterwards")}},
+}
+
+var pathVarPattern = regexp.MustCompile(`{([a-z]+)}`)
+
+// schemaGenerator derives the schemas of Go types, as they are encoded by encoding/json.
+type schemaGenerator struct {
+	components map[string]interface{}
+}
+
+type schema = map[string]interface{}
+
+func componentName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+
+	return string(name)
+}
+
+// schemaOf returns the schema of a type. Named structs become components referred to by name.
+func (g *schemaGenerator) schemaOf(t reflect.Type) schema {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return schema{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(geojson.FeatureCollection{}):
+		return schema{"type": "object", "required": []string{"type", "features"}, "properties": schema{
+			"type":     schema{"type": "string", "enum": []string{"FeatureCollection"}},
+			"features": schema{"type": "array", "items": schema{"type": "object"}},
+		}}
+	}
+
+	if t.Implements(reflect.TypeOf((*json.Marshaler)(nil)).Elem()) {
+		return schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schemaOf(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return schema{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Struct:
+		name := componentName(t)
+		if _, exists := g.components[name]; !exists {
+			// added before its fields, in case they refer to it:
+			g.components[name] = schema{}
+			g.components[name] = g.structSchema(t)
+		}
+		return schema{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return schema{"type": "string", "format": "byte"}
+		}
+		// nil slices are encoded as null:
+		return schema{"type": "array", "items": g.schemaOf(t.Elem()), "nullable": t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": g.schemaOf(t.Elem()), "nullable": true}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	}
+
+	return schema{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) schema {
+	properties := schema{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, options := f.Name, ""
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+
+			parts := strings.SplitN(tag, ",", 2)
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			if len(parts) > 1 {
+				options = parts[1]
+			}
+		}
+
+		properties[name] = g.schemaOf(f.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	return schema{"type": "object", "properties": properties, "required": required, "additionalProperties": false}
+}
+
+func (g *schemaGenerator) parameters(params []apiParam, in string) []interface{} {
+	var result []interface{}
+	for _, p := range params {
+		s := schema{"type": p.Type}
+		if p.Multiple {
+			s = schema{"type": "array", "items": s}
+		}
+
+		result = append(result, schema{"name": p.Name, "in": in, "description": p.Description, "required": p.Required, "schema": s})
+	}
+
+	return result
+}
+
+func (g *schemaGenerator) formBody(params []apiParam) schema {
+	properties := schema{}
+	required := []string{}
+	for _, p := range params {
+		s := schema{"type": p.Type, "description": p.Description}
+		if p.Multiple {
+			s = schema{"type": "array", "items": schema{"type": p.Type}, "description": p.Description}
+		}
+		properties[p.Name] = s
+
+		if p.Required {
+			required = append(required, p.Name)
+		}
+	}
+
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+
+	return schema{"content": schema{"application/x-www-form-urlencoded": schema{"schema": s}}}
+}
+
+// operation returns the OpenAPI operation of a route.
+func (g *schemaGenerator) operation(path, template string, op apiOperation) schema {
+	var params []interface{}
+	for _, m := range pathVarPattern.FindAllStringSubmatch(path, -1) {
+		t := "integer"
+		if m[1] == "file" {
+			t = "string"
+		}
+
+		params = append(params, schema{"name": m[1], "in": "path", "required": true, "schema": schema{"type": t}})
+	}
+
+	result := schema{"summary": op.Summary}
+
+	if op.Form {
+		result["requestBody"] = g.formBody(op.Params)
+	} else {
+		params = append(params, g.parameters(op.Params, "query")...)
+	}
+
+	if op.Body != nil {
+		result["requestBody"] = schema{"required": true, "content": schema{"application/json": schema{"schema": g.schemaOf(reflect.TypeOf(op.Body))}}}
+	}
+
+	if len(params) > 0 {
+		result["parameters"] = params
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	success := schema{"description": http.StatusText(status)}
+	if op.Response != nil {
+		success["content"] = schema{"application/json": schema{"schema": g.schemaOf(reflect.TypeOf(op.Response))}}
+	} else if op.ContentType != "" {
+		success["content"] = schema{op.ContentType: schema{"schema": schema{"type": "string"}}}
+	}
+
+	errorType := reflect.TypeOf(ErrorResponse{})
+	if strings.HasPrefix(template, "/api/v1/") {
+		errorType = reflect.TypeOf(apiErrorResponse{})
+	}
+
+	result["responses"] = schema{
+		strconv.Itoa(status): success,
+		"default": schema{"description": "An error", "content": schema{
+			"application/json": schema{"schema": g.schemaOf(errorType)},
+			"text/plain":       schema{"schema": schema{"type": "string"}},
+		}},
+	}
+
+	if op.Public {
+		result["security"] = []interface{}{}
+	}
+
+	return result
+}
+
+// routeTemplates returns the method and path template of each route of the router; routes
+// without methods only serve GET.
+func routeTemplates(router *mux.Router) ([][2]string, error) {
+	var routes [][2]string
+
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+
+		for _, m := range methods {
+			routes = append(routes, [2]string{m, template})
+		}
+
+		return nil
+	})
+
+	return routes, err
+}
+
+// openapiDocument returns the OpenAPI document of the routes of the router.
+func openapiDocument(router *mux.Router) (schema, error) {
+	routes, err := routeTemplates(router)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(routes, func(i, j int) bool { return routes[i][1] < routes[j][1] })
+
+	g := schemaGenerator{components: make(map[string]interface{})}
+	paths := schema{}
+
+	for _, route := range routes {
+		method, template := route[0], route[1]
+
+		path := template
+		if strings.HasSuffix(path, "/") && path != "/" {
+			path += "{file}"
+		}
+
+		op, described := apiOperations[method+" "+template]
+		if !described {
+			log.Println("The route " + method + " " + template + " isn't described in apiOperations")
+		}
+
+		item, ok := paths[path].(schema)
+		if !ok {
+			item = schema{}
+			paths[path] = item
+		}
+
+		item[strings.ToLower(method)] = g.operation(path, template, op)
+	}
+
+	doc := schema{
+		"openapi": openapiVersion,
+		"info": schema{
+			"title":       "Tesla Journal",
+			"description": "A driving journal for Teslamate. Distances and odometer readings are in the unit of the car, km or mi, given alongside them; those of rules and rates are in km. Durations are in minutes and amounts in SEK.",
+			"version":     "1",
+		},
+		"paths": paths,
+		"components": schema{
+			"schemas": g.components,
+			"securitySchemes": schema{
+				"session": schema{"type": "apiKey", "in": "cookie", "name": sessionCookie,
+					"description": "The session of a logged in user. Changes also need the CSRF token in the " + csrfHeader + " header or the csrf form parameter."},
+				"token": schema{"type": "http", "scheme": "bearer", "description": "An API token, see the token subcommand"},
+			},
+		},
+	}
+
+	if config.Auth.Enabled {
+		doc["security"] = []interface{}{schema{"session": []string{}}, schema{"token": []string{}}}
+	}
+
+	return doc, nil
+}
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	doc, err := openapiDocument(newRouter())
+	if err != nil {
+		log.Println("Error generating the OpenAPI document: " + err.Error())
+		http.Error(w, "Error generating the OpenAPI document", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, doc)
+}
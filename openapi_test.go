@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validate checks a decoded JSON value against a schema of the OpenAPI document, handling
+// the parts of JSON schema that the document uses.
+func validate(doc map[string]interface{}, s map[string]interface{}, value interface{}, path string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		component, ok := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, ref)
+		}
+
+		return validate(doc, component, value, path)
+	}
+
+	if value == nil {
+		if s["nullable"] == true || len(s) == 0 {
+			return nil
+		}
+
+		return fmt.Errorf("%s: null isn't allowed", path)
+	}
+
+	if allOf, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			err := validate(doc, sub.(map[string]interface{}), value, path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == value
+		}
+
+		if !found {
+			return fmt.Errorf("%s: %v isn't one of %v", path, value, enum)
+		}
+	}
+
+	switch s["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %T", path, value)
+		}
+
+		properties, _ := s["properties"].(map[string]interface{})
+		required, _ := s["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%s: %s is missing", path, name)
+			}
+		}
+
+		for name, v := range object {
+			property, ok := properties[name].(map[string]interface{})
+			if !ok {
+				property, ok = s["additionalProperties"].(map[string]interface{})
+			}
+
+			if !ok {
+				if s["additionalProperties"] == false {
+					return fmt.Errorf("%s: unexpected property %s", path, name)
+				}
+				continue
+			}
+
+			err := validate(doc, property, v, path+"."+name)
+			if err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %T", path, value)
+		}
+
+		for i, v := range array {
+			err := validate(doc, s["items"].(map[string]interface{}), v, path+"["+strconv.Itoa(i)+"]")
+			if err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string, got %T", path, value)
+		}
+
+		if s["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return fmt.Errorf("%s: %q isn't a date-time", path, str)
+			}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: expected an integer, got %v", path, value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: expected a number, got %T", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, got %T", path, value)
+		}
+	}
+
+	return nil
+}
+
+func getOpenAPI(t *testing.T) map[string]interface{} {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var doc map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return doc
+}
+
+// checkResponse validates the body of a response against the schema of its operation.
+func checkResponse(t *testing.T, doc map[string]interface{}, method, path string, w *httptest.ResponseRecorder) {
+	t.Helper()
+
+	operation, ok := doc["paths"].(map[string]interface{})[path].(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected %s %s in the document", method, path)
+	}
+
+	response, ok := operation["responses"].(map[string]interface{})[strconv.Itoa(w.Code)].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected the status %d of %s %s in the document", w.Code, method, path)
+	}
+
+	s := response["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+
+	body := w.Body.String()
+	var value interface{}
+	err := json.Unmarshal([]byte(body), &value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = validate(doc, s, value, "response")
+	if err != nil {
+		t.Errorf("Expected the response of %s %s to match the document: %v\n%s", method, path, err, body)
+	}
+}
+
+func TestOpenAPIDescribesAllRoutes(t *testing.T) {
+	useFixtures(t)
+	useAuth(t)
+
+	routes, err := routeTemplates(newRouter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registered := make(map[string]bool)
+	for _, route := range routes {
+		key := route[0] + " " + route[1]
+		registered[key] = true
+
+		if _, ok := apiOperations[key]; !ok {
+			t.Errorf("Expected %s to be described in apiOperations", key)
+		}
+	}
+
+	var stale []string
+	for key := range apiOperations {
+		if !registered[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(stale)
+
+	if len(stale) > 0 {
+		t.Errorf("Expected no operations without routes, got %v", stale)
+	}
+
+	// the document itself is public:
+	doc := getOpenAPI(t)
+	if doc["openapi"] != openapiVersion {
+		t.Errorf("Expected OpenAPI %s, got %v", openapiVersion, doc["openapi"])
+	}
+
+	paths := doc["paths"].(map[string]interface{})
+	for _, path := range []string{"/drive/{id}", "/api/v1/drives/{id}", "/login", "/static/{file}", "/report/{car}/{year}/{month}.pdf"} {
+		if _, ok := paths[path]; !ok {
+			t.Errorf("Expected %s in the document", path)
+		}
+	}
+
+	patch := paths["/api/v1/drives/{id}"].(map[string]interface{})["patch"].(map[string]interface{})
+	if _, ok := patch["requestBody"]; !ok {
+		t.Errorf("Expected the JSON body of PATCH /api/v1/drives/{id} in the document")
+	}
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	doc := getOpenAPI(t)
+
+	_, _, err := groupDrives(store, 1, []int64{4, 5}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := store.(*memoryStore).groups[0].Id
+
+	form := url.Values{"action": {"classify"}, "classification": {"1"}, "drive": {"3"}, "year": {"2021"}, "month": {"3"}, "car": {"1"}}
+	checkResponse(t, doc, http.MethodPost, "/action", postForm(t, "/action", form))
+
+	for _, c := range []struct {
+		method, path, template, body string
+		status                       int
+	}{
+		{http.MethodGet, "/drive/3", "/drive/{id}", "", http.StatusOK},
+		{http.MethodGet, "/drive/group/" + strconv.Itoa(group), "/drive/group/{id}", "", http.StatusOK},
+		{http.MethodGet, "/categories", "/categories", "", http.StatusOK},
+		{http.MethodGet, "/rates", "/rates", "", http.StatusOK},
+		{http.MethodGet, "/closedmonths/1", "/closedmonths/{car}", "", http.StatusOK},
+		{http.MethodGet, "/history/drive/3", "/history/drive/{id}", "", http.StatusOK},
+		{http.MethodGet, "/api/v1/cars", "/api/v1/cars", "", http.StatusOK},
+		{http.MethodGet, "/api/v1/drives?car=1", "/api/v1/drives", "", http.StatusOK},
+		{http.MethodGet, "/api/v1/drives/99", "/api/v1/drives/{id}", "", http.StatusNotFound},
+		{http.MethodPatch, "/api/v1/drives/6", "/api/v1/drives/{id}", `{"comment": "Kundbesök"}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/groups", "/api/v1/groups", `{"car": 1, "drives": [1, 2]}`, http.StatusCreated},
+		{http.MethodGet, "/api/v1/totals?car=1", "/api/v1/totals", "", http.StatusOK},
+	} {
+		w := apiRequest(t, c.method, c.path, c.body)
+		if w.Code != c.status {
+			t.Errorf("Expected status %d from %s %s, got %d", c.status, c.method, c.path, w.Code)
+			continue
+		}
+
+		if w.Code >= http.StatusBadRequest {
+			// errors are described by the default response:
+			operation := doc["paths"].(map[string]interface{})[c.template].(map[string]interface{})[strings.ToLower(c.method)].(map[string]interface{})
+			s := operation["responses"].(map[string]interface{})["default"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+
+			var value interface{}
+			json.Unmarshal(w.Body.Bytes(), &value)
+			if err := validate(doc, s, value, "error"); err != nil {
+				t.Errorf("Expected the error of %s %s to match the document: %v", c.method, c.path, err)
+			}
+			continue
+		}
+
+		checkResponse(t, doc, c.method, c.template, w)
+	}
+}
+
+func TestOpenAPIValidateRejects(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	doc := getOpenAPI(t)
+	drive := map[string]interface{}{"$ref": "#/components/schemas/GetDriveResponse"}
+
+	w := apiRequest(t, http.MethodGet, "/drive/3", "")
+	var value map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate(doc, drive, value, "response"); err != nil {
+		t.Fatalf("Expected the drive to validate: %v", err)
+	}
+
+	// a schema that accepts anything proves nothing:
+	value["Drive"].(map[string]interface{})["Distance"] = "20 km"
+	if validate(doc, drive, value, "response") == nil {
+		t.Errorf("Expected a string distance to be rejected")
+	}
+
+	delete(value["Drive"].(map[string]interface{}), "Distance")
+	if validate(doc, drive, value, "response") == nil {
+		t.Errorf("Expected a missing distance to be rejected")
+	}
+
+	value["Unknown"] = true
+	if validate(doc, drive, value, "response") == nil {
+		t.Errorf("Expected an unknown property to be rejected")
+	}
+}
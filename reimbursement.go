@@ -0,0 +1,253 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reimbursementTable computes the amounts owed for drives from the rates of their categories.
+type reimbursementTable struct {
+	rates      []Rate
+	categories map[int]Category
+	// defaultRate applies to deductible categories lacking a rate for the day:
+	defaultRate float64
+}
+
+func getReimbursementTable() (reimbursementTable, error) {
+	table := reimbursementTable{defaultRate: config.Report.RatePerKm}
+
+	var err error
+	table.categories, err = getCategoryMap()
+	if err != nil {
+		return table, err
+	}
+
+	table.rates, err = store.GetRates()
+
+	return table, err
+}
+
+// appliesTo tells whether the rate is valid on the day.
+func (rate Rate) appliesTo(day time.Time) bool {
+	if day.Before(stripTime(rate.ValidFrom)) {
+		return false
+	}
+
+	return !rate.ValidTo.Valid || !day.After(stripTime(rate.ValidTo.Time))
+}
+
+// rate returns the rate per km for a drive of the classification starting at start. If several
+// rates of the category are valid on the day, the one valid from the latest date is used.
+func (t reimbursementTable) rate(classification sql.NullInt32, start time.Time) float64 {
+	if !classification.Valid {
+		return 0
+	}
+
+	day := stripTime(convertTime(start))
+
+	var found *Rate
+	for i, rate := range t.rates {
+		if rate.Category == int(classification.Int32) && rate.appliesTo(day) {
+			if found == nil || rate.ValidFrom.After(found.ValidFrom) {
+				found = &t.rates[i]
+			}
+		}
+	}
+
+	if found != nil {
+		return found.RatePerKm
+	}
+
+	if c, known := t.categories[int(classification.Int32)]; known && c.Deductible {
+		return t.defaultRate
+	}
+
+	return 0
+}
+
+// amount returns the amount owed for a drive, rounded to öre.
+func (t reimbursementTable) amount(classification sql.NullInt32, start time.Time, distance float32) float64 {
+	return mileageAllowance(distance, t.rate(classification, start))
+}
+
+// mileageAllowance returns the allowance for the distance at the rate per km, rounded to öre.
+func mileageAllowance(distance float32, ratePerKm float64) float64 {
+	return math.Round(float64(distance)*ratePerKm*100) / 100
+}
+
+// formatAmount formats an amount in SEK with two decimals.
+func formatAmount(f float64, decimalSeparator string) string {
+	return strings.Replace(fmt.Sprintf("%.2f", f), ".", decimalSeparator, 1)
+}
+
+func parseRateForm(r *http.Request) (Rate, error) {
+	var rate Rate
+
+	err := r.ParseForm()
+	if err != nil {
+		return rate, err
+	}
+
+	err = getIntParamPost(r, "category", &rate.Category)
+	if err != nil {
+		return rate, errors.New("A rate needs a category")
+	}
+
+	categories, err := getCategoryMap()
+	if err != nil {
+		return rate, err
+	}
+
+	if _, exists := categories[rate.Category]; !exists {
+		return rate, errors.New("Unknown category: " + r.Form.Get("category"))
+	}
+
+	s := strings.Replace(strings.TrimSpace(r.Form.Get("rate")), ",", ".", 1)
+	rate.RatePerKm, err = strconv.ParseFloat(s, 64)
+	if err != nil || rate.RatePerKm < 0 {
+		return rate, errors.New("Invalid rate: " + r.Form.Get("rate"))
+	}
+
+	rate.ValidFrom, err = time.Parse("2006-01-02", strings.TrimSpace(r.Form.Get("validfrom")))
+	if err != nil {
+		return rate, errors.New("Invalid first date: " + r.Form.Get("validfrom"))
+	}
+
+	if s := strings.TrimSpace(r.Form.Get("validto")); s != "" {
+		validTo, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return rate, errors.New("Invalid last date: " + s)
+		}
+
+		if validTo.Before(rate.ValidFrom) {
+			return rate, errors.New("The rate ends before it starts")
+		}
+
+		rate.ValidTo = sql.NullTime{Time: validTo, Valid: true}
+	}
+
+	return rate, nil
+}
+
+func getRatesHandler(w http.ResponseWriter, r *http.Request) {
+	rates, err := store.GetRates()
+	if err != nil {
+		log.Println("Error retrieving rates: " + err.Error())
+		http.Error(w, "Error retrieving rates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rates)
+}
+
+func postRate(w http.ResponseWriter, r *http.Request) {
+	rate, err := parseRateForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rate, err = store.CreateRate(rate)
+	if err != nil {
+		log.Println("Error creating rate: " + err.Error())
+		http.Error(w, "Error creating rate", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rate)
+}
+
+func putRate(w http.ResponseWriter, r *http.Request) {
+	id, err := getIdVar(r)
+	if err != nil {
+		http.Error(w, "Invalid rate id", http.StatusBadRequest)
+		return
+	}
+
+	rate, err := parseRateForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rate.Id = id
+
+	err = store.UpdateRate(rate)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Println("Error updating rate: " + err.Error())
+		http.Error(w, "Error updating rate", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rate)
+}
+
+func removeRate(w http.ResponseWriter, r *http.Request) {
+	id, err := getIdVar(r)
+	if err != nil {
+		http.Error(w, "Invalid rate id", http.StatusBadRequest)
+		return
+	}
+
+	err = store.DeleteRate(id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Printf("Error deleting rate %d: %s\n", id, err.Error())
+		http.Error(w, "Error deleting rate", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type RatesData struct {
+	Categories  []Category
+	Rates       []Rate
+	DefaultRate float64
+}
+
+// serveRates serves the page for editing the rates; the page uses the rate endpoints.
+func serveRates(w http.ResponseWriter, r *http.Request) {
+	var data RatesData
+	var err error
+
+	data.Categories, err = store.GetCategories()
+	if err != nil {
+		log.Println("Error retrieving categories: " + err.Error())
+		http.Error(w, "Error retrieving categories", http.StatusInternalServerError)
+		return
+	}
+	localizeCategories(data.Categories, requestLocale(r))
+
+	data.Rates, err = store.GetRates()
+	if err != nil {
+		log.Println("Error retrieving rates: " + err.Error())
+		http.Error(w, "Error retrieving rates", http.StatusInternalServerError)
+		return
+	}
+
+	data.DefaultRate = config.Report.RatePerKm
+
+	err = ratesTemplate[requestLocale(r)].Execute(w, data)
+	if err != nil {
+		log.Println("Error while executing template: " + err.Error())
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestRateValidity(t *testing.T) {
+	rates := []Rate{
+		{Id: 1, Category: 1, RatePerKm: 1.85, ValidFrom: date("2020-01-01"), ValidTo: sql.NullTime{Time: date("2022-12-31"), Valid: true}},
+		{Id: 2, Category: 1, RatePerKm: 2.5, ValidFrom: date("2023-01-01")},
+		// overlaps the first rate; the later start takes precedence:
+		{Id: 3, Category: 1, RatePerKm: 2, ValidFrom: date("2021-07-01"), ValidTo: sql.NullTime{Time: date("2021-07-31"), Valid: true}},
+	}
+
+	table := reimbursementTable{
+		rates:       rates,
+		categories:  map[int]Category{1: {Id: 1, Deductible: true}, 2: {Id: 2}},
+		defaultRate: 3,
+	}
+
+	business := sql.NullInt32{Int32: 1, Valid: true}
+
+	tests := []struct {
+		start    string
+		expected float64
+	}{
+		{"2019-12-31T12:00:00Z", 3},
+		{"2020-01-01T12:00:00Z", 1.85},
+		{"2021-07-15T12:00:00Z", 2},
+		{"2021-08-01T12:00:00Z", 1.85},
+		{"2022-12-31T12:00:00Z", 1.85},
+		// past midnight in Sweden on New Year's Eve:
+		{"2022-12-31T23:30:00Z", 2.5},
+		{"2030-06-01T12:00:00Z", 2.5},
+	}
+
+	for _, test := range tests {
+		start, _ := time.Parse(time.RFC3339, test.start)
+		if rate := table.rate(business, start); rate != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.start, test.expected, rate)
+		}
+	}
+
+	if rate := table.rate(sql.NullInt32{Int32: 2, Valid: true}, date("2021-01-01")); rate != 0 {
+		t.Errorf("Expected no rate for private drives, got %v", rate)
+	}
+
+	if rate := table.rate(sql.NullInt32{}, date("2021-01-01")); rate != 0 {
+		t.Errorf("Expected no rate for unclassified drives, got %v", rate)
+	}
+}
+
+func TestMileageAllowanceRounding(t *testing.T) {
+	if a := mileageAllowance(12.3, 1.85); a != 22.76 {
+		t.Errorf("Expected 22.76, got %v", a)
+	}
+}
+
+func TestReimbursementInTotals(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	_, err := store.CreateRate(Rate{Category: 1, RatePerKm: 1.85, ValidFrom: date("2021-03-01")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// drive 1 in February is paid the default 2.50 kr/km, drive 2 in March 1.85 kr/km:
+	totals, err := getTotals(2021, 0, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if totals.Reimbursement != 87 {
+		t.Errorf("Expected 87 kr for the year, got %v", totals.Reimbursement)
+	}
+
+	totals, _ = getTotals(2021, 3, 1, 0)
+	if totals.Reimbursement != 37 {
+		t.Errorf("Expected 37 kr for March, got %v", totals.Reimbursement)
+	}
+
+	drive, _, err := getDriveById(2, defaultLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if drive.Reimbursement != 37 || drive.ReimbursementString != "37.00" {
+		t.Errorf("Expected 37.00 kr for drive 2, got %s", drive.ReimbursementString)
+	}
+
+	data := generateMain(2021, 3, 1, 0, defaultLocale)
+	if data.TotalReimbursementString != "37.00" {
+		t.Errorf("Expected 37.00 kr in the monthly view, got %s", data.TotalReimbursementString)
+	}
+}
+
+func TestRateHandlers(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	invalid := []url.Values{
+		{"category": {"9"}, "rate": {"2,5"}, "validfrom": {"2021-01-01"}},
+		{"category": {"1"}, "rate": {"x"}, "validfrom": {"2021-01-01"}},
+		{"category": {"1"}, "rate": {"-1"}, "validfrom": {"2021-01-01"}},
+		{"category": {"1"}, "rate": {"2,5"}},
+		{"category": {"1"}, "rate": {"2,5"}, "validfrom": {"2021-01-01"}, "validto": {"2020-12-31"}},
+	}
+
+	for _, values := range invalid {
+		if status := postForm(t, "/rates", values).Code; status != http.StatusBadRequest {
+			t.Errorf("%v: expected status 400, got %d", values, status)
+		}
+	}
+
+	if status := postForm(t, "/rates", url.Values{"category": {"1"}, "rate": {"2,5"}, "validfrom": {"2023-01-01"}}).Code; status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+
+	rates, _ := store.GetRates()
+	if len(rates) != 1 || rates[0].RatePerKm != 2.5 || rates[0].ValidTo.Valid {
+		t.Errorf("Unexpected rates %+v", rates)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/settings/rates", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `value="2023-01-01"`) {
+		t.Errorf("Expected the rate on the rates page, got status %d", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodDelete, "/rates/1", nil)
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", w.Code)
+	}
+}
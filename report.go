@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// the columns of the tables of drives in the report, in mm; they fill a landscape A4 page
+// with 10 mm margins. The headers are keys of the message catalogs:
+var reportColumns = []struct {
+	header string
+	width  float64
+	align  string
+}{
+	{"start", 14, "C"},
+	{"end", 14, "C"},
+	{"from", 50, "L"},
+	{"to", 50, "L"},
+	{"odometer_start_short", 22, "R"},
+	{"odometer_end_short", 22, "R"},
+	{"km", 16, "R"},
+	{"classification", 28, "L"},
+	{"comment", 61, "L"},
+}
+
+const reportRowHeight = 6
+
+// fitText shortens s until it fits in a cell of the given width.
+func fitText(pdf *gofpdf.Fpdf, s string, width float64) string {
+	if pdf.GetStringWidth(s) <= width-2 {
+		return s
+	}
+
+	runes := []rune(s)
+	for len(runes) > 0 && pdf.GetStringWidth(string(runes)+"...") > width-2 {
+		runes = runes[:len(runes)-1]
+	}
+
+	return string(runes) + "..."
+}
+
+func reportRow(row exportRow) []string {
+	return []string{
+		row.StartTime,
+		row.EndTime,
+		row.StartAddress,
+		row.EndAddress,
+		strconv.Itoa(row.StartOdometer),
+		strconv.Itoa(row.EndOdometer),
+		fmt.Sprintf("%.1f", row.Distance),
+		row.ClassificationString,
+		row.Comment,
+	}
+}
+
+// writeReport renders the month of data as a printable driving journal in the language lang:
+// a header, a table of the drives of each day, the totals of the month and a line for the
+// signature of the driver. The header names the driver if the journal is of one driver.
+func writeReport(out io.Writer, data MainData, car Car, owner, lang string) error {
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	pdf.SetMargins(10, 10, 10)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("")
+	pdf.SetTitle(translate(lang, "journal"), true)
+
+	// the core fonts use code page 1252, which covers Swedish:
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	t := func(key string, args ...interface{}) string {
+		return tr(translate(lang, key, args...))
+	}
+
+	period := fmt.Sprintf("%s %d", data.DropdownMonths[data.Month-1].Name, data.Year)
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 8, t("journal")+tr(fmt.Sprintf(" %s, Tesla Model %s (%s)", period, car.Model, car.Name)), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 8, t("page_of", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, t("journal"), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+
+	if owner == "" {
+		owner = "______________________________"
+	}
+
+	lines := [][2]string{
+		{"car", fmt.Sprintf("Tesla Model %s (%s)", car.Model, car.Name)},
+		{"owner", owner},
+		{"period", period},
+		{"printed", convertTime(time.Now()).Format("2006-01-02")},
+	}
+
+	for _, d := range data.Drivers {
+		if d.Id == data.DriverId {
+			lines = append(lines, [2]string{"driver", d.Name})
+		}
+	}
+
+	pdf.SetFont("Helvetica", "", 11)
+	for _, line := range lines {
+		pdf.CellFormat(25, 6, t(line[0])+":", "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 6, tr(line[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	_, pageHeight := pdf.GetPageSize()
+
+	// the days are listed latest first on screen, but a journal reads from the start of the month:
+	for i := len(data.Days) - 1; i >= 0; i-- {
+		day := data.Days[i]
+		rows := journalRows(day.Drives, day.GroupedDrives, true)
+
+		// keep the date together with the header and first drive of its table:
+		if pdf.GetY()+8+3*reportRowHeight > pageHeight-15 {
+			pdf.AddPage()
+		}
+
+		pdf.SetFont("Helvetica", "B", 11)
+		pdf.CellFormat(0, 8, tr(day.DateString), "", 1, "L", false, 0, "")
+
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFillColor(230, 230, 230)
+		for _, c := range reportColumns {
+			label := t(c.header)
+			if c.header == "km" {
+				label = t(data.Unit)
+			}
+
+			pdf.CellFormat(c.width, reportRowHeight, label, "1", 0, c.align, true, 0, "")
+		}
+		pdf.Ln(-1)
+
+		pdf.SetFont("Helvetica", "", 9)
+		for _, row := range rows {
+			for j, value := range reportRow(row) {
+				c := reportColumns[j]
+				pdf.CellFormat(c.width, reportRowHeight, fitText(pdf, tr(value), c.width), "1", 0, c.align, false, 0, "")
+			}
+			pdf.Ln(-1)
+		}
+
+		pdf.Ln(3)
+	}
+
+	if len(data.Days) == 0 {
+		pdf.SetFont("Helvetica", "I", 11)
+		pdf.CellFormat(0, 8, t("no_drives"), "", 1, "L", false, 0, "")
+	}
+
+	// the totals and the signature are kept on the same page:
+	if pdf.GetY()+70 > pageHeight-15 {
+		pdf.AddPage()
+	}
+
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(0, 8, t("summary"), "", 1, "L", false, 0, "")
+
+	totals := [][3]string{
+		{"total_distance", data.TotalDistanceString + " " + data.Unit, data.TotalDurationString},
+		{"of_which_business", data.TotalBusinessDistanceString + " " + data.Unit, data.TotalBusinessDurationString},
+		{"of_which_private", data.TotalPrivateDistanceString + " " + data.Unit, data.TotalPrivateDurationString},
+		{"reimbursement", data.TotalReimbursementString + " kr", ""},
+	}
+	if data.UnclassifiedDrivesRemaining {
+		totals = append(totals, [3]string{"unclassified", data.UnclassifiedDistanceString + " " + data.Unit, data.UnclassifiedDurationString})
+	}
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, total := range totals {
+		pdf.CellFormat(50, 6, t(total[0])+":", "", 0, "L", false, 0, "")
+		pdf.CellFormat(30, 6, tr(total[1]), "", 0, "R", false, 0, "")
+		pdf.CellFormat(30, 6, tr(total[2]), "", 1, "R", false, 0, "")
+	}
+
+	pdf.Ln(20)
+
+	x, y := pdf.GetXY()
+	for i, label := range []string{"signature", "name_clarification", "date"} {
+		left := x + float64(i)*90
+		pdf.Line(left, y, left+80, y)
+		pdf.SetXY(left, y+1)
+		pdf.CellFormat(80, 5, t(label), "", 0, "L", false, 0, "")
+	}
+
+	if pdf.Err() {
+		return pdf.Error()
+	}
+
+	return pdf.Output(out)
+}
+
+func reportHandler(w http.ResponseWriter, r *http.Request) {
+	req, status, err := carYearRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	vars := mux.Vars(r)
+	month, err := strconv.Atoi(vars["month"])
+	if err != nil || month < 1 || month > 12 {
+		http.Error(w, "Invalid month: "+strconv.Quote(vars["month"]), http.StatusBadRequest)
+		return
+	}
+
+	lang := requestLocale(r)
+	data := generateMain(req.Year, month, req.Car.Id, req.Driver.Id, lang)
+
+	var buf bytes.Buffer
+	err = writeReport(&buf, data, req.Car, config.Report.Owner, lang)
+	if err != nil {
+		log.Println("Error generating report: " + err.Error())
+		http.Error(w, "Error generating report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"korjournal-%d-%d-%02d.pdf\"", req.Car.Id, req.Year, month))
+	w.Write(buf.Bytes())
+}
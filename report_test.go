@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReportHandler(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+	config.Report.Owner = "Åsa Öberg"
+
+	r := httptest.NewRequest(http.MethodGet, "/report/1/2021/3.pdf", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("Content-Type") != "application/pdf" {
+		t.Errorf("Unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+
+	body := w.Body.Bytes()
+	if !bytes.HasPrefix(body, []byte("%PDF-")) || !bytes.Contains(body[len(body)-10:], []byte("%%EOF")) {
+		t.Error("Expected a complete PDF document")
+	}
+}
+
+func TestReportOfEmptyMonth(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/report/2/2020/1.pdf", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || !bytes.HasPrefix(w.Body.Bytes(), []byte("%PDF-")) {
+		t.Errorf("Expected a PDF for a month without drives, got status %d", w.Code)
+	}
+}
+
+func TestReportRejectsInvalidPaths(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	tests := map[string]int{
+		"/report/0/2021/3.pdf":          http.StatusBadRequest,
+		"/report/1/2021/13.pdf":         http.StatusBadRequest,
+		"/report/1/x/3.pdf":             http.StatusBadRequest,
+		"/report/1%20OR%201/2021/3.pdf": http.StatusBadRequest,
+		"/report/9/2021/3.pdf":          http.StatusNotFound,
+	}
+
+	for path, status := range tests {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+
+		if w.Code != status {
+			t.Errorf("GET %s: expected status %d, got %d", path, status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// the roles of users:
+const (
+	// owners may do anything:
+	roleOwner = "owner"
+	// drivers see the journals of the cars they drive, and may only change their own drives:
+	roleDriver = "driver"
+	// accountants see and export the journals of all cars, but can't change anything:
+	roleAccountant = "accountant"
+)
+
+var allRoles = []string{roleOwner, roleDriver, roleAccountant}
+
+const accessKey contextKey = "access"
+
+var (
+	errNotAllowed  = errors.New("Not allowed")
+	errNotOwnDrive = errors.New("Drivers may only change their own drives")
+)
+
+func isRole(role string) bool {
+	return hasRole(allRoles, role)
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// access is what the user of a request may do.
+type access struct {
+	Role string
+	// Drivers are the drivers the user is, if their role is driver:
+	Drivers []Driver
+}
+
+// currentAccess returns what the user of a request let through by authorize may do. Without
+// logging in, anyone may do anything.
+func currentAccess(r *http.Request) access {
+	if a, ok := r.Context().Value(accessKey).(access); ok {
+		return a
+	}
+
+	return access{Role: roleOwner}
+}
+
+// canEdit tells whether the user may change drives at all; drivers only their own.
+func (a access) canEdit() bool {
+	return a.Role == roleOwner || a.Role == roleDriver
+}
+
+// canManage tells whether the user may change what concerns all drives, like rules and closed months.
+func (a access) canManage() bool {
+	return a.Role == roleOwner
+}
+
+func (a access) canSeeCar(carId int) bool {
+	if a.Role != roleDriver {
+		return true
+	}
+
+	for _, d := range a.Drivers {
+		if d.CarId == carId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// visibleCars returns the cars whose journals the user may see.
+func (a access) visibleCars(cars []Car) []Car {
+	var visible []Car
+	for _, c := range cars {
+		if a.canSeeCar(c.Id) {
+			visible = append(visible, c)
+		}
+	}
+
+	return visible
+}
+
+// isOwnDrive tells whether a drive with the driver may be changed by the user.
+func (a access) isOwnDrive(driverId sql.NullInt32) bool {
+	if a.Role != roleDriver {
+		return a.canEdit()
+	}
+
+	for _, d := range a.Drivers {
+		if driverId.Valid && int(driverId.Int32) == d.Id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// driverActions are the actions of postAction that drivers may take on their own drives:
+var driverActions = []string{"classify", "comment", "group", "ungroup"}
+
+// checkAction makes sure the user may take the action on the drives and grouped drives, which
+// for a driver means that it must be one of driverActions and all of the drives must be theirs.
+func checkAction(a access, action string, driveIds []int64, groupIds []int64) error {
+	if a.Role == roleOwner {
+		return nil
+	}
+
+	if a.Role != roleDriver || !hasRole(driverActions, action) {
+		return fmt.Errorf("%w: the role %s may not %s", errNotAllowed, a.Role, action)
+	}
+
+	members, err := store.GetDriveIdsForGroups(groupIds)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range append(append([]int64{}, driveIds...), members...) {
+		d, err := store.GetDriveById(int(id))
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !a.isOwnDrive(d.DriverId) {
+			return fmt.Errorf("%w: drive %d", errNotOwnDrive, d.Id)
+		}
+	}
+
+	return nil
+}
+
+// routeRoles lists the roles that may use a route, by method and path template. Routes that
+// aren't listed may be read by every role, while changes are left to owners.
+var routeRoles = map[string][]string{
+	"POST /":                      allRoles,
+	"POST /logout":                allRoles,
+	"POST /locale":                allRoles,
+	"POST /action":                {roleOwner, roleDriver},
+	"PATCH /api/v1/drives/{id}":   {roleOwner, roleDriver},
+	"POST /api/v1/groups":         {roleOwner, roleDriver},
+	"DELETE /api/v1/groups/{id}":  {roleOwner, roleDriver},
+	"GET /settings/rates":         {roleOwner},
+	"GET /settings/drivers/{car}": {roleOwner},
+}
+
+func allowedRoles(method, template string) []string {
+	if roles, listed := routeRoles[method+" "+template]; listed {
+		return roles
+	}
+
+	if isSafeMethod(method) {
+		return allRoles
+	}
+
+	return []string{roleOwner}
+}
+
+// requestCar returns the car a request concerns, if it tells. A car that isn't a valid id is
+// returned as 0, which no one drives; the handlers reject it anyway.
+func requestCar(r *http.Request, template string) (int, bool, error) {
+	vars := mux.Vars(r)
+	if s, ok := vars["car"]; ok {
+		car, _ := parseId(s)
+		return int(car), true, nil
+	}
+
+	switch template {
+	case "/details/{id}", "/drive/{id}", "/history/drive/{id}", "/api/v1/drives/{id}":
+		id, err := parseId(vars["id"])
+		if err != nil {
+			return 0, true, nil
+		}
+
+		d, err := store.GetDriveById(int(id))
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+
+		return d.CarId, true, err
+	case "/groupdetails/{id}", "/drive/group/{id}", "/history/group/{id}", "/api/v1/groups/{id}":
+		id, err := parseId(vars["id"])
+		if err != nil {
+			return 0, true, nil
+		}
+
+		gd, err := store.GetGroupedDrivesById(int(id))
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+
+		return gd.CarId, true, err
+	}
+
+	if s := r.FormValue("car"); s != "" {
+		car, _ := parseId(s)
+		return int(car), true, nil
+	}
+
+	return 0, false, nil
+}
+
+// authorize refuses the requests that the role of the user doesn't allow, as well as requests
+// by drivers concerning cars they don't drive. It runs after authenticate, and lets the handlers
+// know what the user may do through currentAccess.
+func authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := currentSession(r)
+		if !ok {
+			// a public page:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		a := access{Role: session.Role}
+		if a.Role == roleDriver {
+			drivers, err := store.GetDriversOfUser(session.UserId)
+			if err != nil {
+				log.Println("Error retrieving drivers of user: " + err.Error())
+				writeError(w, r, http.StatusInternalServerError, "access_not_checked", err)
+				return
+			}
+
+			a.Drivers = drivers
+		}
+
+		var template string
+		if route := mux.CurrentRoute(r); route != nil {
+			template, _ = route.GetPathTemplate()
+		}
+
+		if !hasRole(allowedRoles(r.Method, template), a.Role) {
+			writeError(w, r, http.StatusForbidden, "not_allowed", fmt.Errorf("The role %s may not %s %s", a.Role, r.Method, template))
+			return
+		}
+
+		car, known, err := requestCar(r, template)
+		if err != nil {
+			log.Println("Error retrieving the car of a request: " + err.Error())
+			writeError(w, r, http.StatusInternalServerError, "access_not_checked", err)
+			return
+		}
+
+		if known && !a.canSeeCar(car) {
+			writeError(w, r, http.StatusForbidden, "car_not_allowed", fmt.Errorf("%s doesn't drive car %d", session.Username, car))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accessKey, a)))
+	})
+}
+
+// homeCar returns the car shown when none is chosen: the first car the user drives, if they are
+// a driver, otherwise car 1.
+func homeCar(a access) int {
+	if a.Role == roleDriver && len(a.Drivers) > 0 {
+		return a.Drivers[0].CarId
+	}
+
+	return 1
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// addUser adds a user with the role and the same password as anna of useAuth.
+func addUser(t *testing.T, username, role string) User {
+	t.Helper()
+
+	hash, err := hashPassword("hemligt lösenord")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := store.CreateUser(User{Username: username, PasswordHash: hash, Role: role})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func actionRequest(form url.Values, csrf string) *http.Request {
+	form.Set("csrf", csrf)
+
+	r := httptest.NewRequest(http.MethodPost, "/action", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return r
+}
+
+func TestDriverRole(t *testing.T) {
+	s := useFixtures(t)
+	useAuth(t)
+	user := addUser(t, "bertil", roleDriver)
+	anna, bertil, _ := useDrivers(t)
+
+	bertil.UserId = sql.NullInt32{Int32: int32(user.Id), Valid: true}
+	store.UpdateDriver(bertil)
+	assignDriver(store, bertil.Id, []int64{3}, []int64{}, "test")
+	assignDriver(store, anna.Id, []int64{5}, []int64{}, "test")
+
+	cookies := login(t, "bertil", "hemligt lösenord")
+	csrf := cookie(cookies, csrfCookie)
+
+	form := url.Values{"action": {"classify"}, "classification": {"1"}, "drive": {"3"}, "year": {"2021"}, "month": {"3"}, "car": {"1"}}
+	w := serve(actionRequest(form, csrf), cookies)
+	if w.Code != http.StatusOK || s.classifications[3].Classification != 1 {
+		t.Errorf("Expected a driver to classify their own drive, got %d", w.Code)
+	}
+
+	// the drives of others, and groups containing them, are refused:
+	form.Set("drive", "5")
+	w = serve(actionRequest(form, csrf), cookies)
+	if w.Code != http.StatusForbidden || s.classifications[5].Classification != 0 {
+		t.Errorf("Expected the drive of another driver to be refused, got %d", w.Code)
+	}
+
+	groupDrives(store, 1, []int64{3, 5}, "test")
+	form.Del("drive")
+	form.Set("groupeddrive", "1")
+	w = serve(actionRequest(form, csrf), cookies)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected a group with the drive of another driver to be refused, got %d", w.Code)
+	}
+
+	form = url.Values{"action": {"closemonth"}, "year": {"2021"}, "month": {"3"}, "car": {"1"}}
+	w = serve(actionRequest(form, csrf), cookies)
+	if w.Code != http.StatusForbidden || len(s.closedMonths) != 0 {
+		t.Errorf("Expected a driver not to close months, got %d", w.Code)
+	}
+
+	// the same goes for the API:
+	r := httptest.NewRequest(http.MethodPatch, "/api/v1/drives/5", strings.NewReader(`{"comment": "Min"}`))
+	r.Header.Set(csrfHeader, csrf)
+	w = serve(r, cookies)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected the API to refuse the drive of another driver, got %d", w.Code)
+	}
+
+	// other cars are out of sight:
+	for _, path := range []string{"/drive/7", "/details/7", "/export?car=2&year=2021", "/annual/2/2021", "/year/2/2021", "/?car=2", "/drivers/2", "/api/v1/drives?car=2", "/api/v1/drives/7"} {
+		w = serve(httptest.NewRequest(http.MethodGet, path, nil), cookies)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected %s to be refused, got %d", path, w.Code)
+		}
+	}
+
+	w = serve(httptest.NewRequest(http.MethodGet, "/", nil), cookies)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `id="btn_group"`) || strings.Contains(body, `id="btn_rules"`) || strings.Contains(body, `value="2"`+">Tesla") {
+		t.Errorf("Expected the journal of car 1 without the buttons of owners, got %d", w.Code)
+	}
+}
+
+func TestAccountantRole(t *testing.T) {
+	s := useFixtures(t)
+	useAuth(t)
+	addUser(t, "cecilia", roleAccountant)
+
+	cookies := login(t, "cecilia", "hemligt lösenord")
+	csrf := cookie(cookies, csrfCookie)
+
+	for _, path := range []string{"/", "/drive/7", "/export?car=2&year=2021", "/annual/2/2021", "/rules", "/closedmonths/1"} {
+		w := serve(httptest.NewRequest(http.MethodGet, path, nil), cookies)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected an accountant to see %s, got %d", path, w.Code)
+		}
+	}
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/", nil), cookies)
+	if strings.Contains(w.Body.String(), `id="btn_group"`) || strings.Contains(w.Body.String(), `id="btn_close"`) {
+		t.Error("Expected no buttons for changing drives")
+	}
+
+	w = serve(classifyRequest(csrf), cookies)
+	if w.Code != http.StatusForbidden || s.classifications[3].Classification != 0 {
+		t.Errorf("Expected an accountant not to classify drives, got %d", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/rules", strings.NewReader(url.Values{"classification": {"1"}, "csrf": {csrf}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = serve(r, cookies)
+	if w.Code != http.StatusForbidden || len(s.rules) != 0 {
+		t.Errorf("Expected an accountant not to add rules, got %d", w.Code)
+	}
+
+	w = serve(httptest.NewRequest(http.MethodGet, "/settings/rates", nil), cookies)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected the settings to be left to owners, got %d", w.Code)
+	}
+
+	// the owner may do all of it:
+	cookies = login(t, "anna", "hemligt lösenord")
+	w = serve(classifyRequest(cookie(cookies, csrfCookie)), cookies)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the owner to classify drives, got %d", w.Code)
+	}
+}
+
+func TestUserRoleCommand(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+
+	var out bytes.Buffer
+	err := runUserCommand([]string{"add", "bertil", "driver"}, strings.NewReader("hemligt lösenord\n"), &out)
+	if err != nil || s.users[0].Role != roleDriver {
+		t.Fatalf("Expected bertil to be added as a driver, got %v", err)
+	}
+
+	if runUserCommand([]string{"add", "cecilia", "chef"}, strings.NewReader("hemligt lösenord\n"), &out) == nil {
+		t.Error("Expected an unknown role to be refused")
+	}
+
+	err = runUserCommand([]string{"role", "bertil", "accountant"}, nil, &out)
+	if err != nil || s.users[0].Role != roleAccountant {
+		t.Errorf("Expected bertil to become an accountant, got %v", err)
+	}
+
+	if runUserCommand([]string{"role", "bertil"}, nil, &out) == nil {
+		t.Error("Expected a missing role to be refused")
+	}
+
+	// drivers are linked to their users on the drivers page:
+	w := postForm(t, "/drivers/1", url.Values{"name": {"Bertil"}, "user": {"bertil"}})
+	drivers, _ := store.GetDriversOfUser(s.users[0].Id)
+	if w.Code != http.StatusCreated || len(drivers) != 1 || drivers[0].Name != "Bertil" {
+		t.Errorf("Expected the driver to be linked to bertil, got %d %v", w.Code, drivers)
+	}
+
+	w = postForm(t, "/drivers/1", url.Values{"name": {"Doris"}, "user": {"doris"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown user to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Matches tells whether all conditions set on the rule are fulfilled by the drive.
+func (rule Rule) Matches(drive Drive) bool {
+	if rule.StartGeofenceId.Valid && (!drive.StartGeofenceId.Valid || drive.StartGeofenceId.Int32 != rule.StartGeofenceId.Int32) {
+		return false
+	}
+
+	if rule.EndGeofenceId.Valid && (!drive.EndGeofenceId.Valid || drive.EndGeofenceId.Int32 != rule.EndGeofenceId.Int32) {
+		return false
+	}
+
+	if !strings.Contains(strings.ToLower(drive.StartAddress), strings.ToLower(rule.StartAddress)) {
+		return false
+	}
+
+	if !strings.Contains(strings.ToLower(drive.EndAddress), strings.ToLower(rule.EndAddress)) {
+		return false
+	}
+
+	start := convertTime(drive.StartDate)
+
+	if len(rule.Weekdays) > 0 {
+		found := false
+		for _, wd := range rule.Weekdays {
+			if time.Weekday(wd) == start.Weekday() {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	minute := int32(start.Hour()*60 + start.Minute())
+	if rule.TimeFrom.Valid && rule.TimeTo.Valid && rule.TimeFrom.Int32 > rule.TimeTo.Int32 {
+		// the window spans midnight, e.g. 22:00 - 02:00:
+		if minute < rule.TimeFrom.Int32 && minute > rule.TimeTo.Int32 {
+			return false
+		}
+	} else {
+		if rule.TimeFrom.Valid && minute < rule.TimeFrom.Int32 {
+			return false
+		}
+
+		if rule.TimeTo.Valid && minute > rule.TimeTo.Int32 {
+			return false
+		}
+	}
+
+	if rule.MinDistance.Valid && float64(drive.Distance) < rule.MinDistance.Float64 {
+		return false
+	}
+
+	if rule.MaxDistance.Valid && float64(drive.Distance) > rule.MaxDistance.Float64 {
+		return false
+	}
+
+	return true
+}
+
+// applyRules classifies the drives that match a rule. Drives that have been classified by hand
+// are never touched; drives classified by a rule are only reclassified if reapply is set.
+// Grouped drives are skipped since they are classified through their group, as are drives
+// in closed months. The changes are logged as made by user. Returns the number of drives that
+// were classified.
+func applyRules(s JournalStore, carId int, drives []Drive, reapply bool, user string) (int, error) {
+	rules, err := s.GetRules(carId)
+	if err != nil || len(rules) == 0 {
+		return 0, err
+	}
+
+	closedMonths, err := s.GetClosedMonths(carId)
+	if err != nil {
+		return 0, err
+	}
+
+	closed := make(map[time.Time]bool)
+	for _, m := range closedMonths {
+		closed[monthStart(m)] = true
+	}
+
+	classified := 0
+	for _, drive := range drives {
+		if drive.GroupId.Valid || closed[monthStart(drive.StartDate)] {
+			continue
+		}
+
+		if drive.Classification.Valid && !(reapply && drive.RuleId.Valid) {
+			continue
+		}
+
+		for _, rule := range rules {
+			if !rule.Matches(drive) {
+				continue
+			}
+
+			// the comment of the rule is only used if the drive has none:
+			if drive.Comment.Valid {
+				rule.Comment = ""
+			}
+
+			err = s.ClassifyByRule(drive.Id, rule)
+			if err != nil {
+				return classified, err
+			}
+
+			err = audit(s, user, ruleChanges(drive, rule))
+			if err != nil {
+				return classified, err
+			}
+
+			classified++
+			break
+		}
+	}
+
+	return classified, nil
+}
+
+func reapplyRules(s JournalStore, carId int, from, to time.Time, user string) (*time.Time, *time.Time, error) {
+	drives, err := s.GetDrives(carId, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	classified, err := applyRules(s, carId, drives, true, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("Classified %d drives using rules\n", classified)
+
+	return &from, &to, nil
+}
+
+// runRulesCommand implements the applyrules subcommand, classifying the drives of the period
+// that haven't been classified yet, e.g. after TeslaMate has logged new drives.
+func runRulesCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("applyrules", flag.ContinueOnError)
+	car := flags.Int("car", 0, "id of the car; all cars if left out")
+	year := flags.Int("year", convertTime(time.Now()).Year(), "year of the drives")
+	month := flags.Int("month", 0, "month of the drives; the whole year if left out")
+	from := flags.String("from", "", "first date (YYYY-MM-DD), instead of year and month")
+	to := flags.String("to", "", "last date (YYYY-MM-DD)")
+	reapply := flags.Bool("reapply", false, "also reclassify drives classified by earlier rules")
+	user := flags.String("user", os.Getenv("USER"), "who to log the changes as")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(*user) == "" {
+		return errors.New("No user to log the changes as; use -user")
+	}
+
+	start, end, err := exportPeriod(*year, *month, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	carIds := []int{*car}
+	if *car == 0 {
+		cars, err := getCars()
+		if err != nil {
+			return err
+		}
+
+		carIds = nil
+		for _, c := range cars {
+			carIds = append(carIds, c.Id)
+		}
+	}
+
+	for _, carId := range carIds {
+		var classified int
+		err = store.Transaction(func(tx JournalStore) error {
+			drives, err := tx.GetDrives(carId, start, end)
+			if err != nil {
+				return err
+			}
+
+			classified, err = applyRules(tx, carId, drives, *reapply, *user)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Classified %d drives of car %d\n", classified, carId)
+	}
+
+	return nil
+}
+
+func getNullIntParamPost(r *http.Request, param string, into *sql.NullInt32) error {
+	s := strings.TrimSpace(r.Form.Get(param))
+	if s == "" {
+		*into = sql.NullInt32{}
+		return nil
+	}
+
+	val, err := strconv.Atoi(s)
+	if err != nil {
+		return errors.New("Invalid value for " + param + ": " + s)
+	}
+
+	*into = sql.NullInt32{Int32: int32(val), Valid: true}
+	return nil
+}
+
+func getNullFloatParamPost(r *http.Request, param string, into *sql.NullFloat64) error {
+	s := strings.TrimSpace(r.Form.Get(param))
+	if s == "" {
+		*into = sql.NullFloat64{}
+		return nil
+	}
+
+	val, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return errors.New("Invalid value for " + param + ": " + s)
+	}
+
+	*into = sql.NullFloat64{Float64: val, Valid: true}
+	return nil
+}
+
+// getTimeOfDayParamPost parses a time of day given as HH:MM into minutes after midnight.
+func getTimeOfDayParamPost(r *http.Request, param string, into *sql.NullInt32) error {
+	s := strings.TrimSpace(r.Form.Get(param))
+	if s == "" {
+		*into = sql.NullInt32{}
+		return nil
+	}
+
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return errors.New("Invalid time of day for " + param + ": " + s)
+	}
+
+	*into = sql.NullInt32{Int32: int32(t.Hour()*60 + t.Minute()), Valid: true}
+	return nil
+}
+
+func parseRuleForm(r *http.Request) (Rule, error) {
+	var rule Rule
+
+	err := r.ParseForm()
+	if err != nil {
+		return rule, err
+	}
+
+	for _, parse := range []func() error{
+		func() error { return getNullIntParamPost(r, "car", &rule.CarId) },
+		func() error { return getNullIntParamPost(r, "startgeofence", &rule.StartGeofenceId) },
+		func() error { return getNullIntParamPost(r, "endgeofence", &rule.EndGeofenceId) },
+		func() error { return getTimeOfDayParamPost(r, "timefrom", &rule.TimeFrom) },
+		func() error { return getTimeOfDayParamPost(r, "timeto", &rule.TimeTo) },
+		func() error { return getNullFloatParamPost(r, "mindistance", &rule.MinDistance) },
+		func() error { return getNullFloatParamPost(r, "maxdistance", &rule.MaxDistance) },
+	} {
+		err = parse()
+		if err != nil {
+			return rule, err
+		}
+	}
+
+	getIntParamPost(r, "priority", &rule.Priority)
+	rule.StartAddress = strings.TrimSpace(r.Form.Get("startaddress"))
+	rule.EndAddress = strings.TrimSpace(r.Form.Get("endaddress"))
+	rule.Comment = strings.TrimSpace(r.Form.Get("comment"))
+
+	rule.Weekdays = pq.Int64Array{}
+	for _, s := range r.Form["weekday"] {
+		wd, err := strconv.Atoi(s)
+		if err != nil || wd < 0 || wd > 6 {
+			return rule, errors.New("Invalid weekday: " + s + " (0 = Sunday, 6 = Saturday)")
+		}
+
+		rule.Weekdays = append(rule.Weekdays, int64(wd))
+	}
+
+	err = getIntParamPost(r, "classification", &rule.Classification)
+	if err != nil {
+		return rule, errors.New("A rule needs a classification")
+	}
+
+	categories, err := getCategoryMap()
+	if err != nil {
+		return rule, err
+	}
+
+	if _, exists := categories[rule.Classification]; !exists {
+		return rule, errors.New("Unknown classification: " + r.Form.Get("classification"))
+	}
+
+	return rule, nil
+}
+
+func getRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := store.GetAllRules()
+	if err != nil {
+		log.Println("Error retrieving rules: " + err.Error())
+		http.Error(w, "Error retrieving rules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rules)
+}
+
+func postRule(w http.ResponseWriter, r *http.Request) {
+	rule, err := parseRuleForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule, err = store.CreateRule(rule)
+	if err != nil {
+		log.Println("Error creating rule: " + err.Error())
+		http.Error(w, "Error creating rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func putRule(w http.ResponseWriter, r *http.Request) {
+	id, err := getIdVar(r)
+	if err != nil {
+		http.Error(w, "Invalid rule id", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := parseRuleForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.Id = id
+
+	err = store.UpdateRule(rule)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Println("Error updating rule: " + err.Error())
+		http.Error(w, "Error updating rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func removeRule(w http.ResponseWriter, r *http.Request) {
+	id, err := getIdVar(r)
+	if err != nil {
+		http.Error(w, "Invalid rule id", http.StatusBadRequest)
+		return
+	}
+
+	err = store.DeleteRule(id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Printf("Error deleting rule %d: %s\n", id, err.Error())
+		http.Error(w, "Error deleting rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
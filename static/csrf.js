@@ -0,0 +1,15 @@
+// Changes must carry the CSRF token of the session when logging in is required. The token is
+// read from its cookie and sent in a header by scripts and in a hidden field by forms.
+var csrfToken = (document.cookie.match(/(?:^|;\s*)tj_csrf=([^;]*)/) || [])[1];
+
+if (csrfToken) {
+    $.ajaxSetup({
+        headers: { "X-CSRF-Token": decodeURIComponent(csrfToken) }
+    });
+
+    $(document).ready(function() {
+        $("form[method=post]").each(function() {
+            $("<input>", { type: "hidden", name: "csrf", value: decodeURIComponent(csrfToken) }).appendTo(this);
+        });
+    });
+}
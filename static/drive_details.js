@@ -4,10 +4,52 @@ $(document).ready(function() {
             var json = JSON.parse(data);
 
             makeMap(JSON.stringify(json.MapData));
-            populateDetails(json.Drives);
+            if (group) {
+                populateDetails(json.Drives, json.Drives.Comment.String, json.Unit);
+            } else {
+                populateDetails(json.Drive, json.Comment, json.Unit);
+            }
         }
     );
     
+    var actions = {
+        "classify": t("classification"),
+        "comment": t("comment"),
+        "group": t("grouping"),
+        "ungroup": t("ungrouping"),
+        "assign": t("driver")
+    };
+
+    $.get("/categories", function(categories) {
+        var labels = {};
+        $.each(categories, function(i, c) {
+            labels[c.Id] = c.Label;
+        });
+
+        $.get("/history/" + (group ? "group/" : "drive/") + id, function(history) {
+            populateHistory(history || [], labels);
+        });
+    });
+
+    function populateHistory(history, labels) {
+        $.each(history, function(i, entry) {
+            var oldValue = entry.OldValue;
+            var newValue = entry.NewValue;
+            if (entry.Action == "classify") {
+                oldValue = labels[oldValue] || oldValue;
+                newValue = labels[newValue] || newValue;
+            }
+
+            var row = $("<tr>");
+            row.append($("<td>").text(new Date(entry.Time).toLocaleString(t("locale_tag"))));
+            row.append($("<td>").text(entry.User || t("rule")));
+            row.append($("<td>").text(actions[entry.Action] || entry.Action));
+            row.append($("<td>").text(oldValue));
+            row.append($("<td>").text(newValue));
+            $("#history").append(row);
+        });
+    }
+
     function makeMap(mapData) {
         var map = L.map('map');
 
@@ -19,10 +61,38 @@ $(document).ready(function() {
         }).addTo(map);
     }
 
-    function populateDetails(drives) {
+    function populateDetails(drives, comment, unit) {
         $("#odometer_start").html(drives.StartOdometer);
         $("#odometer_end").html(drives.EndOdometer);
-        $("#classification").html(drives.Classification);
-        $("#comment").html(drives.Comment);
+        $("#distance").html(drives.DistanceString + " " + unit);
+        $("#classification").html(drives.ClassificationString);
+
+        $("#comment_drive").val(drives.Id);
+        $("#comment").val(comment);
     }
+
+    var frm = $("#commentform");
+    frm.submit(function (e) {
+        e.preventDefault();
+
+        $("#btn_comment").prop("disabled", true);
+
+        $.ajax({
+            type: frm.attr("method"),
+            url: frm.attr("action"),
+            data: frm.serialize(),
+            success: function (data) {
+                $("#btn_comment").prop("disabled", false);
+            },
+            error: function (data) {
+                console.log('An error occurred.');
+                console.log(data);
+
+                var json = data.responseJSON;
+                alert(json && json.Message ? json.Message + ".\n\n" + json.Error : t("error_occurred"));
+
+                $("#btn_comment").prop("disabled", false);
+            },
+        });
+    });
 });
@@ -0,0 +1,49 @@
+$(document).ready(function() {
+    var car = $("#drivers").data("car");
+
+    $("body").on("click", ".driver .save",
+        function() {
+            var row = $(this).parents(".driver");
+            var id = row.data("id");
+
+            $.ajax({
+                type: id ? "PUT" : "POST",
+                url: "/drivers/" + car + (id ? "/" + id : ""),
+                data: row.find("input").serialize(),
+                success: function (data) {
+                    window.location.reload();
+                },
+                error: function (data) {
+                    console.log('An error occurred.');
+                    console.log(data);
+
+                    alert(t("driver_not_saved") + "\n\n" + data.responseText);
+                },
+            });
+        }
+    );
+
+    $("body").on("click", ".driver .delete",
+        function() {
+            var row = $(this).parents(".driver");
+
+            if (!confirm(t("confirm_delete_driver"))) {
+                return;
+            }
+
+            $.ajax({
+                type: "DELETE",
+                url: "/drivers/" + car + "/" + row.data("id"),
+                success: function (data) {
+                    row.remove();
+                },
+                error: function (data) {
+                    console.log('An error occurred.');
+                    console.log(data);
+
+                    alert(t("driver_not_deleted") + "\n\n" + data.responseText);
+                },
+            });
+        }
+    );
+});
@@ -0,0 +1,5 @@
+// The texts of the scripts come from the message catalog of the language of the page, which
+// the page puts in messages. A text missing from the catalog shows its key.
+function t(key) {
+    return (window.messages && messages[key]) || key;
+}
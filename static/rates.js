@@ -0,0 +1,47 @@
+$(document).ready(function() {
+    $("body").on("click", ".rate .save",
+        function() {
+            var row = $(this).parents(".rate");
+            var id = row.data("id");
+
+            $.ajax({
+                type: id ? "PUT" : "POST",
+                url: "/rates" + (id ? "/" + id : ""),
+                data: row.find("select, input").serialize(),
+                success: function (data) {
+                    window.location.reload();
+                },
+                error: function (data) {
+                    console.log('An error occurred.');
+                    console.log(data);
+
+                    alert(t("rate_not_saved") + "\n\n" + data.responseText);
+                },
+            });
+        }
+    );
+
+    $("body").on("click", ".rate .delete",
+        function() {
+            var row = $(this).parents(".rate");
+
+            if (!confirm(t("confirm_delete_rate"))) {
+                return;
+            }
+
+            $.ajax({
+                type: "DELETE",
+                url: "/rates/" + row.data("id"),
+                success: function (data) {
+                    row.remove();
+                },
+                error: function (data) {
+                    console.log('An error occurred.');
+                    console.log(data);
+
+                    alert(t("rate_not_deleted") + "\n\n" + data.responseText);
+                },
+            });
+        }
+    );
+});
@@ -43,6 +43,9 @@ $(document).ready(function() {
     );
 
     var frm = $("#dayform");
+    // the unit of the distances, km or mi:
+    var unit = frm.data("unit");
+
     frm.submit(function (e) {
         e.preventDefault();
 
@@ -50,8 +53,14 @@ $(document).ready(function() {
             type: frm.attr("method"),
             url: frm.attr("action"),
             data: frm.serialize(),
-            success: function (data) {
-                var json = JSON.parse(data);
+            dataType: "json",
+            success: function (json) {
+                // closing or reopening changes what can be done with the whole month:
+                var action = $("#action").val();
+                if (action == "closemonth" || action == "reopenmonth") {
+                    selectform.submit();
+                    return;
+                }
 
                 populateTotals(json.Totals);
                 populateDays(json.AffectedDays);
@@ -61,6 +70,9 @@ $(document).ready(function() {
             error: function (data) {
                 console.log('An error occurred.');
                 console.log(data);
+
+                var json = data.responseJSON;
+                alert(json && json.Message ? json.Message + ".\n\n" + json.Error : t("error_occurred"));
             },
         });
     });
@@ -70,25 +82,30 @@ $(document).ready(function() {
         var checkedDrives = $(".drivecb:checked").not(".groupedcb").length;
         var nothingChecked = checkedDrives == 0 && checkedGroupDrives == 0;
 
-        $("#btn_business").prop("disabled", nothingChecked);
-        $("#btn_private").prop("disabled", nothingChecked);
+        // the drives of a closed month can't be changed:
+        if (frm.data("closed")) {
+            nothingChecked = true;
+        }
+
+        $(".classify").prop("disabled", nothingChecked);
+        $("#assignee, #btn_assign").prop("disabled", nothingChecked);
         $("#btn_group").prop("disabled", nothingChecked || checkedDrives < 2  || checkedGroupDrives > 0);
         $("#btn_ungroup").prop("disabled", nothingChecked || checkedDrives > 0 || checkedGroupDrives == 0);
     }
 
-    $("#btn_business").click(
+    $(".classify").click(
         function() {
             $("#action").val("classify");
-            $("#classification").val("business");
+            $("#classification").val($(this).data("classification"));
 
             $("#dayform").submit();
         }
     );
 
-    $("#btn_private").click(
+    $("#btn_assign").click(
         function() {
-            $("#action").val("classify");
-            $("#classification").val("private");
+            $("#action").val("assign");
+            $("#assigneeid").val($("#assignee").val());
 
             $("#dayform").submit();
         }
@@ -102,31 +119,80 @@ $(document).ready(function() {
         }
     );
 
+    $("#btn_rules").click(
+        function() {
+            $("#action").val("applyrules");
+
+            $("#dayform").submit();
+        }
+    );
+
+    $("#btn_suggestions").click(
+        function() {
+            $("#action").val("acceptsuggestions");
+
+            $("#dayform").submit();
+        }
+    );
+
+    $("#btn_close").click(
+        function() {
+            if (!confirm(t("confirm_close_month"))) {
+                return;
+            }
+
+            $("#action").val("closemonth");
+
+            $("#dayform").submit();
+        }
+    );
+
+    $("#btn_reopen").click(
+        function() {
+            var reason = prompt(t("reopen_reason_prompt"));
+            if (!reason) {
+                return;
+            }
+
+            $("#action").val("reopenmonth");
+            $("#reason").val(reason);
+
+            $("#dayform").submit();
+        }
+    );
+
     $("#btn_ungroup").click(
         function() {
             $("#action").val("ungroup");
 
+            var commented = $(".groupedcb:checked[data-comment]").length > 0;
+            $("#copycomment").val(commented && confirm(t("confirm_copy_comment")));
+
             $("#dayform").submit();
         }
     );
 
     function populateTotals(totals) {
         var html = "";
-        html += "Total körsträcka: " + totals.TotalDistance.toFixed(1) + " km<br>";
-        html += "Varav tjänsteresor: " + totals.TotalBusinessDistance.toFixed(1) + " km<br>";
-        html += "Varav privatresor: " + totals.TotalPrivateDistance.toFixed(1) + " km";
+        html += t("total_distance") + ": " + totals.TotalDistance.toFixed(1) + " " + unit + "<br>";
+        html += t("of_which_business") + ": " + totals.TotalBusinessDistance.toFixed(1) + " " + unit + " (" + totals.Reimbursement.toFixed(2) + " kr)<br>";
+        html += t("of_which_private") + ": " + totals.TotalPrivateDistance.toFixed(1) + " " + unit;
+        // drives only need a driver once the car has drivers:
+        if ($("#driver").length > 0 && totals.UnassignedDistance > 0) {
+            html += "<br><font color='red'>" + t("without_driver") + ": " + totals.UnassignedDistance.toFixed(1) + " " + unit + "</font>";
+        }
         if (totals.UnclassifiedDistance > 0) {
-            html += "<br><font color='red'>Oklassificerad sträcka: " + totals.UnclassifiedDistance.toFixed(1) + " km</font>";
+            html += "<br><font color='red'>" + t("unclassified_distance") + ": " + totals.UnclassifiedDistance.toFixed(1) + " " + unit + "</font>";
         }
 
         $("#totaldistances").html(html);
 
         html = "";
-        html += "Total tid: " + tohhmm(totals.TotalDuration) + "<br>";
-        html += "Varav tjänsteresor: " + tohhmm(totals.TotalBusinessDuration) + "<br>";
-        html += "Varav privatresor: " + tohhmm(totals.TotalPrivateDuration);
+        html += t("total_duration") + ": " + tohhmm(totals.TotalDuration) + "<br>";
+        html += t("of_which_business") + ": " + tohhmm(totals.TotalBusinessDuration) + "<br>";
+        html += t("of_which_private") + ": " + tohhmm(totals.TotalPrivateDuration);
         if (totals.UnclassifiedDuration > 0) {
-            html += "<br><font color='red'>Oklassificerad tid: " + tohhmm(totals.UnclassifiedDuration) + "</font>";
+            html += "<br><font color='red'>" + t("unclassified_duration") + ": " + tohhmm(totals.UnclassifiedDuration) + "</font>";
         }
 
         $("#totaldurations").html(html);
@@ -191,7 +257,11 @@ $(document).ready(function() {
                 if (gid != -1 && gid != currentGroupId) {
                     currentGroupId = gid;
 
-                    html += makeDriveHTML(getGroupedDrive(groupedDrives, gid), gid);
+                    // a group isn't shown in the journal of a driver unless all of its drives are theirs:
+                    var group = getGroupedDrive(groupedDrives || [], gid);
+                    if (group) {
+                        html += makeDriveHTML(group, gid);
+                    }
                 }
 
                 if (gid == -1) {
@@ -212,7 +282,8 @@ $(document).ready(function() {
         html += "<td align=left valign=center width=25>";
         if (groupID != -1) {
             endpoint = "group" + endpoint + groupID;
-            html += "<input type='checkbox' class='drivecb groupedcb' name='groupeddrive' value='" + groupID + "'/>";
+            var commentAttr = drive.Comment.Valid ? " data-comment='true'" : "";
+            html += "<input type='checkbox' class='drivecb groupedcb' name='groupeddrive' value='" + groupID + "'" + commentAttr + "/>";
         } else {
             endpoint = endpoint + drive.Id;
             html += "    <input type='checkbox' class='drivecb' name='drive' value='" + drive.Id + "'/>";
@@ -232,16 +303,19 @@ $(document).ready(function() {
         html += "</td>";
 
         html += "<td align=left width=250>";
-        html += "    <span lang=sv style='font-size: 10.0pt; font-family:Calibri;'>";
+        html += "    <span style='font-size: 10.0pt; font-family:Calibri;'>";
         html += "    <a href='" + endpoint + "'>";
         html += "    " + drive.EndAddress + "<br>";
         html += "    " + drive.StartAddress;
         html += "    </a>";
+        if (drive.Comment && drive.Comment.Valid) {
+            html += "    <br><span class='comment'>" + escapeHTML(drive.Comment.String) + "</span>";
+        }
         html += "    </span>";
         html += "</td>";
 
         html += "<td align=right width=50>";
-        html += "    <span lang=sv style='font-size: 10.0pt;font-family:Calibri;'>";
+        html += "    <span style='font-size: 10.0pt;font-family:Calibri;'>";
         html += "    <a href='" + endpoint + "'>";
         html += "    " + drive.EndTime + "<br>";
         html += "    " + drive.StartTime;
@@ -249,21 +323,36 @@ $(document).ready(function() {
         html += "    </span>";
         html += "</td>";
 
-        html += "<td width=150>";
-        html += "    &nbsp;";
+        html += "<td align=center width=150>";
+        if (drive.DriverName) {
+            html += "    <span class='driver'>" + escapeHTML(drive.DriverName) + "</span>";
+        } else if ($("#driver").length > 0) {
+            html += "    <span class='driver unassigned'>" + t("no_driver") + "</span>";
+        } else {
+            html += "    &nbsp;";
+        }
         html += "</td>";
 
         html += "<td align=left width=250>";
-        html += "    <span lang=sv style='font-size: 10.0pt;font-family:Calibri;'>";
+        html += "    <span style='font-size: 10.0pt;font-family:Calibri;'>";
         html += "    <a href='" + endpoint + "'>";
-        html += "    Körsträcka: " + drive.DistanceString + " km<br>";
-        html += "    Tid: " + drive.DurationString;
+        html += "    " + t("drive_distance") + ": " + drive.DistanceString + " " + unit + "<br>";
+        html += "    " + t("duration") + ": " + drive.DurationString;
+        if (drive.Reimbursement > 0) {
+            html += "    <br>" + t("reimbursement") + ": " + drive.ReimbursementString + " kr";
+        }
         html += "    </a>";
         html += "    </span>";
         html += "</td>";
 
         html += "<td class=" + drive.ClassificationClass + " align=right width=150>";
-        html += "    <a class=" + drive.ClassificationClass + " href='" + endpoint + "'>" + drive.ClassificationString + "</a>";
+        if (drive.RuleId && drive.RuleId.Valid && drive.Classification.Valid) {
+            html += "    <a class='" + drive.ClassificationClass + " auto' href='" + endpoint + "' title='" + t("auto_classified") + "'>" + drive.ClassificationString + "</a>";
+        } else if (drive.SuggestedClassification && drive.SuggestedClassification.Valid) {
+            html += "    <a class=" + drive.ClassificationClass + " href='" + endpoint + "'><span class='suggestion'>" + drive.SuggestionString + "</span></a>";
+        } else {
+            html += "    <a class=" + drive.ClassificationClass + " href='" + endpoint + "'>" + drive.ClassificationString + "</a>";
+        }
         html += "</td>";
         html += "</tr>";
 
@@ -276,6 +365,10 @@ $(document).ready(function() {
         return html;
     }
 
+    function escapeHTML(text) {
+        return $("<div>").text(text).html();
+    }
+
     function getGroupedDrive(groupedDrives, gid) {
         for (var i = 0; i < groupedDrives.length; i++) {
            if (groupedDrives[i].Id == gid) {
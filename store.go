@@ -0,0 +1,130 @@
+package main
+
+import (
+	"time"
+)
+
+// JournalStore is the data access layer of the journal. The service uses a
+// PostgreSQL implementation working directly on the Teslamate database; the
+// tests use an in-memory implementation.
+//
+// Drives and grouped drives are returned as stored, i.e. without the display
+// strings (times, durations, classification labels etc.) filled in.
+type JournalStore interface {
+	GetCars() ([]Car, error)
+	GetFirstAndLastYears() (int, int, error)
+	// GetUnitOfLength returns the unit of length TeslaMate is set to, "km" or "mi", or an empty string if TeslaMate has no settings.
+	GetUnitOfLength() (string, error)
+
+	// GetDrives returns the drives of a car starting within [from, to), latest first.
+	GetDrives(carId int, from, to time.Time) ([]Drive, error)
+	// GetDrivesPage returns limit drives of a car starting within [from, to), latest first, from
+	// offset, along with the number of drives in all. Unless driverId is 0, only the drives of the
+	// driver are included; unless classification is anyClassification, only those classified with
+	// it, or the unclassified ones for noClassification.
+	GetDrivesPage(carId, driverId int, from, to time.Time, classification, limit, offset int) ([]Drive, int, error)
+	GetDriveById(id int) (Drive, error)
+	GetPositions(driveIds []int64) ([]Position, error)
+	// GetTotals returns the totals of the drives of a car within [from, to), only counting those of the driver unless driverId is 0.
+	GetTotals(carId, driverId int, from, to time.Time) (Totals, error)
+
+	// GetDateRange returns the first start date and the last end date of the given drives and grouped drives.
+	GetDateRange(driveIds []int64, groupIds []int64) (time.Time, time.Time, error)
+
+	GetGroupedDrives(carId int, from, to time.Time) ([]GroupedDrives, error)
+	GetGroupedDrivesById(id int) (GroupedDrives, error)
+	GetDriveIdsForGroups(groupIds []int64) ([]int64, error)
+	GroupDrives(carId int, driveIds []int64) error
+	UngroupDrives(groupIds []int64, copyComment bool) error
+
+	ChangeClassification(classification int, driveIds []int64, groupIds []int64) error
+	ChangeComment(comment string, driveIds []int64, groupIds []int64) error
+	// ClassifyByRule classifies a drive using a rule, also writing the comment of the rule if the drive has none.
+	ClassifyByRule(driveId int, rule Rule) error
+	// AcceptSuggestion classifies drives with the classification suggested for them, marking them as
+	// such so that GetClassifiedTrips leaves them out.
+	AcceptSuggestion(classification int, driveIds []int64) error
+	// GetClassifiedTrips returns the number of drives classified by hand per pair of places and classification.
+	GetClassifiedTrips() ([]ClassifiedTrip, error)
+
+	GetCategories() ([]Category, error)
+	CreateCategory(c Category) (Category, error)
+	UpdateCategory(c Category) error
+	DeleteCategory(id int) error
+
+	// GetRules returns the rules applying to a car, in the order they should be tried.
+	GetRules(carId int) ([]Rule, error)
+	GetAllRules() ([]Rule, error)
+	CreateRule(rule Rule) (Rule, error)
+	UpdateRule(rule Rule) error
+	DeleteRule(id int) error
+
+	// GetDrivers returns the drivers of a car, by name.
+	GetDrivers(carId int) ([]Driver, error)
+	// GetDriversOfUser returns the drivers the user is, one per car they drive.
+	GetDriversOfUser(userId int) ([]Driver, error)
+	CreateDriver(d Driver) (Driver, error)
+	UpdateDriver(d Driver) error
+	// DeleteDriver deletes a driver, leaving their drives without a driver.
+	DeleteDriver(id int) error
+	// AssignDriver assigns the drives, and the drives of the groups, to a driver. A driverId of 0 leaves them without a driver.
+	AssignDriver(driverId int, driveIds []int64, groupIds []int64) error
+
+	GetRates() ([]Rate, error)
+	CreateRate(rate Rate) (Rate, error)
+	UpdateRate(rate Rate) error
+	DeleteRate(id int) error
+
+	// GetClosedMonths returns the first day of each closed month of a car.
+	GetClosedMonths(carId int) ([]time.Time, error)
+	// GetClosedMonthsOf returns the closed months that the given drives and grouped drives start in.
+	GetClosedMonthsOf(driveIds []int64, groupIds []int64) ([]time.Time, error)
+	// CloseMonth closes a month of a car, logging who did it. Closing a closed month does nothing.
+	CloseMonth(carId int, month time.Time, user string) error
+	// ReopenMonth reopens a closed month of a car, logging who did it and why. Fails with
+	// sql.ErrNoRows if the month isn't closed.
+	ReopenMonth(carId int, month time.Time, user, reason string) error
+	GetClosedMonthLog(carId int) ([]ClosedMonthEvent, error)
+
+	// AppendAudit appends entries to the audit log, setting the hashes that chain each
+	// entry to the one before it.
+	AppendAudit(entries []AuditEntry) error
+	// GetAuditLog returns the whole audit log in the order it was written.
+	GetAuditLog() ([]AuditEntry, error)
+	// GetAuditHistory returns the audit entries of a drive or grouped drive, oldest first.
+	GetAuditHistory(entity string, entityId int) ([]AuditEntry, error)
+
+	GetUsers() ([]User, error)
+	// GetUser returns the user with the username, or sql.ErrNoRows if there is none.
+	GetUser(username string) (User, error)
+	CreateUser(user User) (User, error)
+	// SetPassword changes the password hash of a user, failing with sql.ErrNoRows if there is no such user.
+	SetPassword(username, passwordHash string) error
+	// SetRole changes the role of a user, failing with sql.ErrNoRows if there is no such user.
+	SetRole(username, role string) error
+	// SetLocale changes the language a user chose, an empty one letting the browser choose,
+	// failing with sql.ErrNoRows if there is no such user.
+	SetLocale(username, locale string) error
+	// DeleteUser deletes a user along with their sessions.
+	DeleteUser(username string) error
+
+	// CreateSession stores a new session, removing the expired ones.
+	CreateSession(session Session) error
+	// GetSession returns the unexpired session with the token hash, or sql.ErrNoRows if there is none.
+	GetSession(tokenHash string) (Session, error)
+	DeleteSession(tokenHash string) error
+
+	// GetApiTokens returns all API tokens, including the revoked and expired ones.
+	GetApiTokens() ([]ApiToken, error)
+	// GetApiToken returns the API token with the hash, along with the role of its user, or
+	// sql.ErrNoRows if there is none that is neither revoked nor expired.
+	GetApiToken(tokenHash string) (ApiToken, error)
+	CreateApiToken(token ApiToken) (ApiToken, error)
+	// RevokeApiToken revokes a token, failing with sql.ErrNoRows if there is no such token that isn't revoked already.
+	RevokeApiToken(id int) error
+
+	// Transaction runs fn with a store whose changes are only kept if fn returns nil.
+	Transaction(fn func(JournalStore) error) error
+}
+
+var store JournalStore
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// suggestions with a lower confidence than this are not presented to the user:
+const minSuggestionConfidence = 0.5
+
+// SuggestionModel suggests classifications for drives based on how earlier drives
+// between the same places were classified. Drives are matched on their pair of
+// geofences if both ends of the drive are within a geofence, otherwise on their pair
+// of addresses. The direction of the drive doesn't matter; a drive home from a
+// customer is classified like the drive to the customer.
+type SuggestionModel struct {
+	counts map[string]map[int]int
+}
+
+func geofenceKey(start, end sql.NullInt32) string {
+	if !start.Valid || !end.Valid {
+		return ""
+	}
+
+	if start.Int32 > end.Int32 {
+		start, end = end, start
+	}
+
+	return fmt.Sprintf("g:%d:%d", start.Int32, end.Int32)
+}
+
+func addressKey(start, end string) string {
+	start = strings.ToLower(strings.TrimSpace(start))
+	end = strings.ToLower(strings.TrimSpace(end))
+	if start == "" || end == "" {
+		return ""
+	}
+
+	if start > end {
+		start, end = end, start
+	}
+
+	return "a:" + start + "\x00" + end
+}
+
+func newSuggestionModel(trips []ClassifiedTrip) SuggestionModel {
+	m := SuggestionModel{counts: make(map[string]map[int]int)}
+
+	for _, t := range trips {
+		for _, key := range []string{geofenceKey(t.StartGeofenceId, t.EndGeofenceId), addressKey(t.StartAddress, t.EndAddress)} {
+			if key == "" {
+				continue
+			}
+
+			if _, ok := m.counts[key]; !ok {
+				m.counts[key] = make(map[int]int)
+			}
+
+			m.counts[key][t.Classification] += t.Count
+		}
+	}
+
+	return m
+}
+
+// Suggest returns the most common classification of earlier drives between the same places, and
+// the confidence of the suggestion. The confidence is the share of the earlier drives having that
+// classification, with one extra unknown drive added so that a single observation never gives
+// more than 50% confidence.
+func (m SuggestionModel) Suggest(drive Drive) (int, float64, bool) {
+	counts, ok := m.counts[geofenceKey(drive.StartGeofenceId, drive.EndGeofenceId)]
+	if !ok {
+		counts, ok = m.counts[addressKey(drive.StartAddress, drive.EndAddress)]
+	}
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	best, bestCount, total := 0, 0, 0
+	for classification, count := range counts {
+		total += count
+
+		if count > bestCount || (count == bestCount && classification < best) {
+			best = classification
+			bestCount = count
+		}
+	}
+
+	confidence := float64(bestCount) / float64(total+1)
+
+	return best, confidence, confidence >= minSuggestionConfidence
+}
+
+// getSuggestionModel builds a model from all drives that have been classified by hand.
+// Drives classified by rules or by accepting suggestions are left out so that neither
+// reinforces itself.
+func getSuggestionModel(s JournalStore) (SuggestionModel, error) {
+	trips, err := s.GetClassifiedTrips()
+	if err != nil {
+		return SuggestionModel{}, err
+	}
+
+	return newSuggestionModel(trips), nil
+}
+
+// suggestClassifications fills in the suggested classification of the unclassified drives,
+// displayed in the language.
+func suggestClassifications(s JournalStore, drives []Drive, categories map[int]Category, lang string) error {
+	needed := false
+	for _, d := range drives {
+		if !d.Classification.Valid {
+			needed = true
+			break
+		}
+	}
+
+	if !needed {
+		return nil
+	}
+
+	model, err := getSuggestionModel(s)
+	if err != nil {
+		return err
+	}
+
+	for i := range drives {
+		if drives[i].Classification.Valid {
+			continue
+		}
+
+		classification, confidence, ok := model.Suggest(drives[i])
+		if _, known := categories[classification]; !ok || !known {
+			continue
+		}
+
+		drives[i].SuggestedClassification = sql.NullInt32{Int32: int32(classification), Valid: true}
+		drives[i].SuggestionConfidence = confidence
+		drives[i].SuggestionString = fmt.Sprintf("%s (%.0f%%)", categoryLabel(categories[classification], lang), confidence*100)
+	}
+
+	return nil
+}
+
+// acceptSuggestions classifies all unclassified, ungrouped drives of the period
+// with their suggested classification.
+func acceptSuggestions(s JournalStore, carId int, from, to time.Time, user string) (*time.Time, *time.Time, error) {
+	categories, err := getCategoryMap()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	drives, err := s.GetDrives(carId, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = suggestClassifications(s, drives, categories, defaultLocale)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accepted := make(map[int][]int64)
+	for _, d := range drives {
+		if d.GroupId.Valid || d.Classification.Valid || !d.SuggestedClassification.Valid {
+			continue
+		}
+
+		classification := int(d.SuggestedClassification.Int32)
+		accepted[classification] = append(accepted[classification], int64(d.Id))
+	}
+
+	// the drives are classified like changeClassification does, but marked as suggested so that
+	// accepting suggestions doesn't make the model more confident of them:
+	for classification, ids := range accepted {
+		err = checkMonthsOpen(s, ids, []int64{})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		changes, err := classificationChanges(s, classification, ids, []int64{})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = s.AcceptSuggestion(classification, ids)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = audit(s, user, changes)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return &from, &to, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// the scopes of API tokens; read tokens may only make requests that don't change anything:
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+)
+
+// tokenPrefix starts every API token, telling them apart from other secrets in scripts.
+const tokenPrefix = "tj_"
+
+// tokenRoutes are the path templates that accept API tokens: the JSON endpoints and the exports.
+// The /api/v1 endpoints all accept them.
+var tokenRoutes = map[string]bool{
+	"/drive/{id}":                      true,
+	"/drive/group/{id}":                true,
+	"/action":                          true,
+	"/export":                          true,
+	"/report/{car}/{year}/{month}.pdf": true,
+	"/annual/{car}/{year}.pdf":         true,
+	"/annual/{car}/{year}.csv":         true,
+}
+
+// bearerToken returns the token of an Authorization: Bearer header, if there is one.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(header[7:]), true
+}
+
+// authenticateToken lets a request carrying an API token through to next as if its user were
+// logged in, if the token is valid and allowed for the route and method. Tokens aren't sent by
+// browsers on their own, so there's no CSRF token to check.
+func authenticateToken(w http.ResponseWriter, r *http.Request, token string, next http.Handler) {
+	var template string
+	if route := mux.CurrentRoute(r); route != nil {
+		template, _ = route.GetPathTemplate()
+	}
+
+	if !tokenRoutes[template] && !strings.HasPrefix(template, "/api/v1/") {
+		writeError(w, r, http.StatusUnauthorized, "tokens_not_accepted", errors.New("API tokens aren't accepted by "+r.URL.Path))
+		return
+	}
+
+	t, err := store.GetApiToken(hashToken(token))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			writeError(w, r, http.StatusInternalServerError, "token_not_checked", err)
+			return
+		}
+
+		writeError(w, r, http.StatusUnauthorized, "invalid_token", errors.New("Unknown, revoked or expired API token"))
+		return
+	}
+
+	if t.Scope != scopeWrite && !isSafeMethod(r.Method) {
+		writeError(w, r, http.StatusForbidden, "token_read_only", fmt.Errorf("The API token %d may only read", t.Id))
+		return
+	}
+
+	session := Session{UserId: t.UserId, Username: t.Username, Role: t.Role, Locale: t.Locale}
+	if t.Expires.Valid {
+		session.Expires = t.Expires.Time
+	}
+
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey, session)))
+}
+
+// createApiToken creates a token for the user, returning the token itself, which isn't stored.
+func createApiToken(username, name, scope string, expires sql.NullTime) (string, ApiToken, error) {
+	var t ApiToken
+
+	user, err := store.GetUser(username)
+	if err == sql.ErrNoRows {
+		return "", t, errors.New("Unknown user: " + username)
+	} else if err != nil {
+		return "", t, err
+	}
+
+	if scope != scopeRead && scope != scopeWrite {
+		return "", t, errors.New("Unknown scope: " + scope)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", t, err
+	}
+	token = tokenPrefix + token
+
+	t, err = store.CreateApiToken(ApiToken{UserId: user.Id, Username: user.Username, Role: user.Role, Name: name, TokenHash: hashToken(token), Scope: scope, Expires: expires})
+
+	return token, t, err
+}
+
+// runTokenCommand implements the token subcommand for managing API tokens.
+func runTokenCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("token", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: tesla_journal token list | add [-scope read|write] [-expires YYYY-MM-DD] <user> <name> | revoke <id>")
+	}
+
+	if len(args) == 1 && args[0] == "list" {
+		tokens, err := store.GetApiTokens()
+		if err != nil {
+			return err
+		}
+
+		for _, t := range tokens {
+			state := "valid"
+			if t.Revoked.Valid {
+				state = "revoked " + t.Revoked.Time.Format("2006-01-02")
+			} else if t.Expires.Valid && !t.Expires.Time.After(time.Now()) {
+				state = "expired"
+			} else if t.Expires.Valid {
+				state = "expires " + t.Expires.Time.Format("2006-01-02")
+			}
+
+			fmt.Fprintf(out, "%d\t%s\t%s\t%s\t%s\t%s\n", t.Id, t.Username, t.Name, t.Scope, t.Created.Format("2006-01-02"), state)
+		}
+
+		return nil
+	}
+
+	if len(args) == 2 && args[0] == "revoke" {
+		id, err := parseId(args[1])
+		if err != nil {
+			return err
+		}
+
+		err = store.RevokeApiToken(int(id))
+		if err == sql.ErrNoRows {
+			return errors.New("Unknown or already revoked token: " + args[1])
+		}
+
+		return err
+	}
+
+	if len(args) > 0 && args[0] == "add" {
+		scope := flags.String("scope", scopeRead, "read or write")
+		expiresFlag := flags.String("expires", "", "the last day the token is valid (YYYY-MM-DD)")
+
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+
+		if flags.NArg() != 2 || strings.TrimSpace(flags.Arg(1)) == "" {
+			flags.Usage()
+			return errors.New("Invalid arguments")
+		}
+
+		var expires sql.NullTime
+		if *expiresFlag != "" {
+			day, err := parseDate(*expiresFlag)
+			if err != nil {
+				return errors.New("Invalid expiry date: " + strconv.Quote(*expiresFlag))
+			}
+
+			// valid through the day given:
+			expires = sql.NullTime{Time: day.AddDate(0, 0, 1), Valid: true}
+		}
+
+		token, t, err := createApiToken(flags.Arg(0), strings.TrimSpace(flags.Arg(1)), *scope, expires)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Token %d for %s; it is only shown now:\n%s\n", t.Id, t.Username, token)
+		return nil
+	}
+
+	flags.Usage()
+	return errors.New("Invalid arguments")
+}
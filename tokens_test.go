@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withToken(r *http.Request, token string) *http.Request {
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	return r
+}
+
+func TestApiTokens(t *testing.T) {
+	s := useFixtures(t)
+	useAuth(t)
+
+	read, _, err := createApiToken("anna", "bokföring", scopeRead, sql.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write, _, err := createApiToken("anna", "skript", scopeWrite, sql.NullTime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(read, tokenPrefix) || s.apiTokens[0].TokenHash == read {
+		t.Errorf("Expected only the hash of the token to be stored")
+	}
+
+	for _, path := range []string{"/drive/3", "/export?car=1&year=2021&month=3", "/annual/1/2021.csv", "/report/1/2021/3.pdf", "/api/v1/drives?car=1"} {
+		w := serve(withToken(httptest.NewRequest(http.MethodGet, path, nil), read), nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected %s to accept the token, got %d", path, w.Code)
+		}
+	}
+
+	// pages don't take tokens, and reading tokens can't change anything:
+	w := serve(withToken(httptest.NewRequest(http.MethodGet, "/", nil), read), nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the journal page to refuse tokens, got %d", w.Code)
+	}
+
+	w = serve(withToken(classifyRequest(""), read), nil)
+	if w.Code != http.StatusForbidden || s.classifications[3].Classification != 0 {
+		t.Errorf("Expected a read token not to classify drives, got %d", w.Code)
+	}
+
+	// no CSRF token is needed with a token:
+	w = serve(withToken(classifyRequest(""), write), nil)
+	if w.Code != http.StatusOK || s.classifications[3].Classification != 2 {
+		t.Errorf("Expected a write token to classify drives, got %d", w.Code)
+	}
+
+	history, _ := store.GetAuditHistory(auditDrive, 3)
+	if len(history) != 1 || history[0].User != "anna" {
+		t.Errorf("Expected the change to be logged as made by anna, got %+v", history)
+	}
+
+	w = serve(withToken(httptest.NewRequest(http.MethodGet, "/drive/3", nil), "tj_forged"), nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown token to be refused, got %d", w.Code)
+	}
+
+	// the responses are in the language of the user:
+	store.SetLocale("anna", "en")
+	w = serve(withToken(httptest.NewRequest(http.MethodGet, "/drive/99", nil), read), nil)
+	if !strings.Contains(w.Body.String(), "There is no such drive") {
+		t.Errorf("Expected an error in English, got %s", w.Body.String())
+	}
+}
+
+func TestApiTokenRevokedAndExpired(t *testing.T) {
+	useFixtures(t)
+	useAuth(t)
+
+	expired, _, _ := createApiToken("anna", "gammal", scopeRead, sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true})
+	w := serve(withToken(httptest.NewRequest(http.MethodGet, "/drive/3", nil), expired), nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an expired token to be refused, got %d", w.Code)
+	}
+
+	var out bytes.Buffer
+	err := runTokenCommand([]string{"add", "-scope", "write", "-expires", "2099-12-31", "anna", "skript"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	token := lines[len(lines)-1]
+
+	w = serve(withToken(httptest.NewRequest(http.MethodGet, "/drive/3", nil), token), nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the new token to be accepted, got %d", w.Code)
+	}
+
+	err = runTokenCommand([]string{"revoke", "2"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = serve(withToken(httptest.NewRequest(http.MethodGet, "/drive/3", nil), token), nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked token to be refused, got %d", w.Code)
+	}
+
+	out.Reset()
+	runTokenCommand([]string{"list"}, &out)
+	if !strings.Contains(out.String(), "1\tanna\tgammal\tread") || !strings.Contains(out.String(), "\texpired\n") || !strings.Contains(out.String(), "\trevoked ") {
+		t.Errorf("Unexpected list of tokens %q", out.String())
+	}
+
+	for _, args := range [][]string{{"add", "-scope", "admin", "anna", "x"}, {"add", "bertil", "x"}, {"add", "-expires", "snart", "anna", "x"}, {"revoke", "2"}} {
+		if runTokenCommand(args, &out) == nil {
+			t.Errorf("Expected %v to fail", args)
+		}
+	}
+}
+
+func TestApiTokenRoles(t *testing.T) {
+	useFixtures(t)
+	useAuth(t)
+	addUser(t, "cecilia", roleAccountant)
+
+	// a token can't do more than its user:
+	token, _, _ := createApiToken("cecilia", "bokföring", scopeWrite, sql.NullTime{})
+
+	w := serve(withToken(classifyRequest(""), token), nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected the token of an accountant not to classify drives, got %d", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/export?"+url.Values{"car": {"2"}, "year": {"2021"}}.Encode(), nil)
+	w = serve(withToken(r, token), nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the token of an accountant to export, got %d", w.Code)
+	}
+}
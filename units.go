@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+)
+
+// Distances and odometer readings are stored in km, as TeslaMate stores them, and converted to
+// the unit of the car only when they are shown, exported or returned by the API. Reimbursement,
+// rates and the distances of rules stay in km.
+
+// the units of length:
+const (
+	unitKm = "km"
+	unitMi = "mi"
+)
+
+const kmPerMile = 1.609344
+
+func isUnit(unit string) bool {
+	return unit == unitKm || unit == unitMi
+}
+
+// carUnit returns the unit the distances of a car are given in: the one configured for the car,
+// otherwise the one of the service, otherwise the one TeslaMate is set to, otherwise km.
+func carUnit(carId int) string {
+	if car, ok := config.Car[strconv.Itoa(carId)]; ok && isUnit(car.Units) {
+		return car.Units
+	}
+
+	if isUnit(config.Service.Units) {
+		return config.Service.Units
+	}
+
+	unit, err := store.GetUnitOfLength()
+	if err != nil {
+		log.Println("Error retrieving the unit of length of TeslaMate: " + err.Error())
+	}
+
+	if isUnit(unit) {
+		return unit
+	}
+
+	return unitKm
+}
+
+// convertDistance converts a distance in km to the unit.
+func convertDistance(km float32, unit string) float32 {
+	if unit == unitMi {
+		return float32(float64(km) / kmPerMile)
+	}
+
+	return km
+}
+
+// convertOdometer converts an odometer reading in whole km to whole units.
+func convertOdometer(km int, unit string) int {
+	if unit == unitMi {
+		return int(math.Round(float64(km) / kmPerMile))
+	}
+
+	return km
+}
+
+// convertDrive converts the distance and odometer readings of a decorated drive to the unit.
+// The drive is for showing only afterwards; rules and rates need km.
+func convertDrive(drive *Drive, unit string) {
+	drive.Distance = convertDistance(drive.Distance, unit)
+	drive.DistanceString = fmt.Sprintf("%.2f", drive.Distance)
+	drive.StartOdometer = convertOdometer(drive.StartOdometer, unit)
+	drive.EndOdometer = convertOdometer(drive.EndOdometer, unit)
+}
+
+// convertGroupedDrives converts a decorated grouped drive to the unit, like convertDrive.
+func convertGroupedDrives(gd *GroupedDrives, unit string) {
+	gd.Distance = convertDistance(gd.Distance, unit)
+	gd.DistanceString = fmt.Sprintf("%.2f", gd.Distance)
+	gd.StartOdometer = convertOdometer(gd.StartOdometer, unit)
+	gd.EndOdometer = convertOdometer(gd.EndOdometer, unit)
+}
+
+// convertDays converts the drives and grouped drives of the days to the unit.
+func convertDays(days []Day, unit string) {
+	for i := range days {
+		for j := range days[i].Drives {
+			convertDrive(&days[i].Drives[j], unit)
+		}
+
+		for j := range days[i].GroupedDrives {
+			convertGroupedDrives(&days[i].GroupedDrives[j], unit)
+		}
+	}
+}
+
+// convertTotals converts the distances of the totals to the unit.
+func convertTotals(totals *Totals, unit string) {
+	totals.TotalDistance = convertDistance(totals.TotalDistance, unit)
+	totals.TotalBusinessDistance = convertDistance(totals.TotalBusinessDistance, unit)
+	totals.TotalPrivateDistance = convertDistance(totals.TotalPrivateDistance, unit)
+	totals.UnclassifiedDistance = convertDistance(totals.UnclassifiedDistance, unit)
+	totals.UnassignedDistance = convertDistance(totals.UnassignedDistance, unit)
+}
+
+// convertRows converts the distances and odometer readings of exported rows to the unit.
+func convertRows(rows []exportRow, unit string) {
+	for i := range rows {
+		rows[i].Distance = convertDistance(rows[i].Distance, unit)
+		rows[i].StartOdometer = convertOdometer(rows[i].StartOdometer, unit)
+		rows[i].EndOdometer = convertOdometer(rows[i].EndOdometer, unit)
+	}
+}
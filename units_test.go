@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCarUnit(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+
+	if unit := carUnit(1); unit != unitKm {
+		t.Errorf("Expected km without settings, got %s", unit)
+	}
+
+	s.unitOfLength = unitMi
+	if unit := carUnit(1); unit != unitMi {
+		t.Errorf("Expected the unit of TeslaMate, got %s", unit)
+	}
+
+	config.Service.Units = unitKm
+	if unit := carUnit(1); unit != unitKm {
+		t.Errorf("Expected the unit of the service to go before that of TeslaMate, got %s", unit)
+	}
+
+	config.Car = map[string]*struct{ Units string }{"1": {Units: unitMi}}
+	if unit := carUnit(1); unit != unitMi {
+		t.Errorf("Expected the unit of the car to go before that of the service, got %s", unit)
+	}
+
+	if unit := carUnit(2); unit != unitKm {
+		t.Errorf("Expected the unit of the service for other cars, got %s", unit)
+	}
+}
+
+func TestConvertUnits(t *testing.T) {
+	if d := convertDistance(160.9344, unitMi); d < 99.999 || d > 100.001 {
+		t.Errorf("Expected 100 mi, got %f", d)
+	}
+
+	if d := convertDistance(20.5, unitKm); d != 20.5 {
+		t.Errorf("Expected km to stay km, got %f", d)
+	}
+
+	if o := convertOdometer(1131, unitMi); o != 703 {
+		t.Errorf("Expected 703 mi, got %d", o)
+	}
+}
+
+func TestJournalInMiles(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+
+	km := generateMain(2021, 3, 1, 0, defaultLocale)
+
+	s.unitOfLength = unitMi
+	data := generateMain(2021, 3, 1, 0, defaultLocale)
+
+	if data.Unit != unitMi || data.TotalDistanceString != "68.7" || data.UnclassifiedDistanceString != "53.1" {
+		t.Errorf("Expected the totals in miles, got %s %s and %s unclassified", data.TotalDistanceString, data.Unit, data.UnclassifiedDistanceString)
+	}
+
+	// the allowance is paid by the km all the same:
+	if data.TotalReimbursementString != km.TotalReimbursementString {
+		t.Errorf("Expected the reimbursement %s, got %s", km.TotalReimbursementString, data.TotalReimbursementString)
+	}
+
+	last := data.Days[0].Drives[0]
+	if last.Id != 6 || last.DistanceString != "37.28" || last.StartOdometer != 665 || last.EndOdometer != 703 {
+		t.Errorf("Expected drive 6 in miles, got %+v", last)
+	}
+
+	// the rules still compare distances in km:
+	_, err := store.CreateRule(Rule{CarId: nullInt32(1), MinDistance: sql.NullFloat64{Float64: 50, Valid: true}, Classification: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = reapplyRules(store, 1, localDate(2021, 3, 1), localDate(2021, 4, 1), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d, _ := store.GetDriveById(6); d.Classification.Int32 != 2 {
+		t.Errorf("Expected the 60 km drive to be classified by the rule, got %v", d.Classification)
+	}
+}
+
+func TestExportsInMiles(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+	s.unitOfLength = unitMi
+
+	r := httptest.NewRequest(http.MethodGet, "/export?car=1&year=2021&month=3&columns=date,startodometer,distance", nil)
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	records := readCSV(t, w.Body.String(), ';')
+	if records[0][2] != "Sträcka (mi)" {
+		t.Errorf("Expected the distance in miles, got %v", records[0])
+	}
+
+	// drive 6 is the last one, followed by the four totals:
+	if drive := records[len(records)-5]; drive[1] != "665" || drive[2] != "37,28" {
+		t.Errorf("Expected drive 6 in miles, got %v", drive)
+	}
+
+	if sum := records[len(records)-1]; sum[2] != "68,66" {
+		t.Errorf("Expected the total in miles, got %v", sum)
+	}
+
+	data, err := getAnnualData(Car{Id: 1, Model: "3", Name: "Tesla"}, 2021, Driver{}, defaultLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Unit != unitMi || data.Trips[0].DistanceString != "12.4" || data.Trips[0].EndOdometer != 634 {
+		t.Errorf("Expected the business trips in miles, got %+v", data.Trips[0])
+	}
+}
+
+func TestApiInMiles(t *testing.T) {
+	s := useFixtures(t)
+	useDefaultConfig(t)
+	s.unitOfLength = unitMi
+
+	w := apiRequest(t, http.MethodGet, "/api/v1/drives/6", "")
+
+	var drive apiDrive
+	err := json.NewDecoder(w.Body).Decode(&drive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if drive.Unit != unitMi || drive.Distance < 37.28 || drive.Distance > 37.29 || drive.StartOdometer != 665 {
+		t.Errorf("Expected drive 6 in miles, got %+v", drive)
+	}
+
+	w = apiRequest(t, http.MethodGet, "/api/v1/totals?car=1&from=2021-03-01&to=2021-03-31", "")
+
+	var totals apiTotals
+	err = json.NewDecoder(w.Body).Decode(&totals)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if totals.Unit != unitMi || totals.Distance < 68.66 || totals.Distance > 68.67 {
+		t.Errorf("Expected the totals in miles, got %+v", totals)
+	}
+}
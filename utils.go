@@ -1,9 +1,17 @@
 package main
 
 import (
+    "errors"
     "time"
+    // the time zones are built in, in case the host has none:
+    _ "time/tzdata"
 )
 
+// location is the time zone of the journal, set from the TimeZone setting by loadTimeZone. Drives
+// are filed on the day and month they start on in it, and their times are shown in it. Loading
+// the default can't fail, as the time zones are built in.
+var location, _ = time.LoadLocation("Europe/Stockholm")
+
 func minutesToHoursAndMinutes(minutes int) (int, int) {
     h := 0
     m := minutes
@@ -15,16 +23,39 @@ func minutesToHoursAndMinutes(minutes int) (int, int) {
     return h, m
 }
 
-func convertTime(t time.Time) time.Time {
-    loc, err := time.LoadLocation("Europe/Stockholm")
-    if err == nil {
-        t = t.In(loc)
+// loadTimeZone sets the time zone of the journal. The name must be one that PostgreSQL knows
+// too, like Europe/Stockholm.
+func loadTimeZone(name string) error {
+    if name == "" || name == "Local" {
+        return errors.New("A time zone name like Europe/Stockholm is needed")
     }
 
-    return t
+    loc, err := time.LoadLocation(name)
+    if err != nil {
+        return err
+    }
+
+    location = loc
+    return nil
+}
+
+func convertTime(t time.Time) time.Time {
+    return t.In(location)
 }
 
+// stripTime returns midnight of the date of from, as read in the time zone of from, in the time
+// zone of the journal. Pass instants through convertTime first to get the day they happened on
+// in the time zone of the journal.
 func stripTime(from time.Time) time.Time {
-    return time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+    return time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, location)
 }
 
+// localDate returns the start of the day in the time zone of the journal.
+func localDate(year, month, day int) time.Time {
+    return time.Date(year, time.Month(month), day, 0, 0, 0, 0, location)
+}
+
+// parseDate parses a date (YYYY-MM-DD) as the start of the day in the time zone of the journal.
+func parseDate(s string) (time.Time, error) {
+    return time.ParseInLocation("2006-01-02", s, location)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// YearMonth is a row of the year overview: the totals of a month, or of the whole year, in the
+// unit of the car.
+type YearMonth struct {
+	Number                     int
+	Name                       string
+	TotalDistanceString        string
+	BusinessDistanceString     string
+	PrivateDistanceString      string
+	UnclassifiedDistanceString string
+	TotalDurationString        string
+	BusinessDurationString     string
+	PrivateDurationString      string
+	UnclassifiedDurationString string
+	// Unclassified is set if drives of the month remain to be classified:
+	Unclassified bool
+}
+
+type YearData struct {
+	Car  Car
+	Year int
+	// Driver is the driver whose drives are counted; the zero Driver if those of all drivers are:
+	Driver Driver
+	// Locale is the language of the page:
+	Locale string
+	// Unit is the unit of the distances, see units.go:
+	Unit   string
+	Months []YearMonth
+	Total  YearMonth
+	// UnclassifiedMonths is the number of months with drives left to classify:
+	UnclassifiedMonths int
+}
+
+// yearMonth formats totals as a row of the year overview.
+func yearMonth(number int, name string, totals Totals) YearMonth {
+	return YearMonth{
+		Number:                     number,
+		Name:                       name,
+		TotalDistanceString:        fmt.Sprintf("%.1f", totals.TotalDistance),
+		BusinessDistanceString:     fmt.Sprintf("%.1f", totals.TotalBusinessDistance),
+		PrivateDistanceString:      fmt.Sprintf("%.1f", totals.TotalPrivateDistance),
+		UnclassifiedDistanceString: fmt.Sprintf("%.1f", totals.UnclassifiedDistance),
+		TotalDurationString:        formatDuration(totals.TotalDuration),
+		BusinessDurationString:     formatDuration(totals.TotalBusinessDuration),
+		PrivateDurationString:      formatDuration(totals.TotalPrivateDuration),
+		UnclassifiedDurationString: formatDuration(totals.UnclassifiedDuration),
+		Unclassified:               totals.UnclassifiedDistance > 0 || totals.UnclassifiedDuration > 0,
+	}
+}
+
+// addTotals adds the distances and durations of t to sum.
+func addTotals(sum *Totals, t Totals) {
+	sum.TotalDistance += t.TotalDistance
+	sum.TotalBusinessDistance += t.TotalBusinessDistance
+	sum.TotalPrivateDistance += t.TotalPrivateDistance
+	sum.UnclassifiedDistance += t.UnclassifiedDistance
+	sum.TotalDuration += t.TotalDuration
+	sum.TotalBusinessDuration += t.TotalBusinessDuration
+	sum.TotalPrivateDuration += t.TotalPrivateDuration
+	sum.UnclassifiedDuration += t.UnclassifiedDuration
+}
+
+// getYearData returns the totals of each month of the year and of the whole year, counting
+// only the drives of the driver unless driver is the zero Driver. The overview shows no
+// reimbursement, so the totals are taken from the store without working it out.
+func getYearData(car Car, year int, driver Driver, lang string) (YearData, error) {
+	data := YearData{
+		Car:    car,
+		Year:   year,
+		Driver: driver,
+		Locale: lang,
+		Unit:   carUnit(car.Id),
+	}
+
+	var sum Totals
+	for _, m := range monthNames(lang) {
+		from := localDate(year, m.Number, 1)
+		totals, err := store.GetTotals(car.Id, driver.Id, from, from.AddDate(0, 1, 0))
+		if err != nil {
+			return data, err
+		}
+		convertTotals(&totals, data.Unit)
+
+		month := yearMonth(m.Number, m.Name, totals)
+		if month.Unclassified {
+			data.UnclassifiedMonths++
+		}
+
+		data.Months = append(data.Months, month)
+		addTotals(&sum, totals)
+	}
+
+	data.Total = yearMonth(0, translate(lang, "total"), sum)
+
+	return data, nil
+}
+
+func yearHandler(w http.ResponseWriter, r *http.Request) {
+	req, status, err := carYearRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	data, err := getYearData(req.Car, req.Year, req.Driver, requestLocale(r))
+	if err != nil {
+		log.Println("Error retrieving year overview: " + err.Error())
+		http.Error(w, "Error retrieving year overview", http.StatusInternalServerError)
+		return
+	}
+
+	err = yearTemplate[data.Locale].Execute(w, data)
+	if err != nil {
+		log.Println("Error while executing template: " + err.Error())
+	}
+}
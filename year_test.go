@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetYearData(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	data, err := getYearData(Car{Id: 1, Model: "3", Name: "Tesla"}, 2021, Driver{}, defaultLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data.Months) != 12 || data.Months[2].Number != 3 || data.Months[2].Name != "Mars" {
+		t.Fatalf("Expected the months of the year, got %+v", data.Months)
+	}
+
+	february := data.Months[1]
+	if february.TotalDistanceString != "20.0" || february.BusinessDistanceString != "20.0" || february.Unclassified {
+		t.Errorf("Unexpected totals of February: %+v", february)
+	}
+
+	march := data.Months[2]
+	for _, c := range []struct{ name, got, expected string }{
+		{"distance", march.TotalDistanceString, "110.5"},
+		{"business distance", march.BusinessDistanceString, "20.0"},
+		{"private distance", march.PrivateDistanceString, "5.0"},
+		{"unclassified distance", march.UnclassifiedDistanceString, "85.5"},
+		{"duration", march.TotalDurationString, "2:55"},
+		{"business duration", march.BusinessDurationString, "0:30"},
+		{"private duration", march.PrivateDurationString, "0:20"},
+		{"unclassified duration", march.UnclassifiedDurationString, "2:05"},
+	} {
+		if c.got != c.expected {
+			t.Errorf("Expected the %s of March to be %s, got %s", c.name, c.expected, c.got)
+		}
+	}
+
+	if !march.Unclassified || data.UnclassifiedMonths != 1 {
+		t.Errorf("Expected March to be the only month with unclassified drives, got %d", data.UnclassifiedMonths)
+	}
+
+	if data.Months[3].TotalDistanceString != "0.0" || data.Months[3].Unclassified {
+		t.Errorf("Expected April to be empty, got %+v", data.Months[3])
+	}
+
+	if data.Total.TotalDistanceString != "130.5" || data.Total.BusinessDistanceString != "40.0" || data.Total.TotalDurationString != "3:25" {
+		t.Errorf("Unexpected totals of the year: %+v", data.Total)
+	}
+}
+
+func TestYearHandler(t *testing.T) {
+	useFixtures(t)
+	useDefaultConfig(t)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/year/1/2021")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "/?car=1&year=2021&month=3") {
+		t.Error("Expected links into the months")
+	}
+
+	if !strings.Contains(body, "Månader med oklassificerade resor: 1") {
+		t.Error("Expected the number of months with unclassified drives")
+	}
+
+	for path, status := range map[string]int{"/year/9/2021": http.StatusNotFound, "/year/1/x": http.StatusBadRequest, "/year/1/2021?driver=9": http.StatusBadRequest} {
+		if w := get(path); w.Code != status {
+			t.Errorf("Expected status %d from %s, got %d", status, path, w.Code)
+		}
+	}
+
+	// the links lead to the month:
+	body = get("/?car=1&year=2021&month=2").Body.String()
+	if !strings.Contains(body, `<option selected value="2">`) || !strings.Contains(body, `name="drive" value="1"`) || strings.Contains(body, `name="drive" value="2"`) {
+		t.Error("Expected the journal of February")
+	}
+}